@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	AutoIncrementKeep  = "keep"
+	AutoIncrementReset = "reset"
+	AutoIncrementDrop  = "drop"
+)
+
+// archiveEngines and archiveRowFormats are the values -archive-engine and
+// -archive-row-format accept, since they are written into the DDL as they
+// are. The map values are the spelling used in the statement.
+var (
+	archiveEngines = map[string]string{
+		"innodb": "InnoDB", "myisam": "MyISAM", "archive": "ARCHIVE", "aria": "Aria", "rocksdb": "ROCKSDB",
+	}
+	archiveRowFormats = map[string]string{
+		"default": "DEFAULT", "dynamic": "DYNAMIC", "fixed": "FIXED", "compressed": "COMPRESSED",
+		"redundant": "REDUNDANT", "compact": "COMPACT", "page": "PAGE",
+	}
+)
+
+// validateArchiveOptions checks the -archive-* flags and normalises the
+// engine and row format names.
+func validateArchiveOptions(config *Config) error {
+	switch config.ArchiveAutoIncrement {
+	case AutoIncrementKeep, AutoIncrementReset, AutoIncrementDrop:
+	default:
+		return fmt.Errorf("invalid -archive-auto-increment %q (want keep, reset or drop)", config.ArchiveAutoIncrement)
+	}
+	if config.ArchiveEngine != "" {
+		engine, ok := archiveEngines[strings.ToLower(config.ArchiveEngine)]
+		if !ok {
+			return fmt.Errorf("invalid -archive-engine %q (want InnoDB, MyISAM, ARCHIVE, Aria or RocksDB)", config.ArchiveEngine)
+		}
+		config.ArchiveEngine = engine
+	}
+	if config.ArchiveRowFormat != "" {
+		rowFormat, ok := archiveRowFormats[strings.ToLower(config.ArchiveRowFormat)]
+		if !ok {
+			return fmt.Errorf("invalid -archive-row-format %q (want DEFAULT, DYNAMIC, FIXED, COMPRESSED, REDUNDANT, COMPACT or PAGE)", config.ArchiveRowFormat)
+		}
+		config.ArchiveRowFormat = rowFormat
+	}
+	if config.ArchiveKeyBlockSize < 0 {
+		return fmt.Errorf("invalid -archive-key-block-size %d", config.ArchiveKeyBlockSize)
+	}
+	return nil
+}
+
+// adaptArchiveTable applies the -archive-* options to the CREATE TABLE
+// statement of the archive table. It returns the rewritten statement and a
+// description of each change for the log.
+func adaptArchiveTable(createStmt string, config *Config) (string, []string, error) {
+	if err := validateArchiveOptions(config); err != nil {
+		return "", nil, err
+	}
+	table, err := parseCreateTable(createStmt)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var changes []string
+	engine := config.ArchiveEngine
+	archiveEngine := engine == "ARCHIVE"
+	autoIncrement := config.ArchiveAutoIncrement
+
+	autoIncColumn := ""
+	for _, def := range table.Definitions {
+		if def.Kind == ColumnDefinition && def.HasAttribute("AUTO_INCREMENT") {
+			autoIncColumn = def.Name
+		}
+	}
+
+	if autoIncrement != AutoIncrementKeep {
+		if _, ok := table.Option("AUTO_INCREMENT"); ok {
+			table.RemoveOption("AUTO_INCREMENT")
+			changes = append(changes, "reset AUTO_INCREMENT counter")
+		}
+	}
+	if autoIncrement == AutoIncrementDrop && autoIncColumn != "" {
+		table.Column(autoIncColumn).RemoveAttribute("AUTO_INCREMENT")
+		changes = append(changes, fmt.Sprintf("dropped AUTO_INCREMENT from column %s", autoIncColumn))
+		autoIncColumn = ""
+	}
+
+	// ARCHIVE tables support neither foreign keys nor indexes, except for a
+	// single index on the AUTO_INCREMENT column
+	if config.ArchiveDropForeignKeys || archiveEngine {
+		for _, def := range table.RemoveDefinitions(func(d *TableDefinition) bool { return d.Kind == ForeignKeyDefinition }) {
+			changes = append(changes, fmt.Sprintf("dropped foreign key %s", def.Name))
+		}
+	}
+
+	if config.ArchiveDropIndexes || archiveEngine {
+		// An AUTO_INCREMENT column must stay the first column of some index
+		keepIndex := autoIncrementIndex(table, autoIncColumn)
+
+		removed := table.RemoveDefinitions(func(d *TableDefinition) bool {
+			if d == keepIndex {
+				return false
+			}
+			switch d.Kind {
+			case IndexDefinition, UniqueIndexDefinition, FulltextIndexDefinition, SpatialIndexDefinition:
+				return true
+			case PrimaryKeyDefinition:
+				return archiveEngine
+			}
+			return false
+		})
+		for _, def := range removed {
+			changes = append(changes, fmt.Sprintf("dropped index %s", indexName(def)))
+		}
+
+		// ARCHIVE only indexes the AUTO_INCREMENT column on its own, so a
+		// composite key that starts with it is narrowed to that column
+		if archiveEngine && keepIndex != nil && len(keepIndex.KeyColumns()) > 1 {
+			index, err := singleColumnIndex(autoIncColumn)
+			if err != nil {
+				return "", nil, err
+			}
+			table.RemoveDefinitions(func(d *TableDefinition) bool { return d == keepIndex })
+			table.Definitions = append(table.Definitions, index)
+			changes = append(changes, fmt.Sprintf("replaced index %s on (%s) with an index on %s", indexName(keepIndex), strings.Join(keepIndex.KeyColumns(), ", "), autoIncColumn))
+		}
+	}
+
+	if engine != "" {
+		if current, _ := table.Option("ENGINE"); !strings.EqualFold(current, engine) {
+			table.SetOption("ENGINE", engine)
+			changes = append(changes, fmt.Sprintf("changed engine from %s to %s", current, engine))
+			if config.ArchiveRowFormat == "" {
+				// Row formats and key block sizes are engine specific
+				table.RemoveOption("ROW_FORMAT")
+				table.RemoveOption("KEY_BLOCK_SIZE")
+			}
+		}
+	}
+
+	if config.ArchiveRowFormat != "" {
+		table.SetOption("ROW_FORMAT", config.ArchiveRowFormat)
+		changes = append(changes, fmt.Sprintf("set ROW_FORMAT=%s", config.ArchiveRowFormat))
+	}
+	if config.ArchiveKeyBlockSize > 0 {
+		table.SetOption("KEY_BLOCK_SIZE", strconv.Itoa(config.ArchiveKeyBlockSize))
+		changes = append(changes, fmt.Sprintf("set KEY_BLOCK_SIZE=%d", config.ArchiveKeyBlockSize))
+	}
+
+	return table.String(), changes, nil
+}
+
+// autoIncrementIndex returns the index that keeps column usable as an
+// AUTO_INCREMENT column, preferring the primary key, or nil.
+func autoIncrementIndex(table *CreateTable, column string) *TableDefinition {
+	if column == "" {
+		return nil
+	}
+
+	var candidate *TableDefinition
+	for _, def := range table.Definitions {
+		switch def.Kind {
+		case PrimaryKeyDefinition, IndexDefinition, UniqueIndexDefinition:
+			columns := def.KeyColumns()
+			if len(columns) == 0 || !strings.EqualFold(columns[0], column) {
+				continue
+			}
+			if def.Kind == PrimaryKeyDefinition {
+				return def
+			}
+			if candidate == nil {
+				candidate = def
+			}
+		}
+	}
+	return candidate
+}
+
+func indexName(def *TableDefinition) string {
+	if def.Kind == PrimaryKeyDefinition {
+		return "PRIMARY"
+	}
+	return def.Name
+}
+
+// singleColumnIndex returns the definition KEY `column` (`column`).
+func singleColumnIndex(column string) (*TableDefinition, error) {
+	tokens, err := tokenizeSQL(fmt.Sprintf("KEY %s (%s)", quoteIdentifier(column), quoteIdentifier(column)))
+	if err != nil {
+		return nil, err
+	}
+	return parseDefinition(tokens)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const ordersCreateTable = "CREATE TABLE `orders` (\n" +
+	"  `id` bigint NOT NULL AUTO_INCREMENT,\n" +
+	"  `customer_id` int NOT NULL,\n" +
+	"  `created_at` datetime NOT NULL,\n" +
+	"  PRIMARY KEY (`id`,`created_at`),\n" +
+	"  KEY `idx_created` (`created_at`),\n" +
+	"  CONSTRAINT `fk_customer` FOREIGN KEY (`customer_id`) REFERENCES `customers` (`id`)\n" +
+	") ENGINE=InnoDB AUTO_INCREMENT=42 DEFAULT CHARSET=utf8mb4 ROW_FORMAT=DYNAMIC"
+
+func TestAdaptArchiveTable(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		want    []string
+		notWant []string
+	}{
+		{
+			name:   "unchanged",
+			config: Config{},
+			want:   []string{"PRIMARY KEY (`id`,`created_at`)", "CONSTRAINT `fk_customer`", "AUTO_INCREMENT=42", "ENGINE=InnoDB"},
+		},
+		{
+			name:    "drop foreign keys and indexes",
+			config:  Config{ArchiveDropForeignKeys: true, ArchiveDropIndexes: true},
+			want:    []string{"PRIMARY KEY (`id`,`created_at`)", "`id` bigint NOT NULL AUTO_INCREMENT"},
+			notWant: []string{"fk_customer", "idx_created"},
+		},
+		{
+			name:    "reset auto increment",
+			config:  Config{ArchiveAutoIncrement: AutoIncrementReset},
+			want:    []string{"`id` bigint NOT NULL AUTO_INCREMENT,"},
+			notWant: []string{"AUTO_INCREMENT=42"},
+		},
+		{
+			name:    "drop auto increment",
+			config:  Config{ArchiveAutoIncrement: AutoIncrementDrop},
+			want:    []string{"`id` bigint NOT NULL,"},
+			notWant: []string{"AUTO_INCREMENT"},
+		},
+		{
+			name:    "compressed",
+			config:  Config{ArchiveRowFormat: "compressed", ArchiveKeyBlockSize: 8},
+			want:    []string{"ROW_FORMAT=COMPRESSED", "KEY_BLOCK_SIZE=8"},
+			notWant: []string{"DYNAMIC"},
+		},
+		{
+			// ARCHIVE allows one index, on the AUTO_INCREMENT column alone
+			name:    "archive engine",
+			config:  Config{ArchiveEngine: "archive"},
+			want:    []string{"ENGINE=ARCHIVE", "KEY `id` (`id`)\n)"},
+			notWant: []string{"PRIMARY KEY", "idx_created", "fk_customer", "ROW_FORMAT"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			if config.ArchiveAutoIncrement == "" {
+				config.ArchiveAutoIncrement = AutoIncrementKeep
+			}
+			got, changes, err := adaptArchiveTable(ordersCreateTable, &config)
+			if err != nil {
+				t.Fatalf("adaptArchiveTable: %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("statement lacks %q:\n%s", want, got)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(got, notWant) {
+					t.Errorf("statement still has %q:\n%s", notWant, got)
+				}
+			}
+			if len(tt.want) > 0 && len(tt.notWant) > 0 && len(changes) == 0 {
+				t.Error("no changes reported")
+			}
+			if _, err := parseCreateTable(got); err != nil {
+				t.Errorf("adapted statement does not parse: %v", err)
+			}
+		})
+	}
+}
+
+func TestAdaptArchiveTableArchiveEngineKeepsSingleColumnIndex(t *testing.T) {
+	createStmt := "CREATE TABLE `events` (\n" +
+		"  `id` int NOT NULL AUTO_INCREMENT,\n" +
+		"  `body` text,\n" +
+		"  PRIMARY KEY (`id`),\n" +
+		"  KEY `idx_body` (`body`(10))\n" +
+		") ENGINE=InnoDB"
+	got, _, err := adaptArchiveTable(createStmt, &Config{ArchiveAutoIncrement: AutoIncrementKeep, ArchiveEngine: "ARCHIVE"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got, "PRIMARY KEY (`id`)") || strings.Contains(got, "idx_body") {
+		t.Errorf("ARCHIVE table keeps the wrong indexes:\n%s", got)
+	}
+}
+
+func TestValidateArchiveOptions(t *testing.T) {
+	config := &Config{ArchiveAutoIncrement: AutoIncrementKeep, ArchiveEngine: "innodb", ArchiveRowFormat: "Compressed"}
+	if err := validateArchiveOptions(config); err != nil {
+		t.Fatal(err)
+	}
+	if config.ArchiveEngine != "InnoDB" || config.ArchiveRowFormat != "COMPRESSED" {
+		t.Errorf("names normalised to %s, %s", config.ArchiveEngine, config.ArchiveRowFormat)
+	}
+
+	for _, bad := range []Config{
+		{ArchiveAutoIncrement: "sometimes"},
+		{ArchiveAutoIncrement: AutoIncrementKeep, ArchiveEngine: "InnoDB COMMENT='x'"},
+		{ArchiveAutoIncrement: AutoIncrementKeep, ArchiveEngine: "BLACKHOLE"},
+		{ArchiveAutoIncrement: AutoIncrementKeep, ArchiveRowFormat: "DYNAMIC; DROP TABLE t"},
+		{ArchiveAutoIncrement: AutoIncrementKeep, ArchiveKeyBlockSize: -1},
+	} {
+		if err := validateArchiveOptions(&bad); err == nil {
+			t.Errorf("validateArchiveOptions accepted %+v", bad)
+		}
+	}
+}
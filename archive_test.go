@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testLogger discards the log file output that NewLogger would write to the
+// working directory.
+func testLogger() *Logger {
+	return &Logger{Logger: log.New(io.Discard, "", 0)}
+}
+
+// openTestDB creates an empty SQLite database file in a temporary directory
+// and opens it through the SQLite dialect.
+func openTestDB(t *testing.T) (*sql.DB, *Config) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "test.db")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	config := &Config{
+		Driver:         DriverSQLite,
+		Database:       path,
+		Table:          "sms_log",
+		DaysToKeep:     30,
+		ExportPath:     t.TempDir(),
+		ConnectTimeout: 5 * time.Second,
+		PartitionMode:  PartitionModeOff,
+		CSV:            defaultCSVOptions(),
+	}
+	db, err := (&SQLiteDialect{}).Open(context.Background(), config, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, config
+}
+
+func mustExec(t *testing.T, db *sql.DB, query string, args ...any) {
+	t.Helper()
+	if _, err := db.Exec(query, args...); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+}
+
+// seedSMSLog creates sms_log with one row per entry of ages, each created
+// that many days ago.
+func seedSMSLog(t *testing.T, db *sql.DB, ages ...int) {
+	t.Helper()
+
+	mustExec(t, db, `CREATE TABLE sms_log (
+		id INTEGER PRIMARY KEY,
+		msisdn TEXT NOT NULL,
+		message TEXT,
+		payload BLOB,
+		created_at DATETIME NOT NULL
+	)`)
+	mustExec(t, db, "CREATE INDEX idx_created_at ON sms_log (created_at)")
+	mustExec(t, db, `CREATE UNIQUE INDEX "idx msisdn" ON sms_log (msisdn, id)`)
+
+	now := time.Now().UTC()
+	for i, age := range ages {
+		createdAt := now.AddDate(0, 0, -age).Format(sqliteTimeLayout)
+		mustExec(t, db, "INSERT INTO sms_log (msisdn, message, payload, created_at) VALUES (?, ?, ?, ?)",
+			fmt.Sprintf("2340800000%04d", i), fmt.Sprintf("message %d, it's %d days old", i, age), []byte{0, byte(i), 0xff}, createdAt)
+	}
+}
+
+func tableCount(t *testing.T, db *sql.DB, table string) int64 {
+	t.Helper()
+	count, err := getTableCount(context.Background(), db, &SQLiteDialect{}, table)
+	if err != nil {
+		t.Fatalf("counting %s: %v", table, err)
+	}
+	return count
+}
+
+func tableExists(t *testing.T, db *sql.DB, table string) bool {
+	t.Helper()
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	return count > 0
+}
+
+// ageRange returns the age in days of the oldest and newest rows of table.
+func ageRange(t *testing.T, db *sql.DB, table string) (oldest, newest float64) {
+	t.Helper()
+	query := fmt.Sprintf(`SELECT julianday('now') - julianday(MIN(created_at)), julianday('now') - julianday(MAX(created_at)) FROM %q`, table)
+	if err := db.QueryRow(query).Scan(&oldest, &newest); err != nil {
+		t.Fatal(err)
+	}
+	return oldest, newest
+}
+
+func exportFile(t *testing.T, config *Config, table, ext string) string {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(config.ExportPath, table+"_*."+ext))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 {
+		t.Fatalf("expected one %s export of %s, found %v", ext, table, matches)
+	}
+	return matches[0]
+}
+
+func archiveName(table string) string {
+	return fmt.Sprintf("%s_archive_%s", table, time.Now().Format("20060102"))
+}
+
+func TestArchiveTable(t *testing.T) {
+	for _, chunkSize := range []int{0, 2} {
+		t.Run(fmt.Sprintf("chunk-size=%d", chunkSize), func(t *testing.T) {
+			db, config := openTestDB(t)
+			config.ChunkSize = chunkSize
+			seedSMSLog(t, db, 400, 200, 100, 45, 31, 29, 10, 1)
+
+			result, err := archiveTable(context.Background(), db, &SQLiteDialect{}, config, testLogger())
+			if err != nil {
+				t.Fatalf("archiveTable: %v", err)
+			}
+
+			archive := archiveName("sms_log")
+			if result.Step != "done" || result.ArchiveTable != archive {
+				t.Errorf("result step %q archive table %q, want done and %s", result.Step, result.ArchiveTable, archive)
+			}
+			if result.ArchiveCount != 5 || result.KeepCount != 3 {
+				t.Errorf("result counted %d to archive and %d to keep, want 5 and 3", result.ArchiveCount, result.KeepCount)
+			}
+
+			if got := tableCount(t, db, "sms_log"); got != 3 {
+				t.Errorf("sms_log has %d rows, want 3", got)
+			}
+			if got := tableCount(t, db, archive); got != 5 {
+				t.Errorf("%s has %d rows, want 5", archive, got)
+			}
+			if _, newest := ageRange(t, db, archive); newest < 30 {
+				t.Errorf("%s holds a row %.1f days old, want only rows older than 30 days", archive, newest)
+			}
+			if oldest, _ := ageRange(t, db, "sms_log"); oldest > 30 {
+				t.Errorf("sms_log holds a row %.1f days old, want only rows newer than 30 days", oldest)
+			}
+			if tableExists(t, db, "sms_log_"+time.Now().Format("20060102")) {
+				t.Error("intermediate table was left behind")
+			}
+
+			// The live table keeps its original indexes
+			var indexes int
+			if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND tbl_name = 'sms_log' AND sql IS NOT NULL").Scan(&indexes); err != nil {
+				t.Fatal(err)
+			}
+			if indexes != 2 {
+				t.Errorf("sms_log has %d indexes, want 2", indexes)
+			}
+		})
+	}
+}
+
+func TestArchiveTableDryRun(t *testing.T) {
+	db, config := openTestDB(t)
+	config.DryRun = true
+	seedSMSLog(t, db, 100, 50, 1)
+
+	result, err := archiveTable(context.Background(), db, &SQLiteDialect{}, config, testLogger())
+	if err != nil {
+		t.Fatalf("archiveTable: %v", err)
+	}
+	if result.ArchiveCount != 2 || result.KeepCount != 1 {
+		t.Errorf("result counted %d to archive and %d to keep, want 2 and 1", result.ArchiveCount, result.KeepCount)
+	}
+	if got := tableCount(t, db, "sms_log"); got != 3 {
+		t.Errorf("sms_log has %d rows after a dry run, want 3", got)
+	}
+	if tableExists(t, db, archiveName("sms_log")) {
+		t.Error("dry run created the archive table")
+	}
+}
+
+func TestArchiveTableNothingToArchive(t *testing.T) {
+	db, config := openTestDB(t)
+	seedSMSLog(t, db, 10, 1)
+
+	result, err := archiveTable(context.Background(), db, &SQLiteDialect{}, config, testLogger())
+	if err != nil {
+		t.Fatalf("archiveTable: %v", err)
+	}
+	if result.ArchiveCount != 0 {
+		t.Errorf("result counted %d to archive, want 0", result.ArchiveCount)
+	}
+	if tableExists(t, db, archiveName("sms_log")) {
+		t.Error("archive table created with nothing to archive")
+	}
+}
+
+func TestArchiveTableInterrupted(t *testing.T) {
+	db, config := openTestDB(t)
+	seedSMSLog(t, db, 100, 50, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := archiveTable(ctx, db, &SQLiteDialect{}, config, testLogger()); err == nil {
+		t.Fatal("archiveTable succeeded with a cancelled context")
+	}
+	if got := tableCount(t, db, "sms_log"); got != 3 {
+		t.Errorf("sms_log has %d rows after an interrupted run, want 3", got)
+	}
+}
+
+func TestArchiveTableDeleteFailed(t *testing.T) {
+	db, config := openTestDB(t)
+	seedSMSLog(t, db, 100, 50, 1)
+	mustExec(t, db, "CREATE TRIGGER no_delete BEFORE DELETE ON sms_log BEGIN SELECT RAISE(ABORT, 'deletes are disabled'); END")
+
+	result, err := archiveTable(context.Background(), db, &SQLiteDialect{}, config, testLogger())
+	if err == nil || !strings.Contains(err.Error(), "deletes are disabled") {
+		t.Fatalf("archiveTable error = %v", err)
+	}
+	if !result.RolledBack {
+		t.Error("failed delete was not rolled back")
+	}
+	if tableExists(t, db, "sms_log_"+time.Now().Format("20060102")) {
+		t.Error("new table was left behind")
+	}
+	if got := tableCount(t, db, "sms_log"); got != 3 {
+		t.Errorf("sms_log has %d rows, want 3", got)
+	}
+}
+
+func TestArchiveTableExports(t *testing.T) {
+	db, config := openTestDB(t)
+	config.ExportSQL = true
+	config.ExportCSV = true
+	seedSMSLog(t, db, 100, 50, 40, 1)
+
+	if _, err := archiveTable(context.Background(), db, &SQLiteDialect{}, config, testLogger()); err != nil {
+		t.Fatalf("archiveTable: %v", err)
+	}
+	archive := archiveName("sms_log")
+
+	t.Run("sql", func(t *testing.T) {
+		dump, err := os.ReadFile(exportFile(t, config, archive, "sql"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(dump), "-- Total rows exported: 3") {
+			t.Errorf("SQL export does not report 3 rows:\n%s", dump)
+		}
+
+		// Loading the dump into an empty database recreates the archive
+		restored, _ := openTestDB(t)
+		mustExec(t, restored, string(dump))
+		if got := tableCount(t, restored, archive); got != 3 {
+			t.Errorf("restored %s has %d rows, want 3", archive, got)
+		}
+
+		query := fmt.Sprintf(`SELECT id, msisdn, message, payload, created_at FROM %q ORDER BY id`, archive)
+		want := queryAll(t, db, query)
+		if got := queryAll(t, restored, query); got != want {
+			t.Errorf("restored rows differ:\ngot  %s\nwant %s", got, want)
+		}
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		file, err := os.Open(exportFile(t, config, archive, "csv"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+
+		records, err := csv.NewReader(file).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 4 {
+			t.Fatalf("CSV export has %d records, want a header and 3 rows", len(records))
+		}
+		if got := strings.Join(records[0], ","); got != "id,msisdn,message,payload,created_at" {
+			t.Errorf("CSV header is %q", got)
+		}
+		if got := records[1][2]; got != "message 0, it's 100 days old" {
+			t.Errorf("first CSV message is %q", got)
+		}
+	})
+}
+
+// queryAll renders every row returned by query, for comparing tables.
+func queryAll(t *testing.T, db *sql.DB, query string) string {
+	t.Helper()
+	rows, err := db.Query(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	for rows.Next() {
+		values := make([]any, len(columns))
+		pointers := make([]any, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(&out, "%v\n", values)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// foreignKey is a foreign key from Table to ReferencedTable.
+type foreignKey struct {
+	Name              string
+	Table             string
+	Columns           []string
+	ReferencedTable   string
+	ReferencedColumns []string
+}
+
+// dependentTable is a table whose rows are archived together with the rows
+// of the parent tables they reference.
+type dependentTable struct {
+	Name         string
+	ArchiveTable string
+	// Count is the number of matching rows when the cascade was planned
+	Count int64
+
+	references []foreignKey
+	// predicate selects the rows that reference archived parent rows
+	predicate string
+	args      []any
+}
+
+// DependentResult describes the rows archived from one dependent table.
+type DependentResult struct {
+	Table        string
+	ArchiveTable string
+	ArchiveCount int64
+}
+
+// planCascade discovers every table that references root, directly or
+// through other dependent tables, and returns them parents first with the
+// predicate selecting the rows that move with the archived root rows.
+func planCascade(ctx context.Context, db *sql.DB, dialect Dialect, root, dateColumn string, cutoffDate time.Time, suffix string, logger *Logger) ([]*dependentTable, error) {
+	tables := map[string]*dependentTable{}
+	queue := []string{root}
+	for len(queue) > 0 {
+		parent := queue[0]
+		queue = queue[1:]
+
+		fks, err := referencingForeignKeys(ctx, db, parent)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read foreign keys referencing %s: %v", parent, err)
+		}
+
+		for _, fk := range fks {
+			if fk.Table == parent {
+				logger.Warning("Ignoring self-referencing foreign key %s on %s", fk.Name, fk.Table)
+				continue
+			}
+			if fk.Table == root {
+				return nil, fmt.Errorf("foreign key cycle: %s references %s", root, parent)
+			}
+			table, ok := tables[fk.Table]
+			if !ok {
+				table = &dependentTable{
+					Name:         fk.Table,
+					ArchiveTable: suffixedName(fk.Table, "archive_"+suffix),
+				}
+				tables[fk.Table] = table
+				queue = append(queue, fk.Table)
+			}
+			table.references = append(table.references, fk)
+		}
+	}
+
+	ordered, err := sortDependents(root, tables)
+	if err != nil {
+		return nil, err
+	}
+
+	setPredicates(root, quoteIdentifier(dateColumn)+" < ?", []any{dialect.TimeArg(cutoffDate)}, ordered)
+
+	for _, table := range ordered {
+		query := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s", quoteIdentifier(table.Name), table.predicate)
+		if err := db.QueryRowContext(ctx, query, table.args...).Scan(&table.Count); err != nil {
+			return nil, fmt.Errorf("failed to count dependent rows in %s: %v", table.Name, err)
+		}
+	}
+
+	return ordered, nil
+}
+
+// setPredicates sets the predicate of each table in ordered, which must come
+// parents first, from the predicates of the tables it references.
+func setPredicates(root, rootPredicate string, rootArgs []any, ordered []*dependentTable) {
+	predicates := map[string]string{root: rootPredicate}
+	args := map[string][]any{root: rootArgs}
+	for _, table := range ordered {
+		var conditions []string
+		table.args = nil
+		for _, fk := range table.references {
+			conditions = append(conditions, fmt.Sprintf("(%s) IN (SELECT %s FROM %s WHERE %s)",
+				quoteColumns(fk.Columns), quoteColumns(fk.ReferencedColumns), quoteIdentifier(fk.ReferencedTable), predicates[fk.ReferencedTable]))
+			table.args = append(table.args, args[fk.ReferencedTable]...)
+		}
+		table.predicate = strings.Join(conditions, " OR ")
+		predicates[table.Name] = table.predicate
+		args[table.Name] = table.args
+	}
+}
+
+// sortDependents orders tables so that every table comes after all the
+// tables it references, or fails if the foreign keys form a cycle.
+func sortDependents(root string, tables map[string]*dependentTable) ([]*dependentTable, error) {
+	// Walk the tables by name so the order does not depend on map iteration
+	names := make([]string, 0, len(tables))
+	for name := range tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pending := make(map[string]int, len(tables))
+	children := make(map[string][]string)
+	for _, name := range names {
+		table := tables[name]
+		parents := map[string]bool{}
+		for _, fk := range table.references {
+			if !parents[fk.ReferencedTable] {
+				parents[fk.ReferencedTable] = true
+				children[fk.ReferencedTable] = append(children[fk.ReferencedTable], name)
+			}
+		}
+		pending[name] = len(parents)
+	}
+
+	var ordered []*dependentTable
+	ready := []string{root}
+	for len(ready) > 0 {
+		name := ready[0]
+		ready = ready[1:]
+		for _, child := range children[name] {
+			pending[child]--
+			if pending[child] == 0 {
+				ordered = append(ordered, tables[child])
+				ready = append(ready, child)
+			}
+		}
+	}
+
+	if len(ordered) != len(tables) {
+		var cyclic []string
+		for name, n := range pending {
+			if n > 0 {
+				cyclic = append(cyclic, name)
+			}
+		}
+		return nil, fmt.Errorf("foreign key cycle among tables: %s", strings.Join(cyclic, ", "))
+	}
+
+	return ordered, nil
+}
+
+func referencingForeignKeys(ctx context.Context, db *sql.DB, table string) ([]foreignKey, error) {
+	query := `SELECT CONSTRAINT_NAME, TABLE_NAME, COLUMN_NAME, REFERENCED_COLUMN_NAME
+		FROM INFORMATION_SCHEMA.KEY_COLUMN_USAGE
+		WHERE REFERENCED_TABLE_SCHEMA = DATABASE() AND TABLE_SCHEMA = DATABASE() AND REFERENCED_TABLE_NAME = ?
+		ORDER BY TABLE_NAME, CONSTRAINT_NAME, ORDINAL_POSITION`
+	rows, err := db.QueryContext(ctx, query, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var fks []foreignKey
+	for rows.Next() {
+		var name, childTable, column, referencedColumn string
+		if err := rows.Scan(&name, &childTable, &column, &referencedColumn); err != nil {
+			return nil, err
+		}
+		if n := len(fks); n > 0 && fks[n-1].Name == name && fks[n-1].Table == childTable {
+			fks[n-1].Columns = append(fks[n-1].Columns, column)
+			fks[n-1].ReferencedColumns = append(fks[n-1].ReferencedColumns, referencedColumn)
+			continue
+		}
+		fks = append(fks, foreignKey{
+			Name:              name,
+			Table:             childTable,
+			Columns:           []string{column},
+			ReferencedTable:   table,
+			ReferencedColumns: []string{referencedColumn},
+		})
+	}
+
+	return fks, rows.Err()
+}
+
+// archiveDependents moves the rows referencing archived parent rows into an
+// archive table per dependent table. Tables are processed children first so
+// no remaining row ever references a deleted one, and each chunk is copied
+// and deleted in a single transaction so rows inserted concurrently are
+// never deleted without being archived.
+func archiveDependents(ctx context.Context, db *sql.DB, dialect Dialect, tables []*dependentTable, suffix string, config *Config, throttler *Throttler, logger *Logger) ([]DependentResult, error) {
+	// Archive copies must not reference live parents, or they would block
+	// the parent deletes that follow
+	childConfig := *config
+	childConfig.ArchiveDropForeignKeys = true
+
+	var results []DependentResult
+	for i := len(tables) - 1; i >= 0; i-- {
+		table := tables[i]
+		if err := throttler.Wait(ctx); err != nil {
+			return results, err
+		}
+
+		if err := createDependentArchive(ctx, db, table, suffix, &childConfig, logger); err != nil {
+			return results, err
+		}
+
+		moved, err := moveDependentRows(ctx, db, dialect, table, config.ChunkSize, throttler, logger)
+		if err != nil {
+			if moved > 0 {
+				// Committed ranges only exist in the archive table now, so
+				// it is restored on rollback like a complete one
+				results = append(results, DependentResult{Table: table.Name, ArchiveTable: table.ArchiveTable, ArchiveCount: moved})
+			} else {
+				logger.Warning("Dropping %s after failed move", table.ArchiveTable)
+				dropSQL := fmt.Sprintf("DROP TABLE IF EXISTS %s", quoteIdentifier(table.ArchiveTable))
+				if dropErr := executeSQL(context.WithoutCancel(ctx), db, dropSQL, logger); dropErr != nil {
+					logger.Error("Failed to drop %s: %v", table.ArchiveTable, dropErr)
+				}
+			}
+			return results, fmt.Errorf("failed to archive dependent rows from %s: %v", table.Name, err)
+		}
+
+		logger.Info("Moved %d dependent rows from %s to %s", moved, table.Name, table.ArchiveTable)
+		results = append(results, DependentResult{Table: table.Name, ArchiveTable: table.ArchiveTable, ArchiveCount: moved})
+	}
+
+	return results, nil
+}
+
+// createDependentArchive creates the archive table of a dependent table. An
+// empty archive table left behind by an interrupted run is reused; one that
+// holds rows is never written to.
+func createDependentArchive(ctx context.Context, db *sql.DB, table *dependentTable, suffix string, config *Config, logger *Logger) error {
+	var exists int
+	query := `SELECT COUNT(*) FROM INFORMATION_SCHEMA.TABLES WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?`
+	if err := db.QueryRowContext(ctx, query, table.ArchiveTable).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to look up %s: %v", table.ArchiveTable, err)
+	}
+	if exists > 0 {
+		var count int64
+		query := fmt.Sprintf("SELECT COUNT(*) FROM %s", quoteIdentifier(table.ArchiveTable))
+		if err := db.QueryRowContext(ctx, query).Scan(&count); err != nil {
+			return fmt.Errorf("failed to count records in %s: %v", table.ArchiveTable, err)
+		}
+		if count > 0 {
+			return fmt.Errorf("%s already exists and holds %d records from an earlier run", table.ArchiveTable, count)
+		}
+		logger.Warning("Reusing empty %s left by an earlier run", table.ArchiveTable)
+		return nil
+	}
+
+	createStmt, err := getCreateTable(ctx, db, table.Name)
+	if err != nil {
+		return fmt.Errorf("failed to get CREATE TABLE for %s: %v", table.Name, err)
+	}
+	createStmt, err = modifyCreateStatement(createStmt, table.Name, table.ArchiveTable, suffix)
+	if err != nil {
+		return fmt.Errorf("failed to rewrite CREATE TABLE for %s: %v", table.Name, err)
+	}
+	createStmt, _, err = adaptArchiveTable(createStmt, config)
+	if err != nil {
+		return fmt.Errorf("failed to adapt archive table for %s: %v", table.Name, err)
+	}
+	if err := executeSQL(ctx, db, createStmt, logger); err != nil {
+		return fmt.Errorf("failed to create %s: %v", table.ArchiveTable, err)
+	}
+	return nil
+}
+
+// restoreDependents undoes archiveDependents: the rows of each archive table
+// are moved back to their dependent table, parents first, and the archive
+// table is dropped.
+func restoreDependents(ctx context.Context, db *sql.DB, results []DependentResult, logger *Logger) error {
+	for i := len(results) - 1; i >= 0; i-- {
+		dependent := results[i]
+		logger.Warning("Rolling back: moving %d records from %s back to %s", dependent.ArchiveCount, dependent.ArchiveTable, dependent.Table)
+		query := fmt.Sprintf("INSERT INTO %s SELECT * FROM %s", quoteIdentifier(dependent.Table), quoteIdentifier(dependent.ArchiveTable))
+		if err := executeSQL(ctx, db, query, logger); err != nil {
+			return fmt.Errorf("failed to restore %s: %v", dependent.Table, err)
+		}
+		dropSQL := fmt.Sprintf("DROP TABLE %s", quoteIdentifier(dependent.ArchiveTable))
+		if err := executeSQL(ctx, db, dropSQL, logger); err != nil {
+			return fmt.Errorf("failed to drop %s: %v", dependent.ArchiveTable, err)
+		}
+	}
+	return nil
+}
+
+// moveDependentRows moves the rows of one dependent table to its archive
+// table in primary key ranges of chunkSize rows, waiting on the throttler
+// before each one. A table without a single-column primary key, or a run
+// without -chunk-size, moves in one range. The returned count covers the
+// ranges committed before an error.
+func moveDependentRows(ctx context.Context, db *sql.DB, dialect Dialect, table *dependentTable, chunkSize int, throttler *Throttler, logger *Logger) (int64, error) {
+	var pk string
+	if chunkSize > 0 {
+		pkColumns, err := dialect.PrimaryKey(ctx, db, table.Name)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get primary key: %v", err)
+		}
+		if len(pkColumns) == 1 {
+			pk = quoteIdentifier(pkColumns[0])
+		} else {
+			logger.Warning("Table %s has no single-column primary key, moving its rows in one transaction", table.Name)
+		}
+	}
+
+	var lower any
+	var totalRows int64
+	for {
+		if err := throttler.Wait(ctx); err != nil {
+			return totalRows, err
+		}
+
+		// Find the upper bound of the next range; none means this is the last one
+		var upper any
+		if pk != "" {
+			query := fmt.Sprintf("SELECT %s FROM %s WHERE (%s)", pk, quoteIdentifier(table.Name), table.predicate)
+			args := append([]any{}, table.args...)
+			if lower != nil {
+				query += fmt.Sprintf(" AND %s > ?", pk)
+				args = append(args, lower)
+			}
+			query += fmt.Sprintf(" ORDER BY %s LIMIT 1 OFFSET %d", pk, chunkSize-1)
+			err := db.QueryRowContext(ctx, query, args...).Scan(&upper)
+			if err != nil && err != sql.ErrNoRows {
+				return totalRows, err
+			}
+		}
+
+		predicate := "(" + table.predicate + ")"
+		args := append([]any{}, table.args...)
+		if lower != nil {
+			predicate += fmt.Sprintf(" AND %s > ?", pk)
+			args = append(args, lower)
+		}
+		if upper != nil {
+			predicate += fmt.Sprintf(" AND %s <= ?", pk)
+			args = append(args, upper)
+		}
+
+		moved, err := moveDependentRange(ctx, db, dialect, table, predicate, args, logger)
+		if err != nil {
+			return totalRows, err
+		}
+		totalRows += moved
+		logger.Info("Moved %d rows from %s...", totalRows, table.Name)
+
+		if upper == nil {
+			break
+		}
+		lower = upper
+	}
+
+	return totalRows, nil
+}
+
+// moveDependentRange copies and deletes the rows of table matching predicate
+// in a transaction, committing only if both statements touched the same
+// rows. A cancelled ctx kills the running statement and rolls back.
+func moveDependentRange(ctx context.Context, db *sql.DB, dialect Dialect, table *dependentTable, predicate string, args []any, logger *Logger) (int64, error) {
+	var moved int64
+	err := runKillable(ctx, db, dialect, func(runCtx context.Context, conn *sql.Conn) error {
+		tx, err := conn.BeginTx(runCtx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		query := fmt.Sprintf("INSERT INTO %s SELECT * FROM %s WHERE %s", quoteIdentifier(table.ArchiveTable), quoteIdentifier(table.Name), predicate)
+		logger.Info("Executing: %s", truncateSQL(query, 200))
+		result, err := tx.ExecContext(runCtx, query, args...)
+		if err != nil {
+			return err
+		}
+		copied, _ := result.RowsAffected()
+
+		query = fmt.Sprintf("DELETE FROM %s WHERE %s", quoteIdentifier(table.Name), predicate)
+		logger.Info("Executing: %s", truncateSQL(query, 200))
+		result, err = tx.ExecContext(runCtx, query, args...)
+		if err != nil {
+			return err
+		}
+		deleted, _ := result.RowsAffected()
+
+		if copied != deleted {
+			return fmt.Errorf("copied %d rows but delete matched %d", copied, deleted)
+		}
+		if err := ctx.Err(); err != nil {
+			// Cancelled between statements; nothing was killed, so stop here
+			return err
+		}
+
+		moved = copied
+		return tx.Commit()
+	})
+	return moved, err
+}
+
+func quoteColumns(columns []string) string {
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = quoteIdentifier(column)
+	}
+	return strings.Join(quoted, ", ")
+}
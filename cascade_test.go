@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// shopTables returns the dependent tables of orders: order_items and
+// shipments reference orders, item_notes references order_items and
+// shipments references order_items as well.
+func shopTables() map[string]*dependentTable {
+	return map[string]*dependentTable{
+		"item_notes": {Name: "item_notes", references: []foreignKey{
+			{Name: "fk_item", Table: "item_notes", Columns: []string{"item_id"}, ReferencedTable: "order_items", ReferencedColumns: []string{"id"}},
+		}},
+		"shipments": {Name: "shipments", references: []foreignKey{
+			{Name: "fk_order", Table: "shipments", Columns: []string{"order_id"}, ReferencedTable: "orders", ReferencedColumns: []string{"id"}},
+			{Name: "fk_item", Table: "shipments", Columns: []string{"order_id", "item_id"}, ReferencedTable: "order_items", ReferencedColumns: []string{"order_id", "id"}},
+		}},
+		"order_items": {Name: "order_items", references: []foreignKey{
+			{Name: "fk_order", Table: "order_items", Columns: []string{"order_id"}, ReferencedTable: "orders", ReferencedColumns: []string{"id"}},
+		}},
+	}
+}
+
+func tableNames(tables []*dependentTable) []string {
+	var names []string
+	for _, table := range tables {
+		names = append(names, table.Name)
+	}
+	return names
+}
+
+func TestSortDependents(t *testing.T) {
+	ordered, err := sortDependents("orders", shopTables())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tableNames(ordered), []string{"order_items", "item_notes", "shipments"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("order = %v, want %v", got, want)
+	}
+
+	tables := shopTables()
+	tables["order_items"].references = append(tables["order_items"].references,
+		foreignKey{Name: "fk_note", Table: "order_items", Columns: []string{"note_id"}, ReferencedTable: "item_notes", ReferencedColumns: []string{"id"}})
+	_, err = sortDependents("orders", tables)
+	if err == nil || !strings.Contains(err.Error(), "item_notes") || !strings.Contains(err.Error(), "order_items") {
+		t.Errorf("cycle error = %v", err)
+	}
+}
+
+func TestSetPredicates(t *testing.T) {
+	ordered, err := sortDependents("orders", shopTables())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cutoff := "2024-01-01 00:00:00"
+	setPredicates("orders", "`created_at` < ?", []any{cutoff}, ordered)
+
+	items := "(`order_id`) IN (SELECT `id` FROM `orders` WHERE `created_at` < ?)"
+	want := map[string]string{
+		"order_items": items,
+		"item_notes":  "(`item_id`) IN (SELECT `id` FROM `order_items` WHERE " + items + ")",
+		"shipments":   items + " OR (`order_id`, `item_id`) IN (SELECT `order_id`, `id` FROM `order_items` WHERE " + items + ")",
+	}
+	wantArgs := map[string]int{"order_items": 1, "item_notes": 1, "shipments": 2}
+	for _, table := range ordered {
+		if table.predicate != want[table.Name] {
+			t.Errorf("%s predicate:\n got %s\nwant %s", table.Name, table.predicate, want[table.Name])
+		}
+		if len(table.args) != wantArgs[table.Name] {
+			t.Errorf("%s has %d args, want %d", table.Name, len(table.args), wantArgs[table.Name])
+		}
+		for _, arg := range table.args {
+			if arg != cutoff {
+				t.Errorf("%s arg = %v, want %s", table.Name, arg, cutoff)
+			}
+		}
+	}
+}
+
+// seedOrders creates orders with one row per entry of ages and two
+// order_items per order, plus empty archive copies of order_items.
+func seedOrders(t *testing.T, db *sql.DB, ages ...int) {
+	t.Helper()
+	mustExec(t, db, "CREATE TABLE orders (id INTEGER PRIMARY KEY, created_at DATETIME NOT NULL)")
+	mustExec(t, db, "CREATE TABLE order_items (id INTEGER PRIMARY KEY, order_id INTEGER NOT NULL REFERENCES orders (id), sku TEXT)")
+	mustExec(t, db, "CREATE TABLE order_items_archive AS SELECT * FROM order_items WHERE 0")
+
+	now := time.Now().UTC()
+	for i, age := range ages {
+		mustExec(t, db, "INSERT INTO orders (id, created_at) VALUES (?, ?)", i+1, now.AddDate(0, 0, -age).Format(sqliteTimeLayout))
+		mustExec(t, db, "INSERT INTO order_items (order_id, sku) VALUES (?, 'a'), (?, 'b')", i+1, i+1)
+	}
+}
+
+func orderItems() *dependentTable {
+	table := &dependentTable{Name: "order_items", ArchiveTable: "order_items_archive", references: shopTables()["order_items"].references}
+	cutoff := (&SQLiteDialect{}).TimeArg(time.Now().AddDate(0, 0, -30))
+	setPredicates("orders", "`created_at` < ?", []any{cutoff}, []*dependentTable{table})
+	return table
+}
+
+func TestMoveDependentRows(t *testing.T) {
+	for _, chunkSize := range []int{0, 3} {
+		db, _ := openTestDB(t)
+		seedOrders(t, db, 100, 90, 80, 10, 1)
+
+		moved, err := moveDependentRows(context.Background(), db, &SQLiteDialect{}, orderItems(), chunkSize, nil, testLogger())
+		if err != nil {
+			t.Fatalf("chunk size %d: %v", chunkSize, err)
+		}
+		if moved != 6 {
+			t.Errorf("chunk size %d: moved %d rows, want 6", chunkSize, moved)
+		}
+		if got := tableCount(t, db, "order_items"); got != 4 {
+			t.Errorf("chunk size %d: order_items has %d rows, want 4", chunkSize, got)
+		}
+		var stray int
+		if err := db.QueryRow("SELECT COUNT(*) FROM order_items_archive WHERE order_id > 3").Scan(&stray); err != nil || stray != 0 {
+			t.Errorf("chunk size %d: archived %d items of recent orders (%v)", chunkSize, stray, err)
+		}
+	}
+}
+
+func TestMoveDependentRowsCancelled(t *testing.T) {
+	db, _ := openTestDB(t)
+	seedOrders(t, db, 100, 90)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := moveDependentRows(ctx, db, &SQLiteDialect{}, orderItems(), 1, nil, testLogger()); err == nil {
+		t.Fatal("moveDependentRows succeeded with a cancelled context")
+	}
+	if got := tableCount(t, db, "order_items"); got != 4 {
+		t.Errorf("order_items has %d rows after a cancelled move, want 4", got)
+	}
+}
+
+func TestRestoreDependents(t *testing.T) {
+	db, _ := openTestDB(t)
+	seedOrders(t, db, 100, 90, 1)
+	mustExec(t, db, "CREATE TABLE item_notes (id INTEGER PRIMARY KEY, item_id INTEGER NOT NULL, note TEXT)")
+	mustExec(t, db, "INSERT INTO item_notes (item_id, note) VALUES (1, 'fragile'), (5, 'gift')")
+	mustExec(t, db, "CREATE TABLE item_notes_archive AS SELECT * FROM item_notes WHERE 0")
+
+	// Children are moved first, so they come first in the results
+	notes := &dependentTable{Name: "item_notes", ArchiveTable: "item_notes_archive", references: shopTables()["item_notes"].references}
+	items := orderItems()
+	setPredicates("order_items", items.predicate, items.args, []*dependentTable{notes})
+
+	var results []DependentResult
+	for _, table := range []*dependentTable{notes, items} {
+		moved, err := moveDependentRows(context.Background(), db, &SQLiteDialect{}, table, 0, nil, testLogger())
+		if err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, DependentResult{Table: table.Name, ArchiveTable: table.ArchiveTable, ArchiveCount: moved})
+	}
+	if got := tableCount(t, db, "item_notes"); got != 1 {
+		t.Fatalf("item_notes has %d rows after the move, want 1", got)
+	}
+
+	if err := restoreDependents(context.Background(), db, results, testLogger()); err != nil {
+		t.Fatal(err)
+	}
+	if got := tableCount(t, db, "order_items"); got != 6 {
+		t.Errorf("order_items has %d rows after restore, want 6", got)
+	}
+	if got := tableCount(t, db, "item_notes"); got != 2 {
+		t.Errorf("item_notes has %d rows after restore, want 2", got)
+	}
+	for _, archive := range []string{"order_items_archive", "item_notes_archive"} {
+		if tableExists(t, db, archive) {
+			t.Errorf("%s was not dropped", archive)
+		}
+	}
+}
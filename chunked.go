@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// copyOldRecordsChunked copies rows older than cutoffDate in primary key
+// ranges of chunkSize rows, waiting on the throttler before each chunk.
+// Tables without a single-column primary key are copied in one statement.
+func copyOldRecordsChunked(ctx context.Context, db *sql.DB, dialect Dialect, sourceTable, destTable, dateColumn string, cutoffDate time.Time, chunkSize int, throttler *Throttler, logger *Logger) error {
+	pkColumns, err := dialect.PrimaryKey(ctx, db, sourceTable)
+	if err != nil {
+		return fmt.Errorf("failed to get primary key: %v", err)
+	}
+	if len(pkColumns) != 1 {
+		logger.Warning("Table %s has no single-column primary key, copying in one statement", sourceTable)
+		if err := throttler.Wait(ctx); err != nil {
+			return err
+		}
+		return copyOldRecords(ctx, db, dialect, sourceTable, destTable, dateColumn, cutoffDate, 0, nil, logger)
+	}
+
+	logger.Info("Copying in chunks of %d rows by primary key %s with cutoff %s", chunkSize, pkColumns[0], cutoffDate.Format("2006-01-02"))
+
+	pk := dialect.QuoteIdentifier(pkColumns[0])
+	source, dest, column := dialect.QuoteIdentifier(sourceTable), dialect.QuoteIdentifier(destTable), dialect.QuoteIdentifier(dateColumn)
+	boundQuery := dialect.Rebind(fmt.Sprintf("SELECT %s FROM %s WHERE %s < ? AND %s > ? ORDER BY %s LIMIT 1 OFFSET %d",
+		pk, source, column, pk, pk, chunkSize-1))
+	firstBoundQuery := dialect.Rebind(fmt.Sprintf("SELECT %s FROM %s WHERE %s < ? ORDER BY %s LIMIT 1 OFFSET %d",
+		pk, source, column, pk, chunkSize-1))
+
+	cutoff := dialect.TimeArg(cutoffDate)
+	var lower any
+	var totalRows int64
+	for {
+		if err := throttler.Wait(ctx); err != nil {
+			return err
+		}
+
+		// Find the upper bound of the next chunk; none means this is the last one
+		var upper any
+		var err error
+		if lower == nil {
+			err = db.QueryRowContext(ctx, firstBoundQuery, cutoff).Scan(&upper)
+		} else {
+			err = db.QueryRowContext(ctx, boundQuery, cutoff, lower).Scan(&upper)
+		}
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+
+		query := fmt.Sprintf("INSERT INTO %s SELECT * FROM %s WHERE %s < ?", dest, source, column)
+		args := []any{cutoff}
+		if lower != nil {
+			query += fmt.Sprintf(" AND %s > ?", pk)
+			args = append(args, lower)
+		}
+		if upper != nil {
+			query += fmt.Sprintf(" AND %s <= ?", pk)
+			args = append(args, upper)
+		}
+
+		result, err := execKillable(ctx, db, dialect, dialect.Rebind(query), args...)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, _ := result.RowsAffected()
+		totalRows += rowsAffected
+		logger.Info("Copied %d rows...", totalRows)
+
+		if upper == nil {
+			break
+		}
+		lower = upper
+	}
+
+	logger.Info("Copied %d rows", totalRows)
+	return nil
+}
+
+// deleteOldRecordsChunked deletes rows older than cutoffDate chunkSize rows
+// at a time, waiting on the throttler before each chunk. Each chunk commits
+// on its own, so the returned count is accurate even when an error occurs.
+func deleteOldRecordsChunked(ctx context.Context, db *sql.DB, dialect Dialect, table, dateColumn string, cutoffDate time.Time, chunkSize int, throttler *Throttler, logger *Logger) (int64, error) {
+	query := dialect.Rebind(dialect.DeleteLimit(table, dialect.QuoteIdentifier(dateColumn)+" < ?", chunkSize))
+	logger.Info("Executing in chunks: %s with cutoff %s", query, cutoffDate.Format("2006-01-02"))
+
+	var totalRows int64
+	for {
+		if err := throttler.Wait(ctx); err != nil {
+			return totalRows, err
+		}
+
+		result, err := execKillable(ctx, db, dialect, query, dialect.TimeArg(cutoffDate))
+		if err != nil {
+			return totalRows, err
+		}
+
+		rowsAffected, _ := result.RowsAffected()
+		totalRows += rowsAffected
+		logger.Info("Deleted %d rows...", totalRows)
+
+		if rowsAffected < int64(chunkSize) {
+			break
+		}
+	}
+
+	logger.Info("Deleted %d rows", totalRows)
+	return totalRows, nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+const credentialHelp = `
+Credential sources (highest precedence first):
+  1. -password            visible in ps output and shell history, avoid in production
+  2. -password-file       first line of the file is used as the password
+  3. -prompt-password     read the password from the terminal without echo
+  4. -password-env        environment variable holding the password (default DB_PASSWORD)
+  5. MySQL option file    [client] section of -defaults-file, or ~/.my.cnf when present;
+                          also supplies user, host, port, socket and ssl-ca/
+                          ssl-cert/ssl-key unless set by flags
+  -prompt-password cannot be combined with -password or -password-file.
+`
+
+// resolveCredentials fills in connection settings not given on the command
+// line from the MySQL option file, then picks the password from the first
+// configured source in precedence order. The sources given as flags come
+// before the environment and the option file, which may be set up for
+// another purpose.
+func resolveCredentials(config *Config, explicit map[string]bool) error {
+	if config.PromptPassword && (explicit["password"] || config.PasswordFile != "") {
+		return fmt.Errorf("-prompt-password cannot be combined with -password or -password-file")
+	}
+
+	// Option files are a MySQL client convention
+	optionFile := config.DefaultsFile
+	if optionFile == "" && config.Driver == DriverMySQL {
+		if home, err := os.UserHomeDir(); err == nil {
+			candidate := filepath.Join(home, ".my.cnf")
+			if _, err := os.Stat(candidate); err == nil {
+				optionFile = candidate
+			}
+		}
+	}
+
+	var options map[string]string
+	if optionFile != "" {
+		var err error
+		options, err = readOptionFile(optionFile, "client")
+		if err != nil {
+			return err
+		}
+		if err := applyClientOptions(config, options, explicit); err != nil {
+			return fmt.Errorf("invalid option file %s: %v", optionFile, err)
+		}
+	}
+
+	if explicit["password"] {
+		return nil
+	}
+
+	if config.PasswordFile != "" {
+		password, err := readPasswordFile(config.PasswordFile)
+		if err != nil {
+			return err
+		}
+		config.Password = password
+		return nil
+	}
+
+	if config.PromptPassword {
+		password, err := promptPassword(config)
+		if err != nil {
+			return err
+		}
+		config.Password = password
+		return nil
+	}
+
+	if config.PasswordEnv != "" {
+		if password, ok := os.LookupEnv(config.PasswordEnv); ok {
+			config.Password = password
+			return nil
+		}
+	}
+
+	if password, ok := options["password"]; ok {
+		config.Password = password
+	}
+
+	return nil
+}
+
+func readPasswordFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read password file: %v", err)
+	}
+	password, _, _ := strings.Cut(string(content), "\n")
+	return strings.TrimRight(password, "\r"), nil
+}
+
+// readOptionFile parses a MySQL option file and returns the keys of the given
+// section. Option names are normalised so that "ssl_ca" and "ssl-ca" are equal.
+// Options without a value are skipped: for the mysql client a bare password
+// line means "prompt", not an empty password.
+func readOptionFile(path, section string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open option file: %v", err)
+	}
+	defer file.Close()
+
+	options := make(map[string]string)
+	current := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' || line[0] == '!' {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			current = strings.ToLower(strings.TrimSpace(line[1 : len(line)-1]))
+			continue
+		}
+
+		if current != section {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		key = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(key)), "_", "-")
+		options[key] = unquoteOptionValue(strings.TrimSpace(value))
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read option file: %v", err)
+	}
+
+	return options, nil
+}
+
+func unquoteOptionValue(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	// Unquoted values may carry a trailing comment
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = strings.TrimSpace(value[:i])
+	}
+	return value
+}
+
+func applyClientOptions(config *Config, options map[string]string, explicit map[string]bool) error {
+	if user, ok := options["user"]; ok && !explicit["user"] {
+		config.User = user
+	}
+	if host, ok := options["host"]; ok && !explicit["host"] {
+		config.Host = host
+	}
+	if port, ok := options["port"]; ok && !explicit["port"] {
+		p, err := strconv.Atoi(port)
+		if err != nil {
+			return fmt.Errorf("invalid port %q", port)
+		}
+		config.Port = p
+	}
+	if socket, ok := options["socket"]; ok && !explicit["socket"] {
+		config.Socket = socket
+	}
+	if ca, ok := options["ssl-ca"]; ok && !explicit["tls-ca"] {
+		config.TLSCA = ca
+	}
+	if cert, ok := options["ssl-cert"]; ok && !explicit["tls-cert"] {
+		config.TLSCert = cert
+	}
+	if key, ok := options["ssl-key"]; ok && !explicit["tls-key"] {
+		config.TLSKey = key
+	}
+	return nil
+}
+
+func promptPassword(config *Config) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", fmt.Errorf("cannot prompt for password: stdin is not a terminal")
+	}
+
+	fmt.Fprintf(os.Stderr, "Enter password for %s@%s: ", config.User, config.Host)
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read password: %v", err)
+	}
+
+	return string(password), nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeOptionFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "my.cnf")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadOptionFile(t *testing.T) {
+	path := writeOptionFile(t, `# comment
+!includedir /etc/mysql/conf.d/
+[mysqld]
+user = mysql
+
+[Client]
+user = archiver
+ssl_ca = "/etc/ssl/ca.pem"
+host=db1 # primary
+; another comment
+port=3307
+skip-ssl
+`)
+	options, err := readOptionFile(path, "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"user": "archiver", "ssl-ca": "/etc/ssl/ca.pem", "host": "db1", "port": "3307"}
+	if len(options) != len(want) {
+		t.Errorf("options = %v, want %v", options, want)
+	}
+	for key, value := range want {
+		if options[key] != value {
+			t.Errorf("options[%s] = %q, want %q", key, options[key], value)
+		}
+	}
+
+	if _, err := readOptionFile(filepath.Join(t.TempDir(), "missing.cnf"), "client"); err == nil {
+		t.Error("readOptionFile accepted a missing file")
+	}
+}
+
+func TestUnquoteOptionValue(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{`"s3cret"`, "s3cret"},
+		{`'it is'`, "it is"},
+		{`"a # b"`, "a # b"},
+		{"plain # comment", "plain"},
+		{"pass#word", "pass#word"},
+		{`"open`, `"open`},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := unquoteOptionValue(tt.in); got != tt.want {
+			t.Errorf("unquoteOptionValue(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestResolveCredentialsPrecedence(t *testing.T) {
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(passwordFile, []byte("from-file\r\nignored\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	optionFile := writeOptionFile(t, "[client]\nuser=archiver\npassword='from-option-file'\n")
+	bareOptionFile := writeOptionFile(t, "[client]\npassword\n")
+
+	tests := []struct {
+		name     string
+		config   Config
+		explicit map[string]bool
+		env      string
+		want     string
+		wantErr  string
+	}{
+		{"flag", Config{Password: "from-flag", PasswordFile: passwordFile, DefaultsFile: optionFile}, map[string]bool{"password": true}, "from-env", "from-flag", ""},
+		{"file", Config{PasswordFile: passwordFile, DefaultsFile: optionFile}, nil, "from-env", "from-file", ""},
+		{"env", Config{DefaultsFile: optionFile}, nil, "from-env", "from-env", ""},
+		{"option file", Config{DefaultsFile: optionFile}, nil, "", "from-option-file", ""},
+		{"bare password line", Config{DefaultsFile: bareOptionFile}, nil, "", "", ""},
+		// The prompt comes before the environment; stdin is not a terminal here
+		{"prompt", Config{PromptPassword: true, DefaultsFile: optionFile}, nil, "from-env", "", "cannot prompt for password"},
+		{"prompt and flag", Config{PromptPassword: true, Password: "x"}, map[string]bool{"password": true}, "", "", "cannot be combined"},
+		{"prompt and file", Config{PromptPassword: true, PasswordFile: passwordFile}, nil, "", "", "cannot be combined"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			config.Driver = DriverMySQL
+			config.PasswordEnv = "TEST_DB_PASSWORD"
+			if tt.env != "" {
+				t.Setenv(config.PasswordEnv, tt.env)
+			}
+			explicit := tt.explicit
+			if explicit == nil {
+				explicit = map[string]bool{}
+			}
+
+			err := resolveCredentials(&config, explicit)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("resolveCredentials error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.Password != tt.want {
+				t.Errorf("password = %q, want %q", config.Password, tt.want)
+			}
+		})
+	}
+}
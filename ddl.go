@@ -0,0 +1,606 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxIdentifierLength is the longest table, index or constraint name MySQL accepts.
+const maxIdentifierLength = 64
+
+type tokenKind int
+
+const (
+	tokenSpace tokenKind = iota
+	tokenComment
+	tokenWord
+	tokenIdent
+	tokenString
+	tokenPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string // raw text as it appeared in the statement
+}
+
+// value returns the unquoted value of identifier and string tokens and the
+// raw text of any other token.
+func (t token) value() string {
+	switch t.kind {
+	case tokenIdent:
+		return strings.ReplaceAll(t.text[1:len(t.text)-1], "``", "`")
+	case tokenString:
+		quote := t.text[0:1]
+		inner := t.text[1 : len(t.text)-1]
+		inner = strings.ReplaceAll(inner, quote+quote, quote)
+		return strings.ReplaceAll(inner, `\`+quote, quote)
+	}
+	return t.text
+}
+
+func (t token) is(word string) bool {
+	return t.kind == tokenWord && strings.EqualFold(t.text, word)
+}
+
+func (t token) significant() bool {
+	return t.kind != tokenSpace && t.kind != tokenComment
+}
+
+func quoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// tokenizeSQL splits a MySQL statement into tokens. Concatenating the text of
+// all tokens reproduces the input exactly.
+func tokenizeSQL(sql string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		start := i
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			for i < len(sql) && strings.IndexByte(" \t\n\r", sql[i]) >= 0 {
+				i++
+			}
+			tokens = append(tokens, token{tokenSpace, sql[start:i]})
+
+		case c == '/' && i+1 < len(sql) && sql[i+1] == '*':
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated comment at offset %d", start)
+			}
+			i += end + 4
+			tokens = append(tokens, token{tokenComment, sql[start:i]})
+
+		case c == '-' && strings.HasPrefix(sql[i:], "-- "), c == '#':
+			for i < len(sql) && sql[i] != '\n' {
+				i++
+			}
+			tokens = append(tokens, token{tokenComment, sql[start:i]})
+
+		case c == '`':
+			i++
+			for {
+				end := strings.IndexByte(sql[i:], '`')
+				if end < 0 {
+					return nil, fmt.Errorf("unterminated identifier at offset %d", start)
+				}
+				i += end + 1
+				if i < len(sql) && sql[i] == '`' {
+					i++
+					continue
+				}
+				break
+			}
+			tokens = append(tokens, token{tokenIdent, sql[start:i]})
+
+		case c == '\'' || c == '"':
+			i++
+			for {
+				if i >= len(sql) {
+					return nil, fmt.Errorf("unterminated string at offset %d", start)
+				}
+				if sql[i] == '\\' {
+					i += 2
+					continue
+				}
+				if sql[i] == c {
+					i++
+					if i < len(sql) && sql[i] == c {
+						i++
+						continue
+					}
+					break
+				}
+				i++
+			}
+			tokens = append(tokens, token{tokenString, sql[start:i]})
+
+		case isWordByte(c):
+			for i < len(sql) && isWordByte(sql[i]) {
+				i++
+			}
+			tokens = append(tokens, token{tokenWord, sql[start:i]})
+
+		default:
+			i++
+			tokens = append(tokens, token{tokenPunct, sql[start:i]})
+		}
+	}
+	return tokens, nil
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c == '$' || c == '.' || c >= 0x80 ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// DefinitionKind classifies an entry between the parentheses of CREATE TABLE.
+type DefinitionKind int
+
+const (
+	ColumnDefinition DefinitionKind = iota
+	PrimaryKeyDefinition
+	IndexDefinition
+	UniqueIndexDefinition
+	FulltextIndexDefinition
+	SpatialIndexDefinition
+	ForeignKeyDefinition
+	CheckDefinition
+)
+
+// TableDefinition is a column, index or constraint definition. Its tokens
+// are kept verbatim so that rendering only changes what was modified.
+type TableDefinition struct {
+	Kind      DefinitionKind
+	Name      string
+	tokens    []token
+	nameToken int // index of the name in tokens, or -1 when unnamed
+}
+
+// SetName renames the column, index or constraint. Unnamed definitions are
+// left unchanged.
+func (d *TableDefinition) SetName(name string) {
+	if d.nameToken < 0 {
+		return
+	}
+	d.Name = name
+	d.tokens[d.nameToken] = token{tokenIdent, quoteIdentifier(name)}
+}
+
+// KeyColumns returns the columns of an index, primary key or foreign key
+// definition, in order, without prefix lengths.
+func (d *TableDefinition) KeyColumns() []string {
+	var columns []string
+	depth := 0
+	for _, t := range d.tokens {
+		if t.kind == tokenPunct {
+			switch t.text {
+			case "(":
+				depth++
+			case ")":
+				depth--
+				if depth == 0 {
+					return columns
+				}
+			}
+			continue
+		}
+		if depth == 1 && t.kind == tokenIdent {
+			columns = append(columns, t.value())
+		}
+	}
+	return columns
+}
+
+// HasAttribute reports whether a column definition carries the given keyword,
+// such as AUTO_INCREMENT, outside of any parentheses.
+func (d *TableDefinition) HasAttribute(word string) bool {
+	depth := 0
+	for _, t := range d.tokens {
+		if t.kind == tokenPunct && t.text == "(" {
+			depth++
+		} else if t.kind == tokenPunct && t.text == ")" {
+			depth--
+		} else if depth == 0 && t.is(word) {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveAttribute removes a keyword such as AUTO_INCREMENT from a column
+// definition, along with the whitespace before it.
+func (d *TableDefinition) RemoveAttribute(word string) {
+	tokens := d.tokens[:0:0]
+	depth := 0
+	for i, t := range d.tokens {
+		if t.kind == tokenPunct && t.text == "(" {
+			depth++
+		} else if t.kind == tokenPunct && t.text == ")" {
+			depth--
+		} else if depth == 0 && t.is(word) {
+			if n := len(tokens); n > 0 && tokens[n-1].kind == tokenSpace {
+				tokens = tokens[:n-1]
+			}
+			continue
+		}
+		if i == d.nameToken {
+			d.nameToken = len(tokens)
+		}
+		tokens = append(tokens, t)
+	}
+	d.tokens = tokens
+}
+
+// String renders the definition without the line break and indentation
+// around it. Other trailing whitespace is kept, since SHOW CREATE TABLE
+// writes a space after a versioned comment such as /*!50100 WITH PARSER */.
+func (d *TableDefinition) String() string {
+	return strings.TrimRight(strings.TrimLeft(joinTokens(d.tokens), " \t\r\n"), "\r\n")
+}
+
+// TableOption is a NAME=value pair following the closing parenthesis, such as
+// ENGINE=InnoDB. Value holds the raw value text, including any quotes. A
+// versioned comment such as /*!50100 TABLESPACE `ts` */ is kept as an option
+// with an empty Name and the comment as its Value.
+type TableOption struct {
+	Name  string
+	Value string
+}
+
+// CreateTable is a parsed CREATE TABLE statement as returned by SHOW CREATE TABLE.
+type CreateTable struct {
+	Name        string
+	Definitions []*TableDefinition
+	Options     []TableOption
+	// Partition holds the raw partitioning clause, including the versioned
+	// comment MySQL wraps it in.
+	Partition string
+}
+
+// parseCreateTable parses the output of SHOW CREATE TABLE.
+func parseCreateTable(sql string) (*CreateTable, error) {
+	tokens, err := tokenizeSQL(strings.TrimSpace(sql))
+	if err != nil {
+		return nil, err
+	}
+
+	pos := 0
+	next := func() (token, bool) {
+		for pos < len(tokens) {
+			t := tokens[pos]
+			pos++
+			if t.significant() {
+				return t, true
+			}
+		}
+		return token{}, false
+	}
+
+	if t, ok := next(); !ok || !t.is("CREATE") {
+		return nil, fmt.Errorf("not a CREATE TABLE statement")
+	}
+	t, ok := next()
+	if ok && t.is("TEMPORARY") {
+		t, ok = next()
+	}
+	if !ok || !t.is("TABLE") {
+		return nil, fmt.Errorf("not a CREATE TABLE statement")
+	}
+
+	t, ok = next()
+	if ok && t.is("IF") {
+		next() // NOT
+		next() // EXISTS
+		t, ok = next()
+	}
+	if !ok || (t.kind != tokenIdent && t.kind != tokenWord) {
+		return nil, fmt.Errorf("missing table name")
+	}
+	table := &CreateTable{Name: t.value()}
+
+	if t, ok := next(); !ok || t.text != "(" {
+		return nil, fmt.Errorf("expected ( after table name")
+	}
+
+	// Split the body on top-level commas
+	depth := 0
+	var current []token
+	closed := false
+	for pos < len(tokens) && !closed {
+		t := tokens[pos]
+		pos++
+
+		if t.kind == tokenPunct {
+			switch t.text {
+			case "(":
+				depth++
+			case ")":
+				if depth == 0 {
+					closed = true
+					continue
+				}
+				depth--
+			case ",":
+				if depth == 0 {
+					def, err := parseDefinition(current)
+					if err != nil {
+						return nil, err
+					}
+					table.Definitions = append(table.Definitions, def)
+					current = nil
+					continue
+				}
+			}
+		}
+		current = append(current, t)
+	}
+	if !closed {
+		return nil, fmt.Errorf("missing ) at end of table definition")
+	}
+	def, err := parseDefinition(current)
+	if err != nil {
+		return nil, err
+	}
+	table.Definitions = append(table.Definitions, def)
+
+	if err := table.parseOptions(tokens[pos:]); err != nil {
+		return nil, err
+	}
+
+	return table, nil
+}
+
+func parseDefinition(tokens []token) (*TableDefinition, error) {
+	def := &TableDefinition{tokens: tokens, nameToken: -1}
+
+	var sig []int
+	for i, t := range tokens {
+		if t.significant() {
+			sig = append(sig, i)
+		}
+	}
+	if len(sig) == 0 {
+		return nil, fmt.Errorf("empty table definition")
+	}
+
+	word := func(n int) token {
+		if n < len(sig) {
+			return tokens[sig[n]]
+		}
+		return token{}
+	}
+	// nameAt records the n-th significant token as the name when it is an identifier
+	nameAt := func(n int) {
+		if n < len(sig) && tokens[sig[n]].kind == tokenIdent {
+			def.nameToken = sig[n]
+			def.Name = tokens[sig[n]].value()
+		}
+	}
+	// skipKeyword skips an optional KEY or INDEX keyword at n
+	skipKeyword := func(n int) int {
+		if word(n).is("KEY") || word(n).is("INDEX") {
+			return n + 1
+		}
+		return n
+	}
+
+	first := word(0)
+	switch {
+	case first.kind == tokenIdent:
+		def.Kind = ColumnDefinition
+		nameAt(0)
+	case first.is("PRIMARY"):
+		def.Kind = PrimaryKeyDefinition
+	case first.is("KEY") || first.is("INDEX"):
+		def.Kind = IndexDefinition
+		nameAt(1)
+	case first.is("UNIQUE"):
+		def.Kind = UniqueIndexDefinition
+		nameAt(skipKeyword(1))
+	case first.is("FULLTEXT"):
+		def.Kind = FulltextIndexDefinition
+		nameAt(skipKeyword(1))
+	case first.is("SPATIAL"):
+		def.Kind = SpatialIndexDefinition
+		nameAt(skipKeyword(1))
+	case first.is("FOREIGN"):
+		def.Kind = ForeignKeyDefinition
+		nameAt(2)
+	case first.is("CHECK"):
+		def.Kind = CheckDefinition
+	case first.is("CONSTRAINT"):
+		n := 1
+		if word(1).kind == tokenIdent {
+			nameAt(1)
+			n = 2
+		}
+		switch {
+		case word(n).is("PRIMARY"):
+			def.Kind = PrimaryKeyDefinition
+		case word(n).is("UNIQUE"):
+			def.Kind = UniqueIndexDefinition
+		case word(n).is("FOREIGN"):
+			def.Kind = ForeignKeyDefinition
+		case word(n).is("CHECK"):
+			def.Kind = CheckDefinition
+		default:
+			return nil, fmt.Errorf("unsupported constraint: %s", strings.TrimSpace(joinTokens(tokens)))
+		}
+	case first.kind == tokenWord:
+		// Unquoted column name
+		def.Kind = ColumnDefinition
+		def.Name = first.text
+	default:
+		return nil, fmt.Errorf("unexpected definition: %s", strings.TrimSpace(joinTokens(tokens)))
+	}
+
+	return def, nil
+}
+
+// parseOptions parses the table options and partitioning clause that follow
+// the closing parenthesis.
+func (c *CreateTable) parseOptions(tokens []token) error {
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		if !t.significant() {
+			if t.kind == tokenComment && isPartitionComment(t.text) {
+				c.Partition = strings.TrimSpace(joinTokens(tokens[i:]))
+				return nil
+			}
+			if t.kind == tokenComment && strings.HasPrefix(t.text, "/*!") {
+				c.Options = append(c.Options, TableOption{Value: t.text})
+			}
+			continue
+		}
+		if t.is("PARTITION") {
+			c.Partition = strings.TrimSpace(joinTokens(tokens[i:]))
+			return nil
+		}
+		if t.kind == tokenPunct && t.text == ";" {
+			continue
+		}
+
+		// Collect the option name, which may be several words such as DEFAULT CHARSET
+		var name []string
+		for ; i < len(tokens); i++ {
+			t = tokens[i]
+			if t.kind == tokenPunct && t.text == "=" {
+				break
+			}
+			if t.kind == tokenWord {
+				name = append(name, strings.ToUpper(t.text))
+			} else if t.significant() {
+				return fmt.Errorf("unexpected %q in table options", t.text)
+			}
+		}
+		if i >= len(tokens) {
+			return fmt.Errorf("missing value for table option %s", strings.Join(name, " "))
+		}
+
+		// Skip to the value
+		for i++; i < len(tokens) && !tokens[i].significant(); i++ {
+		}
+		if i >= len(tokens) {
+			return fmt.Errorf("missing value for table option %s", strings.Join(name, " "))
+		}
+		c.Options = append(c.Options, TableOption{Name: strings.Join(name, " "), Value: tokens[i].text})
+	}
+	return nil
+}
+
+func isPartitionComment(text string) bool {
+	inner := strings.TrimPrefix(text, "/*!")
+	inner = strings.TrimLeft(inner, "0123456789")
+	return strings.HasPrefix(text, "/*!") && strings.HasPrefix(strings.ToUpper(strings.TrimSpace(inner)), "PARTITION")
+}
+
+// Option returns the value of the named table option.
+func (c *CreateTable) Option(name string) (string, bool) {
+	for _, opt := range c.Options {
+		if strings.EqualFold(opt.Name, name) {
+			return opt.Value, true
+		}
+	}
+	return "", false
+}
+
+// SetOption sets or adds a table option.
+func (c *CreateTable) SetOption(name, value string) {
+	for i, opt := range c.Options {
+		if strings.EqualFold(opt.Name, name) {
+			c.Options[i].Value = value
+			return
+		}
+	}
+	c.Options = append(c.Options, TableOption{Name: strings.ToUpper(name), Value: value})
+}
+
+// Column returns the definition of the named column, or nil.
+func (c *CreateTable) Column(name string) *TableDefinition {
+	for _, def := range c.Definitions {
+		if def.Kind == ColumnDefinition && strings.EqualFold(def.Name, name) {
+			return def
+		}
+	}
+	return nil
+}
+
+// RemoveDefinitions removes every definition for which remove returns true
+// and returns the removed definitions.
+func (c *CreateTable) RemoveDefinitions(remove func(*TableDefinition) bool) []*TableDefinition {
+	var kept, removed []*TableDefinition
+	for _, def := range c.Definitions {
+		if remove(def) {
+			removed = append(removed, def)
+		} else {
+			kept = append(kept, def)
+		}
+	}
+	c.Definitions = kept
+	return removed
+}
+
+// RemoveOption removes the named table option if present.
+func (c *CreateTable) RemoveOption(name string) {
+	options := c.Options[:0]
+	for _, opt := range c.Options {
+		if !strings.EqualFold(opt.Name, name) {
+			options = append(options, opt)
+		}
+	}
+	c.Options = options
+}
+
+// String renders the statement in the layout used by SHOW CREATE TABLE.
+func (c *CreateTable) String() string {
+	var b strings.Builder
+	b.WriteString("CREATE TABLE ")
+	b.WriteString(quoteIdentifier(c.Name))
+	b.WriteString(" (\n")
+	for i, def := range c.Definitions {
+		b.WriteString("  ")
+		b.WriteString(def.String())
+		if i < len(c.Definitions)-1 {
+			b.WriteString(",")
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString(")")
+	for _, opt := range c.Options {
+		b.WriteString(" ")
+		if opt.Name != "" {
+			b.WriteString(opt.Name)
+			b.WriteString("=")
+		}
+		b.WriteString(opt.Value)
+	}
+	if c.Partition != "" {
+		b.WriteString("\n")
+		b.WriteString(c.Partition)
+	}
+	return b.String()
+}
+
+func joinTokens(tokens []token) string {
+	var b strings.Builder
+	for _, t := range tokens {
+		b.WriteString(t.text)
+	}
+	return b.String()
+}
+
+// suffixedName appends suffix to name, shortening name when needed so the
+// result stays within MySQL's identifier length limit.
+func suffixedName(name, suffix string) string {
+	suffix = "_" + suffix
+	runes := []rune(name)
+	if keep := maxIdentifierLength - utf8.RuneCountInString(suffix); len(runes) > keep {
+		runes = runes[:keep]
+	}
+	return string(runes) + suffix
+}
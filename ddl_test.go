@@ -0,0 +1,313 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// createTableCorpus holds SHOW CREATE TABLE output in the layout MySQL uses.
+var createTableCorpus = []struct {
+	name  string
+	table string
+	sql   string
+	// renamed are substrings of the statement modifyCreateStatement returns
+	// for the table renamed to table_archive with suffix 20240101.
+	renamed []string
+}{
+	{
+		name:  "partitioned",
+		table: "sms_log",
+		sql: "CREATE TABLE `sms_log` (\n" +
+			"  `id` bigint NOT NULL AUTO_INCREMENT,\n" +
+			"  `created_at` datetime NOT NULL,\n" +
+			"  PRIMARY KEY (`id`,`created_at`),\n" +
+			"  KEY `idx_created` (`created_at`)\n" +
+			") ENGINE=InnoDB AUTO_INCREMENT=42 DEFAULT CHARSET=utf8mb4\n" +
+			"/*!50100 PARTITION BY RANGE (to_days(`created_at`))\n" +
+			"(PARTITION p202401 VALUES LESS THAN (739282) ENGINE = InnoDB,\n" +
+			" PARTITION pmax VALUES LESS THAN MAXVALUE ENGINE = InnoDB) */",
+		renamed: []string{
+			"CREATE TABLE `sms_log_archive` (",
+			"KEY `idx_created_20240101` (`created_at`)",
+			"(PARTITION p202401 VALUES LESS THAN (739282) ENGINE = InnoDB,\n PARTITION pmax",
+		},
+	},
+	{
+		name:  "generated columns",
+		table: "orders",
+		sql: "CREATE TABLE `orders` (\n" +
+			"  `id` int NOT NULL,\n" +
+			"  `price` decimal(10,2) NOT NULL,\n" +
+			"  `qty` int NOT NULL,\n" +
+			"  `total` decimal(12,2) GENERATED ALWAYS AS ((`price` * `qty`)) STORED,\n" +
+			"  `label` varchar(64) GENERATED ALWAYS AS (concat(_utf8mb4'#',`id`,_utf8mb4', (x)')) VIRTUAL,\n" +
+			"  PRIMARY KEY (`id`),\n" +
+			"  KEY `idx_total` (`total`)\n" +
+			") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci",
+		renamed: []string{
+			"`total` decimal(12,2) GENERATED ALWAYS AS ((`price` * `qty`)) STORED,",
+			"`label` varchar(64) GENERATED ALWAYS AS (concat(_utf8mb4'#',`id`,_utf8mb4', (x)')) VIRTUAL,",
+			"KEY `idx_total_20240101` (`total`)",
+		},
+	},
+	{
+		name:  "foreign keys",
+		table: "order_items",
+		sql: "CREATE TABLE `order_items` (\n" +
+			"  `id` int NOT NULL,\n" +
+			"  `order_id` int NOT NULL,\n" +
+			"  PRIMARY KEY (`id`),\n" +
+			"  KEY `fk_order` (`order_id`),\n" +
+			"  CONSTRAINT `fk_order` FOREIGN KEY (`order_id`) REFERENCES `orders` (`id`) ON DELETE CASCADE,\n" +
+			"  CONSTRAINT `chk_id` CHECK ((`id` > 0))\n" +
+			") ENGINE=InnoDB DEFAULT CHARSET=latin1",
+		renamed: []string{
+			"KEY `fk_order_20240101` (`order_id`)",
+			"CONSTRAINT `fk_order_20240101` FOREIGN KEY (`order_id`) REFERENCES `orders` (`id`) ON DELETE CASCADE",
+			"CONSTRAINT `chk_id_20240101` CHECK ((`id` > 0))",
+		},
+	},
+	{
+		name:  "versioned table options",
+		table: "events",
+		sql: "CREATE TABLE `events` (\n" +
+			"  `id` int NOT NULL,\n" +
+			"  PRIMARY KEY (`id`)\n" +
+			") /*!50100 TABLESPACE `ts1` */ ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 STATS_PERSISTENT=0 /*!80016 DEFAULT ENCRYPTION='N' */",
+		renamed: []string{
+			") /*!50100 TABLESPACE `ts1` */ ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 STATS_PERSISTENT=0 /*!80016 DEFAULT ENCRYPTION='N' */",
+		},
+	},
+	{
+		name:  "quoted identifiers",
+		table: "we`ird",
+		sql: "CREATE TABLE `we``ird` (\n" +
+			"  `col``1` int NOT NULL,\n" +
+			"  `a,b` varchar(10) DEFAULT NULL,\n" +
+			"  UNIQUE KEY `uk``x` (`col``1`),\n" +
+			"  KEY `k)` (`a,b`(5))\n" +
+			") ENGINE=InnoDB",
+		renamed: []string{
+			"CREATE TABLE `we``ird_archive` (",
+			"`col``1` int NOT NULL,",
+			"UNIQUE KEY `uk``x_20240101` (`col``1`)",
+			"KEY `k)_20240101` (`a,b`(5))",
+		},
+	},
+	{
+		name:  "fulltext and spatial keys",
+		table: "places",
+		sql: "CREATE TABLE `places` (\n" +
+			"  `id` int NOT NULL,\n" +
+			"  `api_key` varchar(64) NOT NULL,\n" +
+			"  `key_hash` binary(32) DEFAULT NULL,\n" +
+			"  `primary_key_note` text,\n" +
+			"  `location` point NOT NULL /*!80003 SRID 4326 */,\n" +
+			"  PRIMARY KEY (`id`),\n" +
+			"  UNIQUE KEY `uk_api_key` (`api_key`),\n" +
+			"  KEY `idx_key_hash` (`key_hash`) USING BTREE,\n" +
+			"  FULLTEXT KEY `ft_note` (`primary_key_note`) /*!50100 WITH PARSER `ngram` */ ,\n" +
+			"  SPATIAL KEY `sp_location` (`location`)\n" +
+			") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4",
+		renamed: []string{
+			"`api_key` varchar(64) NOT NULL,",
+			"`key_hash` binary(32) DEFAULT NULL,",
+			"`primary_key_note` text,",
+			"UNIQUE KEY `uk_api_key_20240101` (`api_key`)",
+			"KEY `idx_key_hash_20240101` (`key_hash`) USING BTREE",
+			"FULLTEXT KEY `ft_note_20240101` (`primary_key_note`) /*!50100 WITH PARSER `ngram` */",
+			"SPATIAL KEY `sp_location_20240101` (`location`)",
+		},
+	},
+	{
+		name:  "comments",
+		table: "notes",
+		sql: "CREATE TABLE `notes` (\n" +
+			"  `id` int NOT NULL COMMENT 'id (primary), unique',\n" +
+			"  `body` text COMMENT 'it''s, ok)',\n" +
+			"  PRIMARY KEY (`id`),\n" +
+			"  KEY `idx_body` (`body`(10)) COMMENT 'prefix, (10)'\n" +
+			") ENGINE=InnoDB COMMENT='notes, (archived) here'",
+		renamed: []string{
+			"`id` int NOT NULL COMMENT 'id (primary), unique',",
+			"`body` text COMMENT 'it''s, ok)',",
+			"KEY `idx_body_20240101` (`body`(10)) COMMENT 'prefix, (10)'",
+			") ENGINE=InnoDB COMMENT='notes, (archived) here'",
+		},
+	},
+}
+
+func TestParseCreateTableRoundTrip(t *testing.T) {
+	for _, tt := range createTableCorpus {
+		t.Run(tt.name, func(t *testing.T) {
+			table, err := parseCreateTable(tt.sql)
+			if err != nil {
+				t.Fatalf("parseCreateTable: %v", err)
+			}
+			if table.Name != tt.table {
+				t.Errorf("Name = %q, want %q", table.Name, tt.table)
+			}
+			if got := table.String(); got != tt.sql {
+				t.Errorf("String() changed the statement:\n%s\nwant:\n%s", got, tt.sql)
+			}
+		})
+	}
+}
+
+func TestModifyCreateStatement(t *testing.T) {
+	for _, tt := range createTableCorpus {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := modifyCreateStatement(tt.sql, tt.table, tt.table+"_archive", "20240101")
+			if err != nil {
+				t.Fatalf("modifyCreateStatement: %v", err)
+			}
+			for _, want := range tt.renamed {
+				if !strings.Contains(got, want) {
+					t.Errorf("statement lacks %s:\n%s", want, got)
+				}
+			}
+			if _, err := parseCreateTable(got); err != nil {
+				t.Errorf("modified statement does not parse: %v", err)
+			}
+		})
+	}
+
+	if _, err := modifyCreateStatement(createTableCorpus[0].sql, "other", "other_archive", "20240101"); err == nil {
+		t.Error("modifyCreateStatement accepted a statement for another table")
+	}
+}
+
+func TestParseCreateTableDefinitions(t *testing.T) {
+	table, err := parseCreateTable(createTableCorpus[4].sql)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type definition struct {
+		Kind    DefinitionKind
+		Name    string
+		Columns []string
+	}
+	var got []definition
+	for _, def := range table.Definitions {
+		got = append(got, definition{def.Kind, def.Name, def.KeyColumns()})
+	}
+	want := []definition{
+		{ColumnDefinition, "col`1", nil},
+		{ColumnDefinition, "a,b", nil},
+		{UniqueIndexDefinition, "uk`x", []string{"col`1"}},
+		{IndexDefinition, "k)", []string{"a,b"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("definitions = %+v, want %+v", got, want)
+	}
+
+	if value, ok := table.Option("engine"); !ok || value != "InnoDB" {
+		t.Errorf("Option(engine) = %q, %v", value, ok)
+	}
+
+	// Columns named like key words stay columns
+	table, err = parseCreateTable(createTableCorpus[5].sql)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = nil
+	for _, def := range table.Definitions {
+		got = append(got, definition{def.Kind, def.Name, def.KeyColumns()})
+	}
+	want = []definition{
+		{ColumnDefinition, "id", nil},
+		{ColumnDefinition, "api_key", nil},
+		{ColumnDefinition, "key_hash", nil},
+		{ColumnDefinition, "primary_key_note", nil},
+		{ColumnDefinition, "location", nil},
+		{PrimaryKeyDefinition, "", []string{"id"}},
+		{UniqueIndexDefinition, "uk_api_key", []string{"api_key"}},
+		{IndexDefinition, "idx_key_hash", []string{"key_hash"}},
+		{FulltextIndexDefinition, "ft_note", []string{"primary_key_note"}},
+		{SpatialIndexDefinition, "sp_location", []string{"location"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("definitions = %+v, want %+v", got, want)
+	}
+}
+
+func TestTokenizeSQL(t *testing.T) {
+	tests := []struct {
+		sql    string
+		tokens []token
+	}{
+		{"KEY `a``b` (`c`)", []token{
+			{tokenWord, "KEY"}, {tokenSpace, " "}, {tokenIdent, "`a``b`"}, {tokenSpace, " "},
+			{tokenPunct, "("}, {tokenIdent, "`c`"}, {tokenPunct, ")"},
+		}},
+		{`'it''s' "a\"b" 'c\\'`, []token{
+			{tokenString, `'it''s'`}, {tokenSpace, " "}, {tokenString, `"a\"b"`}, {tokenSpace, " "}, {tokenString, `'c\\'`},
+		}},
+		{"/*!50100 PARTITION */-- x\n# y", []token{
+			{tokenComment, "/*!50100 PARTITION */"}, {tokenComment, "-- x"}, {tokenSpace, "\n"}, {tokenComment, "# y"},
+		}},
+		{"decimal(10,2)", []token{
+			{tokenWord, "decimal"}, {tokenPunct, "("}, {tokenWord, "10"}, {tokenPunct, ","}, {tokenWord, "2"}, {tokenPunct, ")"},
+		}},
+	}
+	for _, tt := range tests {
+		got, err := tokenizeSQL(tt.sql)
+		if err != nil {
+			t.Errorf("tokenizeSQL(%q): %v", tt.sql, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.tokens) {
+			t.Errorf("tokenizeSQL(%q) = %v, want %v", tt.sql, got, tt.tokens)
+		}
+		if joined := joinTokens(got); joined != tt.sql {
+			t.Errorf("tokens of %q join to %q", tt.sql, joined)
+		}
+	}
+
+	for _, sql := range []string{"`open", "'open", `'esc\'`, "/* open"} {
+		if _, err := tokenizeSQL(sql); err == nil {
+			t.Errorf("tokenizeSQL(%q) accepted unterminated input", sql)
+		}
+	}
+}
+
+func TestTokenValue(t *testing.T) {
+	tests := []struct {
+		tok  token
+		want string
+	}{
+		{token{tokenIdent, "`a``b`"}, "a`b"},
+		{token{tokenString, `'it''s'`}, "it's"},
+		{token{tokenString, `"a\"b"`}, `a"b`},
+		{token{tokenWord, "InnoDB"}, "InnoDB"},
+	}
+	for _, tt := range tests {
+		if got := tt.tok.value(); got != tt.want {
+			t.Errorf("%q.value() = %q, want %q", tt.tok.text, got, tt.want)
+		}
+	}
+}
+
+func TestSuffixedName(t *testing.T) {
+	long := strings.Repeat("x", 70)
+	tests := []struct {
+		name, suffix, want string
+	}{
+		{"idx_created", "20240101", "idx_created_20240101"},
+		{long, "20240101", strings.Repeat("x", 55) + "_20240101"},
+		{strings.Repeat("x", 55), "20240101", strings.Repeat("x", 55) + "_20240101"},
+		{strings.Repeat("é", 60), "20240101", strings.Repeat("é", 55) + "_20240101"},
+	}
+	for _, tt := range tests {
+		got := suffixedName(tt.name, tt.suffix)
+		if got != tt.want {
+			t.Errorf("suffixedName(%q, %q) = %q, want %q", tt.name, tt.suffix, got, tt.want)
+		}
+		if n := utf8.RuneCountInString(got); n > maxIdentifierLength {
+			t.Errorf("suffixedName(%q) is %d characters long", tt.name, n)
+		}
+	}
+}
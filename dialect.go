@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const (
+	DriverMySQL    = "mysql"
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+)
+
+// Column is a table column as reported by the server's catalog.
+type Column struct {
+	Name string
+	Type string
+}
+
+// Dialect holds everything the archive flow and the exports need to know
+// about the SQL of a particular database server.
+type Dialect interface {
+	// Name is the server name used in logs and dump headers.
+	Name() string
+	// Open connects to the server described by config.
+	Open(ctx context.Context, config *Config, logger *Logger) (*sql.DB, error)
+
+	// QuoteIdentifier quotes a table or column name.
+	QuoteIdentifier(name string) string
+	// Rebind rewrites the ? placeholders of query for the driver.
+	Rebind(query string) string
+	// FormatValue returns a scanned value as an SQL literal for dumps.
+	FormatValue(val any, colType *sql.ColumnType) string
+	// TimeArg returns t as a query argument comparable with date columns.
+	TimeArg(t time.Time) any
+
+	// CreateTableStatement returns the statements that recreate table.
+	CreateTableStatement(ctx context.Context, db *sql.DB, table string) (string, error)
+	// ArchiveTableStatement returns the statement creating dest with the
+	// structure of source, where createStmt came from CreateTableStatement,
+	// and a description of each change made for the archive copy.
+	ArchiveTableStatement(createStmt, source, dest, suffix string, config *Config) (string, []string, error)
+	// Columns returns the columns of table in order.
+	Columns(ctx context.Context, db *sql.DB, table string) ([]Column, error)
+	// PrimaryKey returns the primary key columns of table in key order.
+	PrimaryKey(ctx context.Context, db *sql.DB, table string) ([]string, error)
+
+	// RenameTable renames from to to, waiting at most timeout for locks.
+	RenameTable(ctx context.Context, db *sql.DB, from, to string, timeout time.Duration, logger *Logger) error
+	// DeleteLimit returns a statement deleting at most limit rows matching condition.
+	DeleteLimit(table, condition string, limit int) string
+	// ConnectionID returns the server's identifier for conn, or
+	// errors.ErrUnsupported when the driver itself aborts statements whose
+	// context is cancelled.
+	ConnectionID(ctx context.Context, conn *sql.Conn) (int64, error)
+	// CancelQuery stops the statement running on the given connection.
+	CancelQuery(ctx context.Context, db *sql.DB, connectionID int64) error
+	// BeginSnapshot starts a read-only transaction on conn in which every
+	// read sees the same snapshot, without blocking writers. Calling the
+	// returned function ends it.
+	BeginSnapshot(ctx context.Context, conn *sql.Conn) (func(), error)
+	// BeginSharedSnapshot starts a snapshot on each of conns, as
+	// BeginSnapshot does, such that all of them see the same data of table.
+	// Calling the returned function ends them.
+	BeginSharedSnapshot(ctx context.Context, db *sql.DB, table string, conns []*sql.Conn) (func(), error)
+
+	// ChecksumAggregate returns an aggregate expression the server evaluates
+	// to a checksum of the given quoted columns over the selected rows,
+	// independent of the order it reads them in.
+	ChecksumAggregate(columns []string) string
+
+	// DumpSettings returns the statements opening and closing an SQL dump.
+	DumpSettings() (begin, end string)
+	// DumpDataLock returns the statements around the data of table in a dump.
+	DumpDataLock(table string) (begin, end string)
+}
+
+func newDialect(driver string) (Dialect, error) {
+	switch driver {
+	case DriverMySQL:
+		return &MySQLDialect{}, nil
+	case DriverPostgres:
+		return &PostgresDialect{}, nil
+	case DriverSQLite:
+		return &SQLiteDialect{}, nil
+	}
+	return nil, fmt.Errorf("unsupported driver %q (want mysql, postgres or sqlite)", driver)
+}
+
+// endSnapshots returns a function ending every snapshot in ends.
+func endSnapshots(ends []func()) func() {
+	return func() {
+		for _, end := range ends {
+			end()
+		}
+	}
+}
+
+// mysqlOnlyFlags are the flags for features that only exist on MySQL.
+var mysqlOnlyFlags = []string{
+	"defaults-file", "tls-server-name", "read-timeout", "write-timeout", "collation", "loc",
+	"max-execution-time",
+	"archive-drop-foreign-keys", "archive-auto-increment", "archive-engine", "archive-row-format",
+	"archive-key-block-size", "archive-drop-indexes",
+	"cascade", "partition-mode", "partition-ahead", "partition-interval",
+	"replicas", "max-replica-lag", "heartbeat-table", "max-load", "critical-load",
+}
+
+// validateDriverFlags rejects flags the selected driver does not support.
+func validateDriverFlags(config *Config, explicit map[string]bool) error {
+	if config.Driver == DriverMySQL {
+		return nil
+	}
+	for _, name := range mysqlOnlyFlags {
+		if explicit[name] {
+			return fmt.Errorf("-%s is only supported with -driver=mysql", name)
+		}
+	}
+	return nil
+}
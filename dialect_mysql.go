@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// MySQLDialect implements Dialect for MySQL and MariaDB.
+type MySQLDialect struct{}
+
+func (d *MySQLDialect) Name() string {
+	return "MySQL"
+}
+
+func (d *MySQLDialect) Open(ctx context.Context, config *Config, logger *Logger) (*sql.DB, error) {
+	cfg, err := mysqlConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("Connecting to database %s@%s/%s", config.User, cfg.Addr, config.Database)
+
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return nil, err
+	}
+	db := sql.OpenDB(connector)
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	// SQL and TSV exports write TIMESTAMP values in UTC, and CSV exports
+	// convert them to -csv-time-zone, both from the instant the driver read
+	if config.ExportSQL || config.ExportLoadData || (config.ExportCSV && config.CSV.TimeZone != "") {
+		if err := checkSessionTimeZone(ctx, db, cfg.Loc); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// checkSessionTimeZone returns an error when the session time_zone and loc
+// disagree, since the driver reads TIMESTAMP values sent in the session
+// time zone as if they were in loc. A winter and a summer instant are
+// compared, so a fixed offset doesn't pass for a zone with daylight saving.
+func checkSessionTimeZone(ctx context.Context, db *sql.DB, loc *time.Location) error {
+	probes := timeZoneProbes(time.Now().Year())
+	query := "SELECT @@session.time_zone, DATE_FORMAT(FROM_UNIXTIME(?), '%Y-%m-%d %H:%i:%s'), DATE_FORMAT(FROM_UNIXTIME(?), '%Y-%m-%d %H:%i:%s')"
+	var zone string
+	server := make([]string, len(probes))
+	if err := db.QueryRowContext(ctx, query, probes[0].Unix(), probes[1].Unix()).Scan(&zone, &server[0], &server[1]); err != nil {
+		return fmt.Errorf("failed to read the session time zone: %v", err)
+	}
+	for i, probe := range probes {
+		if err := compareTimeZoneProbe(zone, loc, probe, server[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// timeZoneProbes are the instants checkSessionTimeZone compares.
+func timeZoneProbes(year int) []time.Time {
+	return []time.Time{
+		time.Date(year, time.January, 15, 12, 0, 0, 0, time.UTC),
+		time.Date(year, time.July, 15, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+// compareTimeZoneProbe compares the local time the server gave for probe
+// with the local time of probe in loc.
+func compareTimeZoneProbe(zone string, loc *time.Location, probe time.Time, server string) error {
+	if want := probe.In(loc).Format("2006-01-02 15:04:05"); server != want {
+		return fmt.Errorf("session time_zone %s does not match -loc=%s (%s UTC is %s in the session, %s in %s); set -session-time-zone to the zone of -loc so TIMESTAMP values are exported unchanged",
+			zone, loc, probe.Format("2006-01-02 15:04"), server, want, loc)
+	}
+	return nil
+}
+
+func (d *MySQLDialect) QuoteIdentifier(name string) string {
+	return quoteIdentifier(name)
+}
+
+func (d *MySQLDialect) Rebind(query string) string {
+	return query
+}
+
+func (d *MySQLDialect) FormatValue(val any, colType *sql.ColumnType) string {
+	typeName, fsp := "", int64(-1)
+	if colType != nil {
+		typeName = colType.DatabaseTypeName()
+		if _, scale, ok := colType.DecimalSize(); ok {
+			fsp = scale
+		}
+	}
+	return formatSQLValue(val, typeName, fsp)
+}
+
+func (d *MySQLDialect) TimeArg(t time.Time) any {
+	return t
+}
+
+func (d *MySQLDialect) CreateTableStatement(ctx context.Context, db *sql.DB, table string) (string, error) {
+	return getCreateTable(ctx, db, table)
+}
+
+// ArchiveTableStatement rewrites the SHOW CREATE TABLE statement with
+// suffixed index and constraint names and applies the -archive-* options.
+func (d *MySQLDialect) ArchiveTableStatement(createStmt, source, dest, suffix string, config *Config) (string, []string, error) {
+	stmt, err := modifyCreateStatement(createStmt, source, dest, suffix)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to rewrite CREATE TABLE: %v", err)
+	}
+	stmt, changes, err := adaptArchiveTable(stmt, config)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to adapt archive table: %v", err)
+	}
+	return stmt, changes, nil
+}
+
+func (d *MySQLDialect) Columns(ctx context.Context, db *sql.DB, table string) ([]Column, error) {
+	query := fmt.Sprintf("SHOW COLUMNS FROM %s", quoteIdentifier(table))
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []Column
+	for rows.Next() {
+		var field, colType string
+		var null, key, def, extra sql.NullString
+		if err := rows.Scan(&field, &colType, &null, &key, &def, &extra); err != nil {
+			return nil, err
+		}
+		columns = append(columns, Column{Name: field, Type: colType})
+	}
+
+	return columns, rows.Err()
+}
+
+func (d *MySQLDialect) PrimaryKey(ctx context.Context, db *sql.DB, table string) ([]string, error) {
+	query := "SELECT COLUMN_NAME FROM INFORMATION_SCHEMA.KEY_COLUMN_USAGE WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND CONSTRAINT_NAME = 'PRIMARY' ORDER BY ORDINAL_POSITION"
+	return queryStrings(ctx, db, query, table)
+}
+
+// RenameTable bounds the wait for the metadata lock with lock_wait_timeout
+// rather than a client-side deadline, so a rename that timed out can never
+// complete later on the server.
+func (d *MySQLDialect) RenameTable(ctx context.Context, db *sql.DB, from, to string, timeout time.Duration, logger *Logger) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if timeout > 0 {
+		seconds := int64(math.Ceil(timeout.Seconds()))
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("SET SESSION lock_wait_timeout = %d", seconds)); err != nil {
+			return err
+		}
+		// Don't hand the modified session back to the pool
+		defer conn.Raw(func(any) error { return driver.ErrBadConn })
+	}
+
+	query := fmt.Sprintf("RENAME TABLE %s TO %s", quoteIdentifier(from), quoteIdentifier(to))
+	logger.Info("Executing SQL: %s", query)
+	_, err = conn.ExecContext(ctx, query)
+	return err
+}
+
+func (d *MySQLDialect) DeleteLimit(table, condition string, limit int) string {
+	return fmt.Sprintf("DELETE FROM %s WHERE %s LIMIT %d", quoteIdentifier(table), condition, limit)
+}
+
+func (d *MySQLDialect) ConnectionID(ctx context.Context, conn *sql.Conn) (int64, error) {
+	var id int64
+	err := conn.QueryRowContext(ctx, "SELECT CONNECTION_ID()").Scan(&id)
+	return id, err
+}
+
+func (d *MySQLDialect) CancelQuery(ctx context.Context, db *sql.DB, connectionID int64) error {
+	_, err := db.ExecContext(ctx, fmt.Sprintf("KILL QUERY %d", connectionID))
+	return err
+}
+
+// BeginSnapshot sets the isolation level for the next transaction only, so
+// the session goes back to the pool unchanged.
+func (d *MySQLDialect) BeginSnapshot(ctx context.Context, conn *sql.Conn) (func(), error) {
+	if _, err := conn.ExecContext(ctx, "SET TRANSACTION ISOLATION LEVEL REPEATABLE READ"); err != nil {
+		return nil, err
+	}
+	if _, err := conn.ExecContext(ctx, "START TRANSACTION WITH CONSISTENT SNAPSHOT, READ ONLY"); err != nil {
+		return nil, err
+	}
+	return func() { conn.ExecContext(context.WithoutCancel(ctx), "COMMIT") }, nil
+}
+
+// BeginSharedSnapshot starts the snapshots while LOCK TABLES ... READ on
+// another connection holds off writes to table, so no write can commit
+// between them. Writers wait only until the last snapshot has started.
+func (d *MySQLDialect) BeginSharedSnapshot(ctx context.Context, db *sql.DB, table string, conns []*sql.Conn) (func(), error) {
+	lock, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Close()
+	if _, err := lock.ExecContext(ctx, fmt.Sprintf("LOCK TABLES %s READ", quoteIdentifier(table))); err != nil {
+		return nil, fmt.Errorf("failed to lock %s: %v", table, err)
+	}
+	// Disconnecting releases the lock even if UNLOCK TABLES fails
+	defer lock.Raw(func(any) error { return driver.ErrBadConn })
+	defer lock.ExecContext(context.WithoutCancel(ctx), "UNLOCK TABLES")
+
+	var ends []func()
+	for _, conn := range conns {
+		end, err := d.BeginSnapshot(ctx, conn)
+		if err != nil {
+			endSnapshots(ends)()
+			return nil, err
+		}
+		ends = append(ends, end)
+	}
+	return endSnapshots(ends), nil
+}
+
+// ChecksumAggregate XORs the CRC32 of each row, as pt-table-checksum does.
+// CONCAT_WS skips NULLs, so a flag per column tells NULL from empty.
+func (d *MySQLDialect) ChecksumAggregate(columns []string) string {
+	isNull := make([]string, len(columns))
+	for i, column := range columns {
+		isNull[i] = "ISNULL(" + column + ")"
+	}
+	return fmt.Sprintf("COALESCE(BIT_XOR(CRC32(CONCAT_WS('#', %s, CONCAT(%s)))), 0)",
+		strings.Join(columns, ", "), strings.Join(isNull, ", "))
+}
+
+func (d *MySQLDialect) DumpSettings() (string, string) {
+	begin := `SET @OLD_UNIQUE_CHECKS=@@UNIQUE_CHECKS, UNIQUE_CHECKS=0;
+SET @OLD_FOREIGN_KEY_CHECKS=@@FOREIGN_KEY_CHECKS, FOREIGN_KEY_CHECKS=0;
+SET @OLD_SQL_MODE=@@SQL_MODE, SQL_MODE='NO_AUTO_VALUE_ON_ZERO';
+SET @OLD_TIME_ZONE=@@TIME_ZONE, TIME_ZONE='+00:00';
+`
+	end := `SET SQL_MODE=@OLD_SQL_MODE;
+SET FOREIGN_KEY_CHECKS=@OLD_FOREIGN_KEY_CHECKS;
+SET UNIQUE_CHECKS=@OLD_UNIQUE_CHECKS;
+SET TIME_ZONE=@OLD_TIME_ZONE;
+`
+	return begin, end
+}
+
+func (d *MySQLDialect) DumpDataLock(table string) (string, string) {
+	return fmt.Sprintf("LOCK TABLES %s WRITE;\n", quoteIdentifier(table)), "UNLOCK TABLES;\n"
+}
+
+func getCreateTable(ctx context.Context, db *sql.DB, tableName string) (string, error) {
+	var table, createStmt string
+	query := fmt.Sprintf("SHOW CREATE TABLE %s", quoteIdentifier(tableName))
+	err := db.QueryRowContext(ctx, query).Scan(&table, &createStmt)
+	return createStmt, err
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PostgresDialect implements Dialect for PostgreSQL. Tables are looked up in
+// the first schema of the connection's search_path.
+type PostgresDialect struct{}
+
+func (d *PostgresDialect) Name() string {
+	return "PostgreSQL"
+}
+
+func (d *PostgresDialect) Open(ctx context.Context, config *Config, logger *Logger) (*sql.DB, error) {
+	dsn, err := postgresDSN(config)
+	if err != nil {
+		return nil, err
+	}
+
+	addr := fmt.Sprintf("%s:%d", config.Host, config.Port)
+	if config.Socket != "" {
+		addr = config.Socket
+	}
+	logger.Info("Connecting to database %s@%s/%s", config.User, addr, config.Database)
+
+	connector, err := pq.NewConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	db := sql.OpenDB(connector)
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// postgresDSN builds a libpq connection string from config, mapping the
+// MySQL style -tls modes onto sslmode.
+func postgresDSN(config *Config) (string, error) {
+	params := map[string]string{
+		"user":   config.User,
+		"dbname": config.Database,
+	}
+	if config.Password != "" {
+		params["password"] = config.Password
+	}
+
+	if config.Socket != "" {
+		// libpq takes the directory holding the socket as the host
+		params["host"] = config.Socket
+		if i := strings.LastIndex(config.Socket, "/.s.PGSQL."); i >= 0 {
+			params["host"] = config.Socket[:i]
+		}
+	} else {
+		params["host"] = config.Host
+	}
+	params["port"] = strconv.Itoa(config.Port)
+
+	if config.ConnectTimeout > 0 {
+		params["connect_timeout"] = strconv.Itoa(int(math.Ceil(config.ConnectTimeout.Seconds())))
+	}
+	if config.Charset != "" {
+		params["client_encoding"] = config.Charset
+	}
+	if config.SessionTimeZone != "" {
+		params["timezone"] = config.SessionTimeZone
+	}
+
+	customTLS := config.TLSCA != "" || config.TLSCert != "" || config.TLSKey != ""
+	switch config.TLSMode {
+	case "false":
+		params["sslmode"] = "disable"
+		if customTLS {
+			params["sslmode"] = "verify-full"
+		}
+	case "true":
+		params["sslmode"] = "verify-full"
+	case "skip-verify":
+		params["sslmode"] = "require"
+	case "preferred":
+		params["sslmode"] = "prefer"
+	default:
+		return "", fmt.Errorf("invalid -tls mode %q", config.TLSMode)
+	}
+	if config.TLSCA != "" {
+		params["sslrootcert"] = config.TLSCA
+	}
+	if config.TLSCert != "" || config.TLSKey != "" {
+		if config.TLSCert == "" || config.TLSKey == "" {
+			return "", fmt.Errorf("both -tls-cert and -tls-key are required for client certificates")
+		}
+		params["sslcert"] = config.TLSCert
+		params["sslkey"] = config.TLSKey
+	}
+
+	var parts []string
+	for _, key := range []string{"host", "port", "user", "password", "dbname", "sslmode", "sslrootcert", "sslcert", "sslkey", "connect_timeout", "client_encoding", "timezone"} {
+		if value, ok := params[key]; ok {
+			value = strings.ReplaceAll(value, `\`, `\\`)
+			value = strings.ReplaceAll(value, `'`, `\'`)
+			parts = append(parts, fmt.Sprintf("%s='%s'", key, value))
+		}
+	}
+	return strings.Join(parts, " "), nil
+}
+
+func (d *PostgresDialect) QuoteIdentifier(name string) string {
+	return pq.QuoteIdentifier(name)
+}
+
+// Rebind numbers the ? placeholders as $1, $2, ...
+func (d *PostgresDialect) Rebind(query string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (d *PostgresDialect) FormatValue(val any, colType *sql.ColumnType) string {
+	switch v := val.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		if colType != nil && colType.DatabaseTypeName() == "BYTEA" {
+			return `'\x` + hex.EncodeToString(v) + `'`
+		}
+		return pq.QuoteLiteral(string(v))
+	case string:
+		return pq.QuoteLiteral(v)
+	case time.Time:
+		return "'" + v.Format("2006-01-02 15:04:05.999999Z07:00") + "'"
+	case bool:
+		if v {
+			return "TRUE"
+		}
+		return "FALSE"
+	case int64, float64:
+		return fmt.Sprintf("%v", v)
+	default:
+		return pq.QuoteLiteral(fmt.Sprintf("%v", v))
+	}
+}
+
+func (d *PostgresDialect) TimeArg(t time.Time) any {
+	return t
+}
+
+// CreateTableStatement assembles a CREATE TABLE statement from the catalog,
+// followed by CREATE INDEX statements for indexes that back no constraint.
+// Foreign keys are left out, as in the archive table itself.
+func (d *PostgresDialect) CreateTableStatement(ctx context.Context, db *sql.DB, table string) (string, error) {
+	query := `SELECT a.attname, format_type(a.atttypid, a.atttypmod), a.attnotnull, pg_get_expr(ad.adbin, ad.adrelid)
+		FROM pg_attribute a
+		LEFT JOIN pg_attrdef ad ON ad.adrelid = a.attrelid AND ad.adnum = a.attnum
+		WHERE a.attrelid = to_regclass($1) AND a.attnum > 0 AND NOT a.attisdropped
+		ORDER BY a.attnum`
+	rows, err := db.QueryContext(ctx, query, pq.QuoteIdentifier(table))
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var definitions []string
+	for rows.Next() {
+		var name, colType string
+		var notNull bool
+		var def sql.NullString
+		if err := rows.Scan(&name, &colType, &notNull, &def); err != nil {
+			return "", err
+		}
+		definition := pq.QuoteIdentifier(name) + " " + colType
+		if notNull {
+			definition += " NOT NULL"
+		}
+		if def.Valid {
+			definition += " DEFAULT " + def.String
+		}
+		definitions = append(definitions, definition)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	if len(definitions) == 0 {
+		return "", fmt.Errorf("table %s does not exist", table)
+	}
+
+	query = `SELECT conname, pg_get_constraintdef(oid) FROM pg_constraint
+		WHERE conrelid = to_regclass($1) AND contype IN ('p', 'u', 'c')
+		ORDER BY contype DESC, conname`
+	rows, err = db.QueryContext(ctx, query, pq.QuoteIdentifier(table))
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, def string
+		if err := rows.Scan(&name, &def); err != nil {
+			return "", err
+		}
+		definitions = append(definitions, fmt.Sprintf("CONSTRAINT %s %s", pq.QuoteIdentifier(name), def))
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	stmt := fmt.Sprintf("CREATE TABLE %s (\n  %s\n)", pq.QuoteIdentifier(table), strings.Join(definitions, ",\n  "))
+
+	query = `SELECT pg_get_indexdef(i.indexrelid) FROM pg_index i
+		WHERE i.indrelid = to_regclass($1)
+		AND NOT EXISTS (SELECT 1 FROM pg_constraint c WHERE c.conindid = i.indexrelid)
+		ORDER BY i.indexrelid`
+	indexes, err := queryStrings(ctx, db, query, pq.QuoteIdentifier(table))
+	if err != nil {
+		return "", err
+	}
+	for _, index := range indexes {
+		stmt += ";\n" + index
+	}
+
+	return stmt, nil
+}
+
+// ArchiveTableStatement copies the structure with CREATE TABLE ... LIKE,
+// which lets the server name the copied indexes. The copy takes its ids from
+// the archived rows, so identity columns and serial defaults are left out:
+// the archive table must neither generate ids nor depend on the sequences
+// of the source table.
+func (d *PostgresDialect) ArchiveTableStatement(createStmt, source, dest, suffix string, config *Config) (string, []string, error) {
+	statements := []string{fmt.Sprintf("CREATE TABLE %s (LIKE %s INCLUDING ALL EXCLUDING IDENTITY)", pq.QuoteIdentifier(dest), pq.QuoteIdentifier(source))}
+	var changes []string
+	for _, column := range sequenceDefaultColumns(createStmt) {
+		statements = append(statements, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s DROP DEFAULT", pq.QuoteIdentifier(dest), pq.QuoteIdentifier(column)))
+		changes = append(changes, fmt.Sprintf("dropped the sequence default of column %s", column))
+	}
+	return strings.Join(statements, ";\n"), changes, nil
+}
+
+// sequenceDefaultColumns returns the columns of a CreateTableStatement
+// statement whose default takes the next value of a sequence.
+func sequenceDefaultColumns(createStmt string) []string {
+	var columns []string
+	for _, line := range strings.Split(createStmt, "\n") {
+		name, rest, ok := cutQuotedIdentifier(strings.TrimPrefix(line, "  "))
+		if !ok {
+			continue
+		}
+		// Neither the name nor the type can hold " DEFAULT "
+		if i := strings.Index(rest, " DEFAULT "); i >= 0 && strings.HasPrefix(rest[i+len(" DEFAULT "):], "nextval(") {
+			columns = append(columns, name)
+		}
+	}
+	return columns
+}
+
+// cutQuotedIdentifier splits a double-quoted identifier off the start of s.
+func cutQuotedIdentifier(s string) (name, rest string, ok bool) {
+	if !strings.HasPrefix(s, `"`) {
+		return "", s, false
+	}
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		if s[i] != '"' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == '"' {
+			b.WriteByte('"')
+			i++
+			continue
+		}
+		return b.String(), s[i+1:], true
+	}
+	return "", s, false
+}
+
+func (d *PostgresDialect) Columns(ctx context.Context, db *sql.DB, table string) ([]Column, error) {
+	query := `SELECT column_name, data_type FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = $1
+		ORDER BY ordinal_position`
+	rows, err := db.QueryContext(ctx, query, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []Column
+	for rows.Next() {
+		var column Column
+		if err := rows.Scan(&column.Name, &column.Type); err != nil {
+			return nil, err
+		}
+		columns = append(columns, column)
+	}
+
+	return columns, rows.Err()
+}
+
+func (d *PostgresDialect) PrimaryKey(ctx context.Context, db *sql.DB, table string) ([]string, error) {
+	query := `SELECT kcu.column_name
+		FROM information_schema.table_constraints tc
+		JOIN information_schema.key_column_usage kcu
+			ON kcu.constraint_schema = tc.constraint_schema AND kcu.constraint_name = tc.constraint_name
+		WHERE tc.table_schema = current_schema() AND tc.table_name = $1 AND tc.constraint_type = 'PRIMARY KEY'
+		ORDER BY kcu.ordinal_position`
+	return queryStrings(ctx, db, query, table)
+}
+
+// RenameTable bounds the wait for the table lock with lock_timeout, so a
+// rename that timed out can never complete later on the server.
+func (d *PostgresDialect) RenameTable(ctx context.Context, db *sql.DB, from, to string, timeout time.Duration, logger *Logger) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if timeout > 0 {
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("SET lock_timeout = %d", timeout.Milliseconds())); err != nil {
+			return err
+		}
+		// Don't hand the modified session back to the pool
+		defer conn.Raw(func(any) error { return driver.ErrBadConn })
+	}
+
+	query := fmt.Sprintf("ALTER TABLE %s RENAME TO %s", pq.QuoteIdentifier(from), pq.QuoteIdentifier(to))
+	logger.Info("Executing SQL: %s", query)
+	_, err = conn.ExecContext(ctx, query)
+	return err
+}
+
+// DeleteLimit selects the rows to delete by ctid, since PostgreSQL has no
+// DELETE ... LIMIT.
+func (d *PostgresDialect) DeleteLimit(table, condition string, limit int) string {
+	quoted := pq.QuoteIdentifier(table)
+	return fmt.Sprintf("DELETE FROM %s WHERE ctid IN (SELECT ctid FROM %s WHERE %s LIMIT %d)", quoted, quoted, condition, limit)
+}
+
+func (d *PostgresDialect) ConnectionID(ctx context.Context, conn *sql.Conn) (int64, error) {
+	var id int64
+	err := conn.QueryRowContext(ctx, "SELECT pg_backend_pid()").Scan(&id)
+	return id, err
+}
+
+func (d *PostgresDialect) CancelQuery(ctx context.Context, db *sql.DB, connectionID int64) error {
+	_, err := db.ExecContext(ctx, "SELECT pg_cancel_backend($1)", connectionID)
+	return err
+}
+
+func (d *PostgresDialect) BeginSnapshot(ctx context.Context, conn *sql.Conn) (func(), error) {
+	if _, err := conn.ExecContext(ctx, "BEGIN ISOLATION LEVEL REPEATABLE READ, READ ONLY"); err != nil {
+		return nil, err
+	}
+	return func() { conn.ExecContext(context.WithoutCancel(ctx), "COMMIT") }, nil
+}
+
+// BeginSharedSnapshot exports the snapshot of the first connection with
+// pg_export_snapshot() and imports it on the others. The first transaction
+// stays open until the others end, as the import requires.
+func (d *PostgresDialect) BeginSharedSnapshot(ctx context.Context, db *sql.DB, table string, conns []*sql.Conn) (func(), error) {
+	first, err := d.BeginSnapshot(ctx, conns[0])
+	if err != nil {
+		return nil, err
+	}
+	ends := []func(){first}
+
+	var snapshot string
+	if err := conns[0].QueryRowContext(ctx, "SELECT pg_export_snapshot()").Scan(&snapshot); err != nil {
+		first()
+		return nil, fmt.Errorf("failed to export snapshot: %v", err)
+	}
+	for _, conn := range conns[1:] {
+		end, err := d.BeginSnapshot(ctx, conn)
+		if err == nil {
+			// Run the imports' ends before the exporting transaction's
+			ends = append([]func(){end}, ends...)
+			_, err = conn.ExecContext(ctx, "SET TRANSACTION SNAPSHOT "+pq.QuoteLiteral(snapshot))
+		}
+		if err != nil {
+			endSnapshots(ends)()
+			return nil, fmt.Errorf("failed to import snapshot: %v", err)
+		}
+	}
+	return endSnapshots(ends), nil
+}
+
+// ChecksumAggregate hashes the MD5 of each row's text form, sorted so the
+// result does not depend on the order rows are read in.
+func (d *PostgresDialect) ChecksumAggregate(columns []string) string {
+	row := fmt.Sprintf("md5(ROW(%s)::text)", strings.Join(columns, ", "))
+	return fmt.Sprintf("COALESCE(md5(string_agg(%s, '' ORDER BY %s)), '')", row, row)
+}
+
+func (d *PostgresDialect) DumpSettings() (string, string) {
+	return "SET client_encoding = 'UTF8';\nSET standard_conforming_strings = on;\n", ""
+}
+
+func (d *PostgresDialect) DumpDataLock(table string) (string, string) {
+	return "BEGIN;\n", "COMMIT;\n"
+}
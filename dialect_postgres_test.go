@@ -0,0 +1,159 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPostgresDSN(t *testing.T) {
+	base := Config{Host: "pg.internal", Port: 5432, User: "archiver", Database: "sms_db", TLSMode: "false"}
+	tests := []struct {
+		name    string
+		modify  func(*Config)
+		want    string
+		wantErr string
+	}{
+		{
+			name: "plain",
+			want: "host='pg.internal' port='5432' user='archiver' dbname='sms_db' sslmode='disable'",
+		},
+		{
+			name: "password and options",
+			modify: func(c *Config) {
+				c.Password = `it's a \ secret`
+				c.ConnectTimeout = 1500 * time.Millisecond
+				c.Charset = "UTF8"
+				c.SessionTimeZone = "Africa/Lagos"
+			},
+			want: `host='pg.internal' port='5432' user='archiver' password='it\'s a \\ secret' dbname='sms_db' sslmode='disable' connect_timeout='2' client_encoding='UTF8' timezone='Africa/Lagos'`,
+		},
+		{
+			name:   "socket file",
+			modify: func(c *Config) { c.Socket = "/var/run/postgresql/.s.PGSQL.5432" },
+			want:   "host='/var/run/postgresql' port='5432' user='archiver' dbname='sms_db' sslmode='disable'",
+		},
+		{
+			name:   "socket directory",
+			modify: func(c *Config) { c.Socket = "/tmp" },
+			want:   "host='/tmp' port='5432' user='archiver' dbname='sms_db' sslmode='disable'",
+		},
+		{
+			name:   "verify",
+			modify: func(c *Config) { c.TLSMode = "true"; c.TLSCA = "/etc/ssl/ca.pem" },
+			want:   "host='pg.internal' port='5432' user='archiver' dbname='sms_db' sslmode='verify-full' sslrootcert='/etc/ssl/ca.pem'",
+		},
+		{
+			name:   "custom CA without -tls",
+			modify: func(c *Config) { c.TLSCA = "/etc/ssl/ca.pem" },
+			want:   "host='pg.internal' port='5432' user='archiver' dbname='sms_db' sslmode='verify-full' sslrootcert='/etc/ssl/ca.pem'",
+		},
+		{
+			name:   "skip verify",
+			modify: func(c *Config) { c.TLSMode = "skip-verify" },
+			want:   "host='pg.internal' port='5432' user='archiver' dbname='sms_db' sslmode='require'",
+		},
+		{
+			name:   "preferred",
+			modify: func(c *Config) { c.TLSMode = "preferred" },
+			want:   "host='pg.internal' port='5432' user='archiver' dbname='sms_db' sslmode='prefer'",
+		},
+		{
+			name:    "invalid mode",
+			modify:  func(c *Config) { c.TLSMode = "maybe" },
+			wantErr: "invalid -tls mode",
+		},
+		{
+			name:    "certificate without key",
+			modify:  func(c *Config) { c.TLSMode = "true"; c.TLSCert = "/etc/ssl/client.pem" },
+			wantErr: "both -tls-cert and -tls-key",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := base
+			if tt.modify != nil {
+				tt.modify(&config)
+			}
+			got, err := postgresDSN(&config)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("postgresDSN error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("postgresDSN:\n got %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPostgresRebind(t *testing.T) {
+	d := &PostgresDialect{}
+	tests := []struct{ in, want string }{
+		{"SELECT 1", "SELECT 1"},
+		{"SELECT * FROM t WHERE a < ? AND b > ?", "SELECT * FROM t WHERE a < $1 AND b > $2"},
+		{"DELETE FROM \"tàble\" WHERE id IN (?, ?, ?)", "DELETE FROM \"tàble\" WHERE id IN ($1, $2, $3)"},
+	}
+	for _, tt := range tests {
+		if got := d.Rebind(tt.in); got != tt.want {
+			t.Errorf("Rebind(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPostgresFormatValue(t *testing.T) {
+	d := &PostgresDialect{}
+	tests := []struct {
+		val  any
+		want string
+	}{
+		{nil, "NULL"},
+		{"it's", "'it''s'"},
+		// pq.QuoteLiteral puts a space before escape string literals
+		{`back\slash`, ` E'back\\slash'`},
+		{[]byte("text"), "'text'"},
+		{true, "TRUE"},
+		{false, "FALSE"},
+		{int64(-42), "-42"},
+		{1.5, "1.5"},
+		{time.Date(2024, time.March, 4, 5, 6, 7, 890000000, time.UTC), "'2024-03-04 05:06:07.89Z'"},
+		{time.Date(2024, time.March, 4, 5, 6, 7, 0, time.FixedZone("", 3600)), "'2024-03-04 05:06:07+01:00'"},
+		{int32(7), "'7'"},
+	}
+	for _, tt := range tests {
+		if got := d.FormatValue(tt.val, nil); got != tt.want {
+			t.Errorf("FormatValue(%#v) = %s, want %s", tt.val, got, tt.want)
+		}
+	}
+}
+
+func TestPostgresArchiveTableStatement(t *testing.T) {
+	createStmt := "CREATE TABLE \"sms_log\" (\n" +
+		"  \"id\" bigint NOT NULL DEFAULT nextval('sms_log_id_seq'::regclass),\n" +
+		"  \"Odd \"\"name\"\"\" integer DEFAULT nextval('odd_seq'::regclass),\n" +
+		"  \"seq_no\" integer NOT NULL,\n" +
+		"  \"note\" text DEFAULT 'DEFAULT nextval(x)'::text,\n" +
+		"  \"created_at\" timestamp without time zone NOT NULL DEFAULT now(),\n" +
+		"  CONSTRAINT \"sms_log_pkey\" PRIMARY KEY (id)\n" +
+		");\n" +
+		"CREATE INDEX idx_created_at ON public.sms_log USING btree (created_at)"
+
+	got, changes, err := (&PostgresDialect{}).ArchiveTableStatement(createStmt, "sms_log", "sms_log_20240304", "20240304", &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "CREATE TABLE \"sms_log_20240304\" (LIKE \"sms_log\" INCLUDING ALL EXCLUDING IDENTITY);\n" +
+		"ALTER TABLE \"sms_log_20240304\" ALTER COLUMN \"id\" DROP DEFAULT;\n" +
+		"ALTER TABLE \"sms_log_20240304\" ALTER COLUMN \"Odd \"\"name\"\"\" DROP DEFAULT"
+	if got != want {
+		t.Errorf("statement:\n got %s\nwant %s", got, want)
+	}
+	if len(changes) != 2 {
+		t.Errorf("changes = %q, want two", changes)
+	}
+}
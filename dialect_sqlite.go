@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+)
+
+func init() {
+	// SQLite has no hash functions of its own
+	sqlite.MustRegisterDeterministicScalarFunction("crc32", 1, func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		switch v := args[0].(type) {
+		case nil:
+			return nil, nil
+		case string:
+			return int64(crc32.ChecksumIEEE([]byte(v))), nil
+		case []byte:
+			return int64(crc32.ChecksumIEEE(v)), nil
+		default:
+			return int64(crc32.ChecksumIEEE([]byte(fmt.Sprint(v)))), nil
+		}
+	})
+}
+
+// sqliteTimeLayout is how dates are stored as text and compared in SQLite,
+// matching its own date and time functions.
+const sqliteTimeLayout = "2006-01-02 15:04:05"
+
+// createIndexPattern matches the head of a CREATE INDEX statement up to the
+// opening parenthesis of the column list, capturing UNIQUE and the index name.
+var createIndexPattern = regexp.MustCompile("(?is)^CREATE\\s+(UNIQUE\\s+)?INDEX\\s+(?:IF\\s+NOT\\s+EXISTS\\s+)?" +
+	"(\"(?:[^\"]|\"\")+\"|`[^`]+`|\\[[^\\]]+\\]|[^\\s(]+)\\s+ON\\s+" +
+	"(?:\"(?:[^\"]|\"\")+\"|`[^`]+`|\\[[^\\]]+\\]|[^\\s(]+)\\s*\\(")
+
+// SQLiteDialect implements Dialect for SQLite databases, where -database is
+// the path of the database file.
+type SQLiteDialect struct{}
+
+func (d *SQLiteDialect) Name() string {
+	return "SQLite"
+}
+
+// Open opens an existing database file; a missing file is an error rather
+// than silently creating an empty database.
+func (d *SQLiteDialect) Open(ctx context.Context, config *Config, logger *Logger) (*sql.DB, error) {
+	logger.Info("Opening SQLite database %s", config.Database)
+
+	params := url.Values{}
+	params.Set("mode", "rw")
+	params.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", config.ConnectTimeout.Milliseconds()))
+	db, err := sql.Open("sqlite", "file:"+config.Database+"?"+params.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func (d *SQLiteDialect) QuoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func (d *SQLiteDialect) Rebind(query string) string {
+	return query
+}
+
+func (d *SQLiteDialect) FormatValue(val any, colType *sql.ColumnType) string {
+	switch v := val.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		if colType != nil && strings.EqualFold(colType.DatabaseTypeName(), "BLOB") {
+			return "X'" + hex.EncodeToString(v) + "'"
+		}
+		return sqliteQuote(string(v))
+	case string:
+		return sqliteQuote(v)
+	case time.Time:
+		return "'" + v.UTC().Format(sqliteTimeLayout+".999999999") + "'"
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case int64, float64:
+		return fmt.Sprintf("%v", v)
+	default:
+		return sqliteQuote(fmt.Sprintf("%v", v))
+	}
+}
+
+func sqliteQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// TimeArg formats t as text, since SQLite compares dates stored as text
+// character by character.
+func (d *SQLiteDialect) TimeArg(t time.Time) any {
+	return t.UTC().Format(sqliteTimeLayout)
+}
+
+// CreateTableStatement returns the original CREATE TABLE statement from
+// sqlite_master followed by the CREATE INDEX statements of the table.
+func (d *SQLiteDialect) CreateTableStatement(ctx context.Context, db *sql.DB, table string) (string, error) {
+	var createStmt string
+	err := db.QueryRowContext(ctx, "SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&createStmt)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("table %s does not exist", table)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// Indexes created for PRIMARY KEY and UNIQUE constraints have no SQL
+	indexes, err := queryStrings(ctx, db, "SELECT sql FROM sqlite_master WHERE type = 'index' AND tbl_name = ? AND sql IS NOT NULL ORDER BY name", table)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join(append([]string{createStmt}, indexes...), ";\n"), nil
+}
+
+// ArchiveTableStatement renames the table in the CREATE TABLE statement and
+// suffixes the index names, which must be unique per database.
+func (d *SQLiteDialect) ArchiveTableStatement(createStmt, source, dest, suffix string, config *Config) (string, []string, error) {
+	statements := strings.Split(createStmt, ";\n")
+
+	open := strings.Index(statements[0], "(")
+	if open < 0 {
+		return "", nil, fmt.Errorf("unexpected CREATE TABLE statement for %s", source)
+	}
+	statements[0] = fmt.Sprintf("CREATE TABLE %s %s", d.QuoteIdentifier(dest), statements[0][open:])
+
+	for i, stmt := range statements[1:] {
+		m := createIndexPattern.FindStringSubmatchIndex(stmt)
+		if m == nil {
+			return "", nil, fmt.Errorf("unexpected CREATE INDEX statement: %s", truncateSQL(stmt, 100))
+		}
+		unique := ""
+		if m[2] >= 0 {
+			unique = "UNIQUE "
+		}
+		name := unquoteSQLiteIdentifier(stmt[m[4]:m[5]])
+		statements[i+1] = fmt.Sprintf("CREATE %sINDEX %s ON %s (%s",
+			unique, d.QuoteIdentifier(suffixedName(name, suffix)), d.QuoteIdentifier(dest), stmt[m[1]:])
+	}
+
+	return strings.Join(statements, ";\n"), nil, nil
+}
+
+func unquoteSQLiteIdentifier(name string) string {
+	switch {
+	case strings.HasPrefix(name, `"`):
+		return strings.ReplaceAll(name[1:len(name)-1], `""`, `"`)
+	case strings.HasPrefix(name, "`"), strings.HasPrefix(name, "["):
+		return name[1 : len(name)-1]
+	}
+	return name
+}
+
+func (d *SQLiteDialect) Columns(ctx context.Context, db *sql.DB, table string) ([]Column, error) {
+	columns, _, err := sqliteTableInfo(ctx, db, d.QuoteIdentifier(table))
+	return columns, err
+}
+
+// PrimaryKey returns the declared primary key, or rowid for tables without
+// one, so chunked copies can always walk the table in key order.
+func (d *SQLiteDialect) PrimaryKey(ctx context.Context, db *sql.DB, table string) ([]string, error) {
+	_, pk, err := sqliteTableInfo(ctx, db, d.QuoteIdentifier(table))
+	if err != nil {
+		return nil, err
+	}
+	if len(pk) == 0 {
+		return []string{"rowid"}, nil
+	}
+	return pk, nil
+}
+
+// sqliteTableInfo returns the columns and primary key columns of a table
+// from PRAGMA table_info.
+func sqliteTableInfo(ctx context.Context, db *sql.DB, quotedTable string) ([]Column, []string, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("PRAGMA table_info(%s)", quotedTable))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var columns []Column
+	keyPositions := map[int]string{}
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var def sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &def, &pk); err != nil {
+			return nil, nil, err
+		}
+		columns = append(columns, Column{Name: name, Type: colType})
+		if pk > 0 {
+			keyPositions[pk] = name
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(columns) == 0 {
+		return nil, nil, fmt.Errorf("table %s does not exist", quotedTable)
+	}
+
+	pk := make([]string, len(keyPositions))
+	for position, name := range keyPositions {
+		pk[position-1] = name
+	}
+	return columns, pk, nil
+}
+
+// RenameTable waits at most timeout for other connections' locks through
+// busy_timeout.
+func (d *SQLiteDialect) RenameTable(ctx context.Context, db *sql.DB, from, to string, timeout time.Duration, logger *Logger) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if timeout > 0 {
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("PRAGMA busy_timeout = %d", timeout.Milliseconds())); err != nil {
+			return err
+		}
+		// Don't hand the modified session back to the pool
+		defer conn.Raw(func(any) error { return driver.ErrBadConn })
+	}
+
+	query := fmt.Sprintf("ALTER TABLE %s RENAME TO %s", d.QuoteIdentifier(from), d.QuoteIdentifier(to))
+	logger.Info("Executing SQL: %s", query)
+	_, err = conn.ExecContext(ctx, query)
+	return err
+}
+
+// DeleteLimit selects the rows to delete by rowid, since DELETE ... LIMIT
+// is only available in SQLite builds compiled with it.
+func (d *SQLiteDialect) DeleteLimit(table, condition string, limit int) string {
+	quoted := d.QuoteIdentifier(table)
+	return fmt.Sprintf("DELETE FROM %s WHERE rowid IN (SELECT rowid FROM %s WHERE %s LIMIT %d)", quoted, quoted, condition, limit)
+}
+
+// ConnectionID is unsupported: the driver interrupts and rolls back a
+// statement itself when its context is cancelled.
+func (d *SQLiteDialect) ConnectionID(ctx context.Context, conn *sql.Conn) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
+
+func (d *SQLiteDialect) CancelQuery(ctx context.Context, db *sql.DB, connectionID int64) error {
+	return errors.ErrUnsupported
+}
+
+// BeginSnapshot opens a read transaction, which sees a single snapshot of
+// the database until it ends. Writers are only kept waiting when the
+// database is not in WAL mode.
+func (d *SQLiteDialect) BeginSnapshot(ctx context.Context, conn *sql.Conn) (func(), error) {
+	if _, err := conn.ExecContext(ctx, "BEGIN"); err != nil {
+		return nil, err
+	}
+	return func() { conn.ExecContext(context.WithoutCancel(ctx), "COMMIT") }, nil
+}
+
+// BeginSharedSnapshot starts the read transactions while another connection
+// holds the write lock, and reads in each so its snapshot is taken then
+// rather than at its first query.
+func (d *SQLiteDialect) BeginSharedSnapshot(ctx context.Context, db *sql.DB, table string, conns []*sql.Conn) (func(), error) {
+	lock, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Close()
+	if _, err := lock.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		return nil, fmt.Errorf("failed to lock database: %v", err)
+	}
+	defer lock.ExecContext(context.WithoutCancel(ctx), "ROLLBACK")
+
+	var ends []func()
+	for _, conn := range conns {
+		end, err := d.BeginSnapshot(ctx, conn)
+		if err == nil {
+			ends = append(ends, end)
+			var n int64
+			err = conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master").Scan(&n)
+		}
+		if err != nil {
+			endSnapshots(ends)()
+			return nil, err
+		}
+	}
+	return endSnapshots(ends), nil
+}
+
+// ChecksumAggregate sums the CRC32 of each row's quoted values, using the
+// crc32 function registered with the driver.
+func (d *SQLiteDialect) ChecksumAggregate(columns []string) string {
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = "quote(" + column + ")"
+	}
+	return fmt.Sprintf("COALESCE(SUM(crc32(%s)), 0)", strings.Join(quoted, " || ',' || "))
+}
+
+func (d *SQLiteDialect) DumpSettings() (string, string) {
+	return "PRAGMA foreign_keys=OFF;\n", ""
+}
+
+func (d *SQLiteDialect) DumpDataLock(table string) (string, string) {
+	return "BEGIN TRANSACTION;\n", "COMMIT;\n"
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSQLiteArchiveTableStatement(t *testing.T) {
+	createStmt := "CREATE TABLE sms_log (id INTEGER PRIMARY KEY, created_at DATETIME);\n" +
+		"CREATE INDEX idx_created ON sms_log(created_at);\n" +
+		"CREATE UNIQUE INDEX IF NOT EXISTS \"uq \"\"msisdn\"\"\" ON \"sms_log\" (id, created_at)"
+	want := "CREATE TABLE \"sms_log_20240101\" (id INTEGER PRIMARY KEY, created_at DATETIME);\n" +
+		"CREATE INDEX \"idx_created_20240101\" ON \"sms_log_20240101\" (created_at);\n" +
+		"CREATE UNIQUE INDEX \"uq \"\"msisdn\"\"_20240101\" ON \"sms_log_20240101\" (id, created_at)"
+
+	got, changes, err := (&SQLiteDialect{}).ArchiveTableStatement(createStmt, "sms_log", "sms_log_20240101", "20240101", &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if len(changes) != 0 {
+		t.Errorf("unexpected changes %v", changes)
+	}
+}
+
+func TestSQLitePrimaryKey(t *testing.T) {
+	db, _ := openTestDB(t)
+	mustExec(t, db, "CREATE TABLE keyed (a TEXT, b INTEGER, c TEXT, PRIMARY KEY (b, a))")
+	mustExec(t, db, "CREATE TABLE unkeyed (a TEXT)")
+
+	dialect := &SQLiteDialect{}
+	for table, want := range map[string][]string{"keyed": {"b", "a"}, "unkeyed": {"rowid"}} {
+		got, err := dialect.PrimaryKey(context.Background(), db, table)
+		if err != nil {
+			t.Fatalf("%s: %v", table, err)
+		}
+		if len(got) != len(want) || got[0] != want[0] || got[len(got)-1] != want[len(want)-1] {
+			t.Errorf("%s: primary key %v, want %v", table, got, want)
+		}
+	}
+
+	if _, err := dialect.PrimaryKey(context.Background(), db, "missing"); err == nil {
+		t.Error("no error for a missing table")
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// sessionTimeZonePattern matches the values MySQL accepts for time_zone:
+// SYSTEM, an offset from UTC or a named zone.
+var sessionTimeZonePattern = regexp.MustCompile(`^(SYSTEM|[+-][0-9]{1,2}:[0-9]{2}|[A-Za-z][A-Za-z0-9_+-]*(/[A-Za-z0-9_+-]+)*)$`)
+
+// driverUTC is the location the driver reads DATETIME values in with -loc=UTC.
+var driverUTC = time.FixedZone("UTC", 0)
+
+// mysqlConfig builds the driver configuration for config, including TLS,
+// socket, timeout, charset and time zone settings.
+func mysqlConfig(config *Config) (*mysql.Config, error) {
+	cfg := mysql.NewConfig()
+	cfg.User = config.User
+	cfg.Passwd = config.Password
+	cfg.DBName = config.Database
+	cfg.ParseTime = true
+
+	if config.Socket != "" {
+		cfg.Net = "unix"
+		cfg.Addr = config.Socket
+	} else {
+		cfg.Net = "tcp"
+		cfg.Addr = net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
+	}
+
+	cfg.Timeout = config.ConnectTimeout
+	cfg.ReadTimeout = config.ReadTimeout
+	cfg.WriteTimeout = config.WriteTimeout
+
+	// charset is not a system variable, so it must not go in Params
+	if config.Charset != "" {
+		if err := cfg.Apply(mysql.Charset(config.Charset, config.Collation)); err != nil {
+			return nil, err
+		}
+	} else {
+		cfg.Collation = config.Collation
+	}
+
+	if config.Location != "" {
+		loc, err := time.LoadLocation(config.Location)
+		if err != nil {
+			return nil, fmt.Errorf("invalid location %q: %v", config.Location, err)
+		}
+		cfg.Loc = loc
+	}
+
+	// TIMESTAMP values arrive in the session time zone and are read as if
+	// they were in Loc, so with -loc=UTC the session uses UTC by default
+	timeZone := config.SessionTimeZone
+	if timeZone == "" && cfg.Loc == time.UTC {
+		timeZone = "+00:00"
+	}
+	if timeZone != "" {
+		// The driver sends Params in a SET statement as they are
+		if !sessionTimeZonePattern.MatchString(timeZone) {
+			return nil, fmt.Errorf("invalid -session-time-zone %q (want SYSTEM, an offset such as +01:00 or a zone name such as Europe/Berlin)", timeZone)
+		}
+		if cfg.Params == nil {
+			cfg.Params = make(map[string]string)
+		}
+		cfg.Params["time_zone"] = "'" + timeZone + "'"
+	}
+
+	// The driver scans zero dates as time.Time{}, which a real 0001-01-01
+	// 00:00:00 read in time.UTC is equal to; a separate zone with the same
+	// offset keeps the two apart (see isZeroDate)
+	if cfg.Loc == time.UTC {
+		cfg.Loc = driverUTC
+	}
+
+	tlsConfig, err := buildTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		cfg.TLS = tlsConfig
+		cfg.AllowFallbackToPlaintext = config.TLSMode == "preferred"
+	} else {
+		cfg.TLSConfig = config.TLSMode
+	}
+
+	return cfg, nil
+}
+
+// buildTLSConfig returns a custom TLS configuration when a CA, client
+// certificate or server name is given, or nil when the -tls mode alone is
+// sufficient. Giving any of these enables TLS even with -tls=false.
+func buildTLSConfig(config *Config) (*tls.Config, error) {
+	if config.TLSCA == "" && config.TLSCert == "" && config.TLSKey == "" && config.TLSServerName == "" {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         config.TLSServerName,
+		InsecureSkipVerify: config.TLSMode == "skip-verify",
+	}
+
+	if config.TLSServerName == "" && config.Socket == "" {
+		tlsConfig.ServerName = config.Host
+	}
+
+	if config.TLSCA != "" {
+		pem, err := os.ReadFile(config.TLSCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read TLS CA: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", config.TLSCA)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.TLSCert != "" || config.TLSKey != "" {
+		if config.TLSCert == "" || config.TLSKey == "" {
+			return nil, fmt.Errorf("both -tls-cert and -tls-key are required for client certificates")
+		}
+		cert, err := tls.LoadX509KeyPair(config.TLSCert, config.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMySQLConfigDSN(t *testing.T) {
+	config := &Config{
+		Host:            "db1",
+		Port:            3306,
+		User:            "archiver",
+		Database:        "app",
+		TLSMode:         "false",
+		Charset:         "utf8mb4",
+		Collation:       "utf8mb4_unicode_ci",
+		Location:        "Europe/Berlin",
+		SessionTimeZone: "+01:00",
+	}
+	cfg, err := mysqlConfig(config)
+	if err != nil {
+		t.Fatalf("mysqlConfig: %v", err)
+	}
+	if cfg.Loc.String() != "Europe/Berlin" {
+		t.Errorf("Loc = %s, want Europe/Berlin", cfg.Loc)
+	}
+
+	dsn := cfg.FormatDSN()
+	for _, want := range []string{"archiver@tcp(db1:3306)/app?", "charset=utf8mb4", "collation=utf8mb4_unicode_ci", "loc=Europe%2FBerlin", "time_zone=%27%2B01%3A00%27"} {
+		if !strings.Contains(dsn, want) {
+			t.Errorf("DSN %s lacks %s", dsn, want)
+		}
+	}
+	if len(cfg.Params) != 1 {
+		t.Errorf("Params = %v, want only time_zone", cfg.Params)
+	}
+
+	config.Charset = ""
+	cfg, err = mysqlConfig(config)
+	if err != nil {
+		t.Fatalf("mysqlConfig: %v", err)
+	}
+	if dsn := cfg.FormatDSN(); strings.Contains(dsn, "charset=") || !strings.Contains(dsn, "collation=utf8mb4_unicode_ci") {
+		t.Errorf("DSN without -charset is %s", dsn)
+	}
+
+	// With -loc=UTC the session defaults to UTC; other zones are left to
+	// -session-time-zone, which checkSessionTimeZone compares with -loc
+	config.SessionTimeZone = ""
+	for location, want := range map[string]string{"UTC": "'+00:00'", "Africa/Lagos": ""} {
+		config.Location = location
+		cfg, err := mysqlConfig(config)
+		if err != nil {
+			t.Fatalf("mysqlConfig: %v", err)
+		}
+		if got := cfg.Params["time_zone"]; got != want {
+			t.Errorf("time_zone with -loc=%s is %q, want %q", location, got, want)
+		}
+	}
+
+	config.Location = "Nowhere/Invalid"
+	if _, err := mysqlConfig(config); err == nil {
+		t.Error("mysqlConfig accepted an invalid location")
+	}
+}
+
+func TestCompareTimeZoneProbe(t *testing.T) {
+	config := &Config{Host: "db1", Port: 3306, TLSMode: "false", Location: "Europe/Berlin"}
+	cfg, err := mysqlConfig(config)
+	if err != nil {
+		t.Fatalf("mysqlConfig: %v", err)
+	}
+	winter, summer := timeZoneProbes(2024)[0], timeZoneProbes(2024)[1]
+
+	tests := []struct {
+		zone           string
+		winter, summer string
+		match          bool
+	}{
+		{"Europe/Berlin", "2024-01-15 13:00:00", "2024-07-15 14:00:00", true},
+		{"SYSTEM", "2024-01-15 13:00:00", "2024-07-15 14:00:00", true},
+		// A fixed offset only matches in winter
+		{"+01:00", "2024-01-15 13:00:00", "2024-07-15 13:00:00", false},
+		{"+00:00", "2024-01-15 12:00:00", "2024-07-15 12:00:00", false},
+	}
+	for _, tt := range tests {
+		err := compareTimeZoneProbe(tt.zone, cfg.Loc, winter, tt.winter)
+		if err == nil {
+			err = compareTimeZoneProbe(tt.zone, cfg.Loc, summer, tt.summer)
+		}
+		if (err == nil) != tt.match {
+			t.Errorf("session time_zone %s with -loc=Europe/Berlin: %v", tt.zone, err)
+		}
+	}
+}
+
+func TestMySQLConfigSessionTimeZone(t *testing.T) {
+	for _, zone := range []string{"+01:00", "-9:30", "SYSTEM", "UTC", "Europe/Berlin", "America/Argentina/Buenos_Aires", "Etc/GMT+3"} {
+		config := &Config{Host: "db1", Port: 3306, TLSMode: "false", SessionTimeZone: zone}
+		cfg, err := mysqlConfig(config)
+		if err != nil {
+			t.Errorf("-session-time-zone=%s: %v", zone, err)
+			continue
+		}
+		if got := cfg.Params["time_zone"]; got != "'"+zone+"'" {
+			t.Errorf("time_zone = %s, want '%s'", got, zone)
+		}
+	}
+	for _, zone := range []string{"+00:00'; DROP TABLE sms_log; --", "Europe/Berlin'", "a b", "/etc", "+1"} {
+		config := &Config{Host: "db1", Port: 3306, TLSMode: "false", SessionTimeZone: zone}
+		if _, err := mysqlConfig(config); err == nil {
+			t.Errorf("mysqlConfig accepted -session-time-zone=%q", zone)
+		}
+	}
+}
+
+func TestMySQLConfigLocationKeepsZeroDatesApart(t *testing.T) {
+	for _, location := range []string{"", "UTC", "Africa/Lagos"} {
+		cfg, err := mysqlConfig(&Config{Host: "db1", Port: 3306, TLSMode: "false", Location: location})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, offset := time.Date(2024, 1, 1, 0, 0, 0, 0, cfg.Loc).Zone(); (offset == 0) != (location != "Africa/Lagos") {
+			t.Errorf("-loc=%q read in %s", location, cfg.Loc)
+		}
+		// The driver builds real dates with time.Date in Loc
+		if isZeroDate(time.Date(1, 1, 1, 0, 0, 0, 0, cfg.Loc)) {
+			t.Errorf("-loc=%q: 0001-01-01 00:00:00 is taken for a zero date", location)
+		}
+	}
+	if !isZeroDate(time.Time{}) {
+		t.Error("time.Time{} is not a zero date")
+	}
+}
+
+func TestMySQLConfigSocket(t *testing.T) {
+	config := &Config{Host: "db1", Port: 3306, Socket: "/var/run/mysqld/mysqld.sock", TLSMode: "false", TLSServerName: "db.internal"}
+	cfg, err := mysqlConfig(config)
+	if err != nil {
+		t.Fatalf("mysqlConfig: %v", err)
+	}
+	if cfg.Net != "unix" || cfg.Addr != config.Socket {
+		t.Errorf("Net, Addr = %s, %s, want unix, %s", cfg.Net, cfg.Addr, config.Socket)
+	}
+	if cfg.TLS == nil || cfg.TLS.ServerName != "db.internal" {
+		t.Errorf("-tls-server-name over a socket gives TLS config %+v", cfg.TLS)
+	}
+}
+
+// writeTestCertificate writes a self-signed certificate and its key as PEM
+// files and returns their paths.
+func writeTestCertificate(t *testing.T) (certPath, keyPath string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "db1"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certPath, keyPath = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certPath, keyPath
+}
+
+func TestBuildTLSConfig(t *testing.T) {
+	certPath, keyPath := writeTestCertificate(t)
+	notPEM := filepath.Join(t.TempDir(), "ca.txt")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if tlsConfig, err := buildTLSConfig(&Config{Host: "db1", TLSMode: "true"}); err != nil || tlsConfig != nil {
+		t.Errorf("plain -tls=true gives %+v, %v, want the driver's own config", tlsConfig, err)
+	}
+
+	tlsConfig, err := buildTLSConfig(&Config{Host: "db1", TLSMode: "skip-verify", TLSCA: certPath, TLSCert: certPath, TLSKey: keyPath})
+	if err != nil {
+		t.Fatalf("buildTLSConfig: %v", err)
+	}
+	if tlsConfig.ServerName != "db1" || !tlsConfig.InsecureSkipVerify || tlsConfig.RootCAs == nil || len(tlsConfig.Certificates) != 1 {
+		t.Errorf("TLS config is %+v", tlsConfig)
+	}
+
+	for name, config := range map[string]*Config{
+		"missing CA":       {Host: "db1", TLSCA: filepath.Join(t.TempDir(), "missing.pem")},
+		"CA without PEM":   {Host: "db1", TLSCA: notPEM},
+		"cert without key": {Host: "db1", TLSCert: certPath},
+		"key as cert":      {Host: "db1", TLSCert: keyPath, TLSKey: keyPath},
+	} {
+		if _, err := buildTLSConfig(config); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+
+	// preferred falls back to plain text when the server has no TLS
+	cfg, err := mysqlConfig(&Config{Host: "db1", Port: 3306, TLSMode: "preferred", TLSCA: certPath})
+	if err != nil {
+		t.Fatalf("mysqlConfig: %v", err)
+	}
+	if cfg.TLS == nil || !cfg.AllowFallbackToPlaintext {
+		t.Errorf("-tls=preferred with -tls-ca gives TLS %v, fallback %v", cfg.TLS != nil, cfg.AllowFallbackToPlaintext)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// encryptedSuffix is appended to the names of encrypted exports.
+const encryptedSuffix = ".age"
+
+// exportRecipients returns the age recipients exports are encrypted to,
+// or nil when they are written in plain text.
+func exportRecipients(config *Config) ([]age.Recipient, error) {
+	var recipients []age.Recipient
+	for _, key := range splitList(config.EncryptRecipients) {
+		recipient, err := age.ParseX25519Recipient(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -encrypt-recipients key %q: %v", key, err)
+		}
+		recipients = append(recipients, recipient)
+	}
+
+	if config.EncryptRecipientsFile != "" {
+		file, err := os.Open(config.EncryptRecipientsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read recipients: %v", err)
+		}
+		defer file.Close()
+		parsed, err := age.ParseRecipients(file)
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipients file %s: %v", config.EncryptRecipientsFile, err)
+		}
+		recipients = append(recipients, parsed...)
+	}
+
+	return recipients, nil
+}
+
+// readIdentities reads the age private keys in path, one per line.
+func readIdentities(path string) ([]age.Identity, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identities: %v", err)
+	}
+	defer file.Close()
+
+	identities, err := age.ParseIdentities(file)
+	if err != nil {
+		return nil, fmt.Errorf("invalid identity file %s: %v", path, err)
+	}
+	return identities, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// encryptWriter encrypts what is written to it to recipients before it
+// reaches w; Close writes the last chunk. Without recipients it writes to w
+// as is.
+func encryptWriter(w io.Writer, recipients []age.Recipient) (io.WriteCloser, error) {
+	if len(recipients) == 0 {
+		return nopWriteCloser{w}, nil
+	}
+	return age.Encrypt(w, recipients...)
+}
+
+// openExport opens an export for reading, decrypting it with identities
+// when its name ends in .age.
+func openExport(path string, identities []age.Identity) (io.Reader, io.Closer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !strings.HasSuffix(path, encryptedSuffix) {
+		return file, file, nil
+	}
+
+	if len(identities) == 0 {
+		file.Close()
+		return nil, nil, errors.New("file is encrypted, pass -identity-file to read it")
+	}
+	r, err := age.Decrypt(file, identities...)
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("failed to decrypt: %v", err)
+	}
+	return r, file, nil
+}
+
+// runDecrypt implements the decrypt command, which writes the plain text of
+// an encrypted export to standard output or -o, and returns the exit code.
+func runDecrypt(args []string) int {
+	fs := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	identityFile := fs.String("identity-file", "", "File with the age private key(s) to decrypt with")
+	output := fs.String("o", "", "Write the plain text to this file instead of standard output")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of %s decrypt [flags] FILE.age:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 || *identityFile == "" {
+		fmt.Println("Error: an encrypted file and -identity-file are required")
+		fs.Usage()
+		return 1
+	}
+
+	if err := decryptFile(fs.Arg(0), *identityFile, *output); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func decryptFile(path, identityFile, output string) error {
+	identities, err := readIdentities(identityFile)
+	if err != nil {
+		return err
+	}
+	r, closer, err := openExport(path, identities)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	defer closer.Close()
+
+	file := os.Stdout
+	if output != "" {
+		// Only the owner may read the plain text
+		file, err = os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+	}
+
+	out := bufio.NewWriter(file)
+	if _, err := io.Copy(out, r); err != nil {
+		return fmt.Errorf("failed to decrypt %s: %v", path, err)
+	}
+	if err := out.Flush(); err != nil {
+		return err
+	}
+	if output != "" {
+		return file.Close()
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+)
+
+// writeIdentity writes a new age key to an identity file and returns its
+// path and public key.
+func writeIdentity(t *testing.T) (string, string) {
+	t.Helper()
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "key.txt")
+	if err := os.WriteFile(path, []byte(identity.String()+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path, identity.Recipient().String()
+}
+
+func TestArchiveTableEncryptedExports(t *testing.T) {
+	db, config := openTestDB(t)
+	config.ExportSQL = true
+	config.ExportCSV = true
+	config.ExportWorkers = 3
+	identityFile, recipient := writeIdentity(t)
+	config.EncryptRecipients = recipient
+	seedSMSLog(t, db, 100, 50, 40, 1)
+
+	if _, err := archiveTable(context.Background(), db, &SQLiteDialect{}, config, testLogger()); err != nil {
+		t.Fatalf("archiveTable: %v", err)
+	}
+	archive := archiveName("sms_log")
+
+	// Only encrypted exports and the manifest are left behind
+	entries, err := os.ReadDir(config.ExportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if name := entry.Name(); !strings.HasSuffix(name, encryptedSuffix) && !strings.HasSuffix(name, ".manifest.json") {
+			t.Errorf("unencrypted file %s in the export path", name)
+		}
+	}
+	sqlPath := exportFile(t, config, archive, "sql.age")
+	data, err := os.ReadFile(sqlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("INSERT INTO")) {
+		t.Error("encrypted SQL export contains plain text")
+	}
+
+	plain := filepath.Join(t.TempDir(), "dump.sql")
+	if err := decryptFile(sqlPath, identityFile, plain); err != nil {
+		t.Fatalf("decryptFile: %v", err)
+	}
+	if data, err := os.ReadFile(plain); err != nil || !strings.Contains(string(data), "INSERT INTO") {
+		t.Errorf("decrypted SQL export has no INSERT statements (%v)", err)
+	}
+
+	manifest, err := readManifest(exportFile(t, config, archive, "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := archiveCheck{Table: archive, Manifest: manifest, ManifestDir: config.ExportPath}
+	problems := runVerifyArchive(t, config, check)
+	if !containsProblem(problems, "-identity-file") {
+		t.Errorf("verify without an identity file reported %v", problems)
+	}
+	config.IdentityFile = identityFile
+	if problems := runVerifyArchive(t, config, check); len(problems) != 0 {
+		t.Errorf("encrypted exports reported problems: %v", problems)
+	}
+}
+
+func TestExportRecipients(t *testing.T) {
+	_, recipient := writeIdentity(t)
+	_, other := writeIdentity(t)
+	file := filepath.Join(t.TempDir(), "recipients.txt")
+	if err := os.WriteFile(file, []byte("# backup key\n"+other+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	recipients, err := exportRecipients(&Config{EncryptRecipients: recipient, EncryptRecipientsFile: file})
+	if err != nil || len(recipients) != 2 {
+		t.Errorf("exportRecipients = %d recipients, %v, want 2", len(recipients), err)
+	}
+	if _, err := exportRecipients(&Config{EncryptRecipients: "age1notakey"}); err == nil {
+		t.Error("exportRecipients accepted an invalid key")
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Masking rules for -export-mask
+const (
+	MaskHash     = "hash"
+	MaskRedact   = "redact"
+	MaskTruncate = "truncate"
+	MaskLast     = "last"
+)
+
+// redactedValue replaces values masked with the redact rule.
+const redactedValue = "REDACTED"
+
+// maskRule is one column=rule entry of -export-mask. Table is empty when
+// the rule applies to the column in every exported table.
+type maskRule struct {
+	Table  string
+	Column string
+	Rule   string
+	N      int
+}
+
+func (r maskRule) String() string {
+	if r.Rule == MaskTruncate || r.Rule == MaskLast {
+		return fmt.Sprintf("%s=%s:%d", r.Column, r.Rule, r.N)
+	}
+	return r.Column + "=" + r.Rule
+}
+
+// columnRef is a column of -export-include or -export-exclude, optionally
+// qualified with the table it applies to.
+type columnRef struct {
+	Table  string
+	Column string
+}
+
+// exportColumnRules are the parsed column selection and masking flags.
+type exportColumnRules struct {
+	Include []columnRef
+	Exclude []columnRef
+	Masks   []maskRule
+	Salt    string
+}
+
+// parseExportColumnRules parses -export-include, -export-exclude and
+// -export-mask, and reads the hashing salt from the environment.
+func parseExportColumnRules(config *Config) (*exportColumnRules, error) {
+	rules := &exportColumnRules{
+		Include: parseColumnRefs(config.ExportInclude),
+		Exclude: parseColumnRefs(config.ExportExclude),
+	}
+
+	for _, item := range splitList(config.ExportMask) {
+		column, rule, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid -export-mask entry %q (want column=rule)", item)
+		}
+		ref := parseColumnRef(strings.TrimSpace(column))
+		mask := maskRule{Table: ref.Table, Column: ref.Column}
+
+		name, arg, hasArg := strings.Cut(strings.TrimSpace(rule), ":")
+		mask.Rule = name
+		switch name {
+		case MaskHash, MaskRedact:
+			if hasArg {
+				return nil, fmt.Errorf("invalid -export-mask entry %q: %s takes no argument", item, name)
+			}
+		case MaskTruncate, MaskLast:
+			n, err := strconv.Atoi(arg)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid -export-mask entry %q: %s needs a character count, e.g. %s:4", item, name, name)
+			}
+			mask.N = n
+		default:
+			return nil, fmt.Errorf("invalid -export-mask entry %q: unknown rule %q (want hash, redact, truncate:N or last:N)", item, name)
+		}
+		rules.Masks = append(rules.Masks, mask)
+	}
+
+	if config.ExportMaskSaltEnv != "" {
+		rules.Salt = os.Getenv(config.ExportMaskSaltEnv)
+	}
+	for _, mask := range rules.Masks {
+		// Unsalted hashes of phone numbers are reversed by trying them all
+		if mask.Rule == MaskHash && rules.Salt == "" {
+			return nil, fmt.Errorf("the hash mask needs a salt in the environment variable named by -export-mask-salt-env (%s)", config.ExportMaskSaltEnv)
+		}
+	}
+	return rules, nil
+}
+
+func parseColumnRefs(value string) []columnRef {
+	var refs []columnRef
+	for _, item := range splitList(value) {
+		refs = append(refs, parseColumnRef(item))
+	}
+	return refs
+}
+
+func parseColumnRef(item string) columnRef {
+	if table, column, ok := strings.Cut(item, "."); ok {
+		return columnRef{Table: table, Column: column}
+	}
+	return columnRef{Column: item}
+}
+
+// appliesTo reports whether a rule qualified with ruleTable applies to
+// table: unqualified rules apply to every table, qualified ones to the
+// table itself and the archive tables made from it.
+func appliesTo(ruleTable, table string) bool {
+	return ruleTable == "" || ruleTable == table || strings.HasPrefix(table, ruleTable+"_archive_")
+}
+
+// exportColumns are the columns of one table that are exported, in table
+// order, with the masks applied to them.
+type exportColumns struct {
+	Names []string
+	Masks []maskRule
+
+	// positions of the masked columns in Names
+	masks map[int]maskRule
+	salt  []byte
+}
+
+// planExportColumns applies the column rules to the columns of table.
+// Qualified names that are not columns of the table are an error, since
+// they are most likely typos; unqualified ones may belong to another table.
+func (r *exportColumnRules) planExportColumns(table string, columns []Column) (*exportColumns, error) {
+	known := make(map[string]bool, len(columns))
+	types := make(map[string]string, len(columns))
+	for _, column := range columns {
+		known[column.Name] = true
+		types[column.Name] = column.Type
+	}
+	check := func(flagName string, ref columnRef) error {
+		if ref.Table != "" && appliesTo(ref.Table, table) && !known[ref.Column] {
+			return fmt.Errorf("%s: table %s has no column %s", flagName, table, ref.Column)
+		}
+		return nil
+	}
+
+	var include []string
+	for _, ref := range r.Include {
+		if err := check("-export-include", ref); err != nil {
+			return nil, err
+		}
+		if appliesTo(ref.Table, table) && known[ref.Column] {
+			include = append(include, ref.Column)
+		}
+	}
+	excluded := make(map[string]bool)
+	for _, ref := range r.Exclude {
+		if err := check("-export-exclude", ref); err != nil {
+			return nil, err
+		}
+		if appliesTo(ref.Table, table) {
+			excluded[ref.Column] = true
+		}
+	}
+
+	plan := &exportColumns{masks: make(map[int]maskRule), salt: []byte(r.Salt)}
+	for _, column := range columns {
+		if (len(r.Include) > 0 && !slices.Contains(include, column.Name)) || excluded[column.Name] {
+			continue
+		}
+		plan.Names = append(plan.Names, column.Name)
+	}
+	if len(plan.Names) == 0 {
+		return nil, fmt.Errorf("no columns of %s left to export", table)
+	}
+
+	for _, mask := range r.Masks {
+		if err := check("-export-mask", columnRef{Table: mask.Table, Column: mask.Column}); err != nil {
+			return nil, err
+		}
+		if !appliesTo(mask.Table, table) || !slices.Contains(plan.Names, mask.Column) {
+			continue
+		}
+		// A masked value is text, which doesn't load back into a number,
+		// date or binary column
+		if !isMaskableType(types[mask.Column]) {
+			return nil, fmt.Errorf("-export-mask: column %s of %s has type %q, only string columns can be masked (exclude it instead)", mask.Column, table, types[mask.Column])
+		}
+		for i, name := range plan.Names {
+			if name == mask.Column {
+				plan.masks[i] = mask
+			}
+		}
+	}
+	for i := range plan.Names {
+		if mask, ok := plan.masks[i]; ok {
+			plan.Masks = append(plan.Masks, mask)
+		}
+	}
+	return plan, nil
+}
+
+// isMaskableType reports whether a column type from Dialect.Columns, such
+// as varchar(20), text or character varying, holds strings.
+func isMaskableType(colType string) bool {
+	name := strings.ToLower(colType)
+	if strings.Contains(name, "char") || strings.Contains(name, "text") || strings.Contains(name, "clob") {
+		return true
+	}
+	name, _, _ = strings.Cut(name, "(")
+	return name == "enum" || name == "set"
+}
+
+// exportColumnsFor plans the exported columns of table from the flags.
+func exportColumnsFor(table string, columns []Column, config *Config) (*exportColumns, error) {
+	rules, err := parseExportColumnRules(config)
+	if err != nil {
+		return nil, err
+	}
+	return rules.planExportColumns(table, columns)
+}
+
+// All reports whether every column of the table is exported unmasked.
+func (c *exportColumns) All(columns []Column) bool {
+	return len(c.Names) == len(columns) && len(c.Masks) == 0
+}
+
+func (c *exportColumns) quotedNames(dialect Dialect) []string {
+	quoted := make([]string, len(c.Names))
+	for i, name := range c.Names {
+		quoted[i] = dialect.QuoteIdentifier(name)
+	}
+	return quoted
+}
+
+// maskEncoder masks the values of each row before encode formats them.
+func (c *exportColumns) maskEncoder(encode rowEncoder) rowEncoder {
+	if len(c.masks) == 0 {
+		return encode
+	}
+	return func(values []any, columnTypes []*sql.ColumnType) ([]byte, error) {
+		for i, mask := range c.masks {
+			if values[i] != nil {
+				values[i] = applyMask(mask, maskText(values[i]), c.salt)
+			}
+		}
+		return encode(values, columnTypes)
+	}
+}
+
+func maskText(val any) string {
+	switch v := val.(type) {
+	case []byte:
+		return string(v)
+	case string:
+		return v
+	case time.Time:
+		return v.Format("2006-01-02 15:04:05.999999")
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// applyMask returns the masked text of a non-NULL value.
+func applyMask(mask maskRule, s string, salt []byte) string {
+	switch mask.Rule {
+	case MaskHash:
+		mac := hmac.New(sha256.New, salt)
+		mac.Write([]byte(s))
+		return hex.EncodeToString(mac.Sum(nil))
+	case MaskRedact:
+		return redactedValue
+	case MaskTruncate:
+		runes := []rune(s)
+		if len(runes) > mask.N {
+			return string(runes[:mask.N])
+		}
+		return s
+	case MaskLast:
+		// Replace every digit but the last N, keeping separators
+		runes := []rune(s)
+		keep := mask.N
+		for i := len(runes) - 1; i >= 0; i-- {
+			if !unicode.IsDigit(runes[i]) {
+				continue
+			}
+			if keep > 0 {
+				keep--
+				continue
+			}
+			runes[i] = '*'
+		}
+		return string(runes)
+	}
+	return s
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestApplyMask(t *testing.T) {
+	salt := []byte("pepper")
+	for _, tc := range []struct {
+		mask maskRule
+		in   string
+		want string
+	}{
+		{maskRule{Rule: MaskRedact}, "secret", redactedValue},
+		{maskRule{Rule: MaskTruncate, N: 3}, "héllo", "hél"},
+		{maskRule{Rule: MaskTruncate, N: 10}, "short", "short"},
+		{maskRule{Rule: MaskLast, N: 4}, "2348031234567", "*********4567"},
+		{maskRule{Rule: MaskLast, N: 4}, "+234 803 123-4567", "+*** *** ***-4567"},
+		{maskRule{Rule: MaskLast, N: 0}, "12a3", "**a*"},
+	} {
+		if got := applyMask(tc.mask, tc.in, salt); got != tc.want {
+			t.Errorf("%s on %q = %q, want %q", tc.mask, tc.in, got, tc.want)
+		}
+	}
+
+	hash := maskRule{Rule: MaskHash}
+	first := applyMask(hash, "2348031234567", salt)
+	if len(first) != 64 || first != applyMask(hash, "2348031234567", salt) {
+		t.Errorf("hash is %q, want a stable hex SHA-256", first)
+	}
+	if first == applyMask(hash, "2348031234567", []byte("other")) || first == applyMask(hash, "2348031234568", salt) {
+		t.Error("hash does not depend on the salt and value")
+	}
+}
+
+func TestParseExportColumnRules(t *testing.T) {
+	t.Setenv("TEST_MASK_SALT", "")
+	for _, bad := range []string{"msisdn", "msisdn=scramble", "msisdn=last", "msisdn=truncate:-1", "msisdn=redact:2", "msisdn=hash"} {
+		config := &Config{ExportMask: bad, ExportMaskSaltEnv: "TEST_MASK_SALT"}
+		if _, err := parseExportColumnRules(config); err == nil {
+			t.Errorf("no error for -export-mask=%s", bad)
+		}
+	}
+
+	t.Setenv("TEST_MASK_SALT", "pepper")
+	config := &Config{ExportMask: "sms_log.msisdn=last:4, message=hash", ExportMaskSaltEnv: "TEST_MASK_SALT"}
+	rules, err := parseExportColumnRules(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules.Masks) != 2 || rules.Masks[0] != (maskRule{Table: "sms_log", Column: "msisdn", Rule: MaskLast, N: 4}) || rules.Salt != "pepper" {
+		t.Errorf("parsed %+v", rules)
+	}
+}
+
+func TestPlanExportColumns(t *testing.T) {
+	columns := []Column{{"id", "INTEGER"}, {"msisdn", "varchar(20)"}, {"message", "TEXT"}, {"created_at", "DATETIME"}}
+	for _, tc := range []struct {
+		include, exclude, mask string
+		table                  string
+		want                   string
+		wantErr                bool
+	}{
+		{table: "sms_log", want: "id,msisdn,message,created_at"},
+		{include: "id,created_at,other", table: "sms_log", want: "id,created_at"},
+		{exclude: "message", table: "sms_log", want: "id,msisdn,created_at"},
+		{exclude: "sms_log.message", table: "sms_log_archive_20251014", want: "id,msisdn,created_at"},
+		{exclude: "users.message", table: "sms_log", want: "id,msisdn,message,created_at"},
+		{include: "sms_log.id,users.name", exclude: "id", table: "sms_log", wantErr: true},
+		{exclude: "sms_log.mesage", table: "sms_log", wantErr: true},
+		{mask: "sms_log.body=redact", table: "sms_log", wantErr: true},
+		{mask: "msisdn=last:4,message=redact", table: "sms_log", want: "id,msisdn,message,created_at"},
+		{mask: "created_at=redact", table: "sms_log", wantErr: true},
+		{mask: "sms_log.id=redact", table: "sms_log", wantErr: true},
+		{mask: "created_at=truncate:4", exclude: "created_at", table: "sms_log", want: "id,msisdn,message"},
+	} {
+		config := &Config{ExportInclude: tc.include, ExportExclude: tc.exclude, ExportMask: tc.mask}
+		plan, err := exportColumnsFor(tc.table, columns, config)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("no error for %+v", tc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%+v: %v", tc, err)
+			continue
+		}
+		if got := strings.Join(plan.Names, ","); got != tc.want {
+			t.Errorf("%+v exports %s, want %s", tc, got, tc.want)
+		}
+	}
+}
+
+func TestIsMaskableType(t *testing.T) {
+	for colType, want := range map[string]bool{
+		"varchar(20)": true, "char(2)": true, "mediumtext": true, "enum('a','b')": true, "set('x')": true,
+		"character varying": true, "text": true, "TEXT": true, "CLOB": true,
+		"int": false, "bigint unsigned": false, "decimal(10,2)": false, "datetime(6)": false,
+		"timestamp with time zone": false, "varbinary(16)": false, "blob": false, "bytea": false, "json": false, "": false,
+	} {
+		if got := isMaskableType(colType); got != want {
+			t.Errorf("isMaskableType(%q) = %v, want %v", colType, got, want)
+		}
+	}
+}
+
+func TestArchiveTableMaskedExports(t *testing.T) {
+	t.Setenv("EXPORT_MASK_SALT", "pepper")
+	db, config := openTestDB(t)
+	config.ExportSQL = true
+	config.ExportCSV = true
+	config.ExportExclude = "payload"
+	config.ExportMask = "msisdn=last:4,sms_log.message=hash"
+	config.ExportMaskSaltEnv = "EXPORT_MASK_SALT"
+	seedSMSLog(t, db, 100, 50, 40, 1)
+
+	if _, err := archiveTable(context.Background(), db, &SQLiteDialect{}, config, testLogger()); err != nil {
+		t.Fatalf("archiveTable: %v", err)
+	}
+	archive := archiveName("sms_log")
+
+	file, err := os.Open(exportFile(t, config, archive, "csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(file).ReadAll()
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(records[0], ","); got != "id,msisdn,message,created_at" {
+		t.Errorf("CSV header is %s", got)
+	}
+	if msisdn, message := records[1][1], records[1][2]; msisdn != "**********0000" || message != applyMask(maskRule{Rule: MaskHash}, "message 0, it's 100 days old", []byte("pepper")) {
+		t.Errorf("first CSV row is %q, want masked msisdn and message", records[1])
+	}
+
+	dump, err := os.ReadFile(exportFile(t, config, archive, "sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(dump), "message 0") || strings.Contains(string(dump), "23408000000000") {
+		t.Error("SQL export contains unmasked values")
+	}
+	if !strings.Contains(string(dump), `("id","msisdn","message","created_at") VALUES`) {
+		t.Error("SQL export does not insert into the exported columns only")
+	}
+
+	path := exportFile(t, config, archive, "manifest.json")
+	manifest, err := readManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(manifest.ExportColumns, ",") != "id,msisdn,message,created_at" || strings.Join(manifest.Masks, ",") != "msisdn=last:4,message=hash" {
+		t.Errorf("manifest lists export columns %v and masks %v", manifest.ExportColumns, manifest.Masks)
+	}
+	check := archiveCheck{Table: archive, Manifest: manifest, ManifestDir: config.ExportPath}
+	if problems := runVerifyArchive(t, config, check); len(problems) != 0 {
+		t.Errorf("masked exports reported problems: %v", problems)
+	}
+}
+
+func TestVerifyArchiveExcludedColumns(t *testing.T) {
+	db, config := openTestDB(t)
+	config.ExportSQL = true
+	config.ExportExclude = "payload"
+	seedSMSLog(t, db, 100, 50, 1)
+
+	if _, err := archiveTable(context.Background(), db, &SQLiteDialect{}, config, testLogger()); err != nil {
+		t.Fatalf("archiveTable: %v", err)
+	}
+	archive := archiveName("sms_log")
+	manifest, err := readManifest(exportFile(t, config, archive, "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := archiveCheck{Table: archive, Manifest: manifest, ManifestDir: config.ExportPath}
+	if problems := runVerifyArchive(t, config, check); len(problems) != 0 {
+		t.Fatalf("fresh archive reported problems: %v", problems)
+	}
+
+	// The export is compared on the exported columns only
+	mustExec(t, db, `UPDATE "`+archive+`" SET payload = X'01', message = 'changed' WHERE id = 1`)
+	problems := runVerifyArchive(t, config, check)
+	if !containsProblem(problems, "SQL export") || !containsProblem(problems, "Checksum differs from the manifest") {
+		t.Errorf("changed message not reported, got %v", problems)
+	}
+}
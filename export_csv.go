@@ -1,149 +1,260 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"encoding/csv"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 )
 
-func exportTableToCSV(db *sql.DB, tableName string, config *Config, logger *Logger) error {
+// exportTableToCSV writes tableName to new files in config.ExportPath and
+// describes them for the manifest. Without a part limit the export is a
+// single file.
+func exportTableToCSV(ctx context.Context, db *sql.DB, dialect Dialect, tableName string, config *Config, logger *Logger) ([]ManifestFile, error) {
 	// Create export directory if it doesn't exist
 	if err := os.MkdirAll(config.ExportPath, 0o755); err != nil {
-		return fmt.Errorf("failed to create export directory: %v", err)
+		return nil, fmt.Errorf("failed to create export directory: %v", err)
 	}
 
 	// Generate filename
 	timestamp := time.Now().Format("20060102_150405")
 	filename := fmt.Sprintf("%s/%s_%s.csv", config.ExportPath, tableName, timestamp)
 
-	// Create the CSV file
-	file, err := os.Create(filename)
-	if err != nil {
-		return fmt.Errorf("failed to create CSV file: %v", err)
-	}
-	defer file.Close()
-
 	logger.Info("Exporting to CSV file: %s", filename)
 
-	// Create CSV writer
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
-
 	// Get column names
-	columnQuery := fmt.Sprintf("SELECT COLUMN_NAME FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = '%s' ORDER BY ORDINAL_POSITION", tableName)
-	rows, err := db.Query(columnQuery)
+	tableColumns, err := dialect.Columns(ctx, db, tableName)
 	if err != nil {
-		return fmt.Errorf("failed to get column names: %v", err)
+		return nil, fmt.Errorf("failed to get column names: %v", err)
 	}
 
-	var columns []string
-	for rows.Next() {
-		var columnName string
-		if err := rows.Scan(&columnName); err != nil {
-			rows.Close()
-			return fmt.Errorf("failed to scan column name: %v", err)
-		}
-		columns = append(columns, columnName)
+	columns, err := exportColumnsFor(tableName, tableColumns, config)
+	if err != nil {
+		return nil, err
 	}
-	rows.Close()
 
-	// Write header row
-	if err := writer.Write(columns); err != nil {
-		return fmt.Errorf("failed to write CSV header: %v", err)
+	format, err := newCSVFormat(columns.Names, config.CSV)
+	if err != nil {
+		return nil, err
+	}
+	out, err := newPartWriter(filename, "csv", format, config, logger)
+	if err != nil {
+		return nil, err
+	}
+	totalRows, err := exportRows(ctx, db, dialect, tableName, columns, config, out, logger)
+	if err != nil {
+		out.Abort()
+		return nil, err
+	}
+	files, err := out.Close()
+	if err != nil {
+		return nil, err
 	}
 
-	// Export data in batches
-	batchSize := 5000
-	offset := 0
-	totalRows := 0
-
-	for {
-		dataQuery := fmt.Sprintf("SELECT * FROM `%s` LIMIT %d OFFSET %d", tableName, batchSize, offset)
-		dataRows, err := db.Query(dataQuery)
-		if err != nil {
-			return fmt.Errorf("failed to query data: %v", err)
-		}
-
-		// Prepare value containers
-		columnCount := len(columns)
-		values := make([]interface{}, columnCount)
-		valuePtrs := make([]interface{}, columnCount)
-		for i := range values {
-			valuePtrs[i] = &values[i]
-		}
-
-		rowCount := 0
+	logger.Info("Successfully exported %d rows to %d CSV files", totalRows, len(files))
+	return files, nil
+}
 
-		for dataRows.Next() {
-			if err := dataRows.Scan(valuePtrs...); err != nil {
-				dataRows.Close()
-				return fmt.Errorf("failed to scan row: %v", err)
-			}
+// CSV quoting modes
+const (
+	CSVQuoteMinimal = "minimal"
+	CSVQuoteAll     = "all"
+	CSVQuoteNone    = "none"
+)
 
-			// Convert values to strings for CSV
-			record := make([]string, columnCount)
-			for i, val := range values {
-				record[i] = formatCSVValue(val)
-			}
+// CSVOptions is the layout of CSV exports. It is recorded in the manifest,
+// so the verify command reads the files the way they were written.
+type CSVOptions struct {
+	Delimiter  string `json:"delimiter"`
+	Quote      string `json:"quote"`
+	Null       string `json:"null"`
+	Header     bool   `json:"header"`
+	TimeLayout string `json:"time_layout"`
+	TimeZone   string `json:"time_zone,omitempty"`
+	CRLF       bool   `json:"crlf,omitempty"`
+	BOM        bool   `json:"bom,omitempty"`
+}
 
-			if err := writer.Write(record); err != nil {
-				dataRows.Close()
-				return fmt.Errorf("failed to write CSV row: %v", err)
-			}
+// defaultCSVOptions is the layout of exports written before it could be
+// configured.
+func defaultCSVOptions() CSVOptions {
+	return CSVOptions{
+		Delimiter:  ",",
+		Quote:      CSVQuoteMinimal,
+		Header:     true,
+		TimeLayout: "2006-01-02 15:04:05",
+	}
+}
 
-			rowCount++
-			totalRows++
+func csvFlags(fs *flag.FlagSet, config *Config) {
+	defaults := defaultCSVOptions()
+	fs.StringVar(&config.CSV.Delimiter, "csv-delimiter", defaults.Delimiter, `CSV field delimiter: a single character, or \t for tab`)
+	fs.StringVar(&config.CSV.Quote, "csv-quote", defaults.Quote, "CSV quoting: minimal (only when needed), all (every non-NULL field) or none (backslash escapes, as LOAD DATA INFILE expects; needs -csv-null='\\N')")
+	fs.StringVar(&config.CSV.Null, "csv-null", defaults.Null, `Text written for NULL in CSV, e.g. \N (when empty, empty strings are quoted to tell them apart)`)
+	fs.BoolVar(&config.CSV.Header, "csv-header", defaults.Header, "Write a header row with the column names to each CSV file")
+	fs.StringVar(&config.CSV.TimeLayout, "csv-time-layout", defaults.TimeLayout, "Go time layout for dates in CSV, e.g. 2006-01-02T15:04:05.000000Z07:00")
+	fs.StringVar(&config.CSV.TimeZone, "csv-time-zone", defaults.TimeZone, "Time zone dates are converted to in CSV, e.g. UTC or Local (default: as read)")
+	fs.BoolVar(&config.CSV.CRLF, "csv-crlf", defaults.CRLF, "End CSV lines with CRLF instead of LF")
+	fs.BoolVar(&config.CSV.BOM, "csv-bom", defaults.BOM, "Start each CSV file with a UTF-8 byte order mark, for Excel")
+}
 
-			// Flush periodically to avoid memory issues
-			if totalRows%1000 == 0 {
-				writer.Flush()
-				if err := writer.Error(); err != nil {
-					dataRows.Close()
-					return fmt.Errorf("failed to flush CSV: %v", err)
-				}
-			}
+// validateCSVOptions checks the CSV flags, resolving the \t delimiter.
+func validateCSVOptions(options *CSVOptions) error {
+	if options.Delimiter == `\t` || options.Delimiter == "tab" {
+		options.Delimiter = "\t"
+	}
+	if len(options.Delimiter) != 1 || strings.ContainsAny(options.Delimiter, "\"\\\r\n") {
+		return fmt.Errorf("invalid -csv-delimiter %q (want a single character other than a quote, backslash or line break)", options.Delimiter)
+	}
+	switch options.Quote {
+	case CSVQuoteMinimal, CSVQuoteAll, CSVQuoteNone:
+	default:
+		return fmt.Errorf("invalid -csv-quote %q (want minimal, all or none)", options.Quote)
+	}
+	if strings.ContainsAny(options.Null, options.Delimiter+"\"\r\n") {
+		return fmt.Errorf("invalid -csv-null %q (must not contain the delimiter, quotes or line breaks)", options.Null)
+	}
+	// Without quotes only \N can't be mistaken for a value, since every
+	// backslash in a value is escaped
+	if options.Quote == CSVQuoteNone && options.Null != `\N` {
+		return fmt.Errorf(`-csv-quote=none needs -csv-null='\N' (a value equal to %q would be read back as NULL)`, options.Null)
+	}
+	if options.TimeLayout == "" {
+		return errors.New("-csv-time-layout must not be empty")
+	}
+	if options.TimeZone != "" {
+		if _, err := time.LoadLocation(options.TimeZone); err != nil {
+			return fmt.Errorf("invalid -csv-time-zone %q: %v", options.TimeZone, err)
 		}
+	}
+	return nil
+}
+
+func (o CSVOptions) lineEnd() string {
+	if o.CRLF {
+		return "\r\n"
+	}
+	return "\n"
+}
 
-		dataRows.Close()
+// csvFormat writes rows as CSV records, with a header row in every part.
+type csvFormat struct {
+	columns  []string
+	options  CSVOptions
+	location *time.Location
+}
 
-		// Log progress
-		if rowCount > 0 {
-			logger.Info("Exported %d rows to CSV...", totalRows)
+func newCSVFormat(columns []string, options CSVOptions) (*csvFormat, error) {
+	f := &csvFormat{columns: columns, options: options}
+	if options.TimeZone != "" {
+		location, err := time.LoadLocation(options.TimeZone)
+		if err != nil {
+			return nil, err
 		}
+		f.location = location
+	}
+	return f, nil
+}
 
-		// If we got fewer rows than batch size, we're done
-		if rowCount < batchSize {
-			break
+func (f *csvFormat) NewEncoder() rowEncoder {
+	var buf []byte
+	return func(values []any, columnTypes []*sql.ColumnType) ([]byte, error) {
+		buf = buf[:0]
+		for i, val := range values {
+			if i > 0 {
+				buf = append(buf, f.options.Delimiter...)
+			}
+			if val == nil {
+				buf = append(buf, f.options.Null...)
+				continue
+			}
+			buf = f.appendField(buf, f.formatValue(val))
 		}
+		return append(buf, f.options.lineEnd()...), nil
+	}
+}
 
-		offset += batchSize
+func (f *csvFormat) Begin(w *countingWriter, part int) error {
+	var buf []byte
+	if f.options.BOM {
+		buf = append(buf, "\uFEFF"...)
 	}
 
-	// Final flush
-	writer.Flush()
-	if err := writer.Error(); err != nil {
-		return fmt.Errorf("failed to flush CSV: %v", err)
+	// Write header row
+	if f.options.Header {
+		for i, column := range f.columns {
+			if i > 0 {
+				buf = append(buf, f.options.Delimiter...)
+			}
+			buf = f.appendField(buf, column)
+		}
+		buf = append(buf, f.options.lineEnd()...)
 	}
 
-	logger.Info("Successfully exported %d rows to %s", totalRows, filename)
+	_, err := w.Write(buf)
+	return err
+}
+
+func (f *csvFormat) Row(w *countingWriter, row []byte) error {
+	_, err := w.Write(row)
+	return err
+}
+
+func (f *csvFormat) End(w *countingWriter, rows int64) error {
 	return nil
 }
 
-func formatCSVValue(val interface{}) string {
-	if val == nil {
-		return ""
+// appendField appends a non-NULL field, quoted or escaped so it cannot be
+// mistaken for the delimiter, a line end or the NULL marker.
+func (f *csvFormat) appendField(buf []byte, field string) []byte {
+	delimiter := f.options.Delimiter[0]
+
+	if f.options.Quote == CSVQuoteNone {
+		for i := 0; i < len(field); i++ {
+			switch c := field[i]; c {
+			case '\\':
+				buf = append(buf, `\\`...)
+			case '\n':
+				buf = append(buf, `\n`...)
+			case '\r':
+				buf = append(buf, `\r`...)
+			case 0:
+				buf = append(buf, `\0`...)
+			case delimiter:
+				buf = append(buf, '\\', c)
+			default:
+				buf = append(buf, c)
+			}
+		}
+		return buf
+	}
+
+	quote := f.options.Quote == CSVQuoteAll || field == f.options.Null ||
+		strings.ContainsAny(field, f.options.Delimiter+"\"\r\n") || strings.HasPrefix(field, " ") || strings.HasPrefix(field, "\t")
+	if !quote {
+		return append(buf, field...)
 	}
+	buf = append(buf, '"')
+	buf = append(buf, strings.ReplaceAll(field, `"`, `""`)...)
+	return append(buf, '"')
+}
 
+func (f *csvFormat) formatValue(val interface{}) string {
 	switch v := val.(type) {
 	case []byte:
 		return string(v)
 	case time.Time:
-		if v.IsZero() {
+		if isZeroDate(v) {
 			return ""
 		}
-		return v.Format("2006-01-02 15:04:05")
+		if f.location != nil {
+			v = v.In(f.location)
+		}
+		return v.Format(f.options.TimeLayout)
 	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
 		return fmt.Sprintf("%d", v)
 	case float32, float64:
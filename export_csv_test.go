@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCSVOptions(t *testing.T) {
+	db, config := openTestDB(t)
+	mustExec(t, db, "CREATE TABLE notes (id INTEGER PRIMARY KEY, body TEXT, created_at DATETIME)")
+	mustExec(t, db, "INSERT INTO notes VALUES (1, NULL, '2024-03-05 14:07:09'), (2, '', NULL), (3, ?, '2024-12-31 23:30:00')",
+		"a;b,\"c\"\tback\\slash\nline")
+
+	for _, tc := range []struct {
+		name   string
+		modify func(*CSVOptions)
+		want   string
+	}{
+		{"default", func(*CSVOptions) {}, `id,body,created_at
+1,,2024-03-05 14:07:09
+2,"",
+3,"a;b,""c""	back\slash
+line",2024-12-31 23:30:00
+`},
+		{"load data", func(o *CSVOptions) {
+			o.Delimiter, o.Quote, o.Null, o.Header = "tab", CSVQuoteNone, `\N`, false
+		}, "1\t\\N\t2024-03-05 14:07:09\n2\t\t\\N\n3\ta;b,\"c\"\\\tback\\\\slash\\nline\t2024-12-31 23:30:00\n"},
+		{"excel", func(o *CSVOptions) {
+			o.Delimiter, o.Quote, o.Null, o.CRLF, o.BOM = ";", CSVQuoteAll, "NULL", true, true
+			o.TimeLayout, o.TimeZone = "02.01.2006 15:04", "Europe/Berlin"
+		}, "\uFEFF\"id\";\"body\";\"created_at\"\r\n\"1\";NULL;\"05.03.2024 15:07\"\r\n\"2\";\"\";NULL\r\n" +
+			"\"3\";\"a;b,\"\"c\"\"\tback\\slash\nline\";\"01.01.2025 00:30\"\r\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config.CSV = defaultCSVOptions()
+			tc.modify(&config.CSV)
+			if err := validateCSVOptions(&config.CSV); err != nil {
+				t.Fatal(err)
+			}
+			config.ExportPath = t.TempDir()
+
+			if _, err := exportTableToCSV(context.Background(), db, &SQLiteDialect{}, "notes", config, testLogger()); err != nil {
+				t.Fatal(err)
+			}
+			path := exportFile(t, config, "notes", "csv")
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tc.want {
+				t.Errorf("export is\n%q\nwant\n%q", data, tc.want)
+			}
+
+			rows, _, err := readCSVExport(path, nil, []string{"id", "body", "created_at"}, config.CSV)
+			if err != nil || rows != 3 {
+				t.Errorf("readCSVExport = %d, %v, want 3 rows", rows, err)
+			}
+		})
+	}
+}
+
+func TestCSVReader(t *testing.T) {
+	options := defaultCSVOptions()
+	reader := &csvReader{r: bufio.NewReader(strings.NewReader("a,\"b,\"\"c\"\"\nd\",\n\"x\",y\n")), options: options}
+	for _, want := range []string{"a|b,\"c\"\nd|", "x|y"} {
+		record, err := reader.Read()
+		if err != nil || strings.Join(record, "|") != want {
+			t.Errorf("Read() = %q, %v, want %q", record, err, want)
+		}
+	}
+
+	for _, bad := range []string{"\"open\n", "\"a\"b\n"} {
+		reader := &csvReader{r: bufio.NewReader(strings.NewReader(bad)), options: options}
+		if _, err := reader.Read(); err == nil {
+			t.Errorf("no error for %q", bad)
+		}
+	}
+	// A value equal to the NULL marker is escaped, so it stays a value
+	reader = &csvReader{r: bufio.NewReader(strings.NewReader("\\\\N\t\\N\tN\n")), options: loadDataOptions()}
+	record, err := reader.Read()
+	if err != nil || strings.Join(record, "|") != `\N|\N|N` || reader.nulls[0] || !reader.nulls[1] || reader.nulls[2] {
+		t.Errorf("Read() = %q, %v with nulls %v, want only the second field NULL", record, err, reader.nulls)
+	}
+
+	for _, bad := range []CSVOptions{{Delimiter: "ab"}, {Delimiter: `"`}, {Delimiter: ",", Quote: "some"}, {Delimiter: ",", Quote: CSVQuoteNone, Null: "a,b"},
+		{Delimiter: ",", Quote: CSVQuoteNone, Null: ""}, {Delimiter: ",", Quote: CSVQuoteNone, Null: "NULL"}} {
+		if err := validateCSVOptions(&bad); err == nil {
+			t.Errorf("no error for %+v", bad)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// loadDataOptions is the layout LOAD DATA INFILE reads without FIELDS and
+// LINES clauses: tab-separated, backslash escapes and \N for NULL.
+func loadDataOptions() CSVOptions {
+	return CSVOptions{
+		Delimiter:  "\t",
+		Quote:      CSVQuoteNone,
+		Null:       `\N`,
+		TimeLayout: "2006-01-02 15:04:05.999999",
+	}
+}
+
+// loadDataHexTypes are the column types written in hex to TSV exports and
+// loaded back with UNHEX, so their bytes don't go through charset conversion.
+var loadDataHexTypes = map[string]bool{
+	"binary": true, "varbinary": true, "tinyblob": true, "blob": true, "mediumblob": true, "longblob": true,
+	"bit": true, "geometry": true, "vector": true,
+}
+
+// isLoadDataHexType reports whether a column type from SHOW COLUMNS, such
+// as varbinary(16), is written in hex.
+func isLoadDataHexType(colType string) bool {
+	name, _, _ := strings.Cut(strings.ToLower(colType), "(")
+	name, _, _ = strings.Cut(name, " ")
+	return loadDataHexTypes[name]
+}
+
+// exportTableToLoadData writes tableName to TSV files in config.ExportPath
+// and a .load.sql file that recreates the table and loads them with LOAD
+// DATA LOCAL INFILE, and describes them for the manifest.
+func exportTableToLoadData(ctx context.Context, db *sql.DB, dialect Dialect, tableName string, config *Config, logger *Logger) ([]ManifestFile, error) {
+	// Create export directory if it doesn't exist
+	if err := os.MkdirAll(config.ExportPath, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create export directory: %v", err)
+	}
+
+	// Generate filenames
+	timestamp := time.Now().Format("20060102_150405")
+	base := fmt.Sprintf("%s/%s_%s", config.ExportPath, tableName, timestamp)
+
+	logger.Info("Exporting to TSV file: %s.tsv", base)
+
+	createStmt, err := dialect.CreateTableStatement(ctx, db, tableName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get CREATE TABLE: %v", err)
+	}
+	tableColumns, err := dialect.Columns(ctx, db, tableName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get column names: %v", err)
+	}
+	columns, err := exportColumnsFor(tableName, tableColumns, config)
+	if err != nil {
+		return nil, err
+	}
+
+	format, err := newLoadDataFormat(columns.Names, tableColumns)
+	if err != nil {
+		return nil, err
+	}
+	hexColumns := format.hex
+	out, err := newPartWriter(base+".tsv", "tsv", format, config, logger)
+	if err != nil {
+		return nil, err
+	}
+	totalRows, err := exportRows(ctx, db, dialect, tableName, columns, config, out, logger)
+	if err != nil {
+		out.Abort()
+		return nil, err
+	}
+	files, err := out.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	script := loadDataScript(dialect, config, tableName, createStmt, columns.Names, hexColumns, files, totalRows)
+	loadFile, err := writeLoadDataScript(base+".load.sql", script, config)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("Successfully exported %d rows to %d TSV files, load them with %s", totalRows, len(files), loadFile.Name)
+	return append(files, loadFile), nil
+}
+
+// loadDataFormat writes rows as TSV lines for LOAD DATA INFILE. Dates are
+// formatted as in SQL exports and binary values in hex.
+type loadDataFormat struct {
+	*csvFormat
+	hex []bool
+}
+
+// newLoadDataFormat returns the format of the named columns, picking the
+// ones written in hex from the types in tableColumns.
+func newLoadDataFormat(names []string, tableColumns []Column) (*loadDataFormat, error) {
+	csv, err := newCSVFormat(names, loadDataOptions())
+	if err != nil {
+		return nil, err
+	}
+	f := &loadDataFormat{csvFormat: csv, hex: make([]bool, len(names))}
+	for i, name := range names {
+		for _, column := range tableColumns {
+			if column.Name == name {
+				f.hex[i] = isLoadDataHexType(column.Type)
+			}
+		}
+	}
+	return f, nil
+}
+
+func (f *loadDataFormat) NewEncoder() rowEncoder {
+	var buf []byte
+	return func(values []any, columnTypes []*sql.ColumnType) ([]byte, error) {
+		buf = buf[:0]
+		for i, val := range values {
+			if i > 0 {
+				buf = append(buf, '\t')
+			}
+			if val == nil {
+				buf = append(buf, `\N`...)
+				continue
+			}
+			if f.hex[i] {
+				buf = hex.AppendEncode(buf, []byte(maskText(val)))
+				continue
+			}
+			buf = f.appendField(buf, f.formatLoadValue(val, columnTypes[i]))
+		}
+		return append(buf, '\n'), nil
+	}
+}
+
+func (f *loadDataFormat) formatLoadValue(val any, colType *sql.ColumnType) string {
+	switch v := val.(type) {
+	case time.Time:
+		fsp := int64(-1)
+		if _, scale, ok := colType.DecimalSize(); ok {
+			fsp = scale
+		}
+		return formatMySQLTime(v, colType.DatabaseTypeName(), fsp)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	}
+	return f.formatValue(val)
+}
+
+// loadDataScript returns the statements that recreate table and load the
+// TSV files into it, one LOAD DATA statement per part.
+func loadDataScript(dialect Dialect, config *Config, table, createStmt string, columns []string, hexColumns []bool, files []ManifestFile, rows int64) string {
+	beginSettings, endSettings := dialect.DumpSettings()
+
+	var targets, sets []string
+	for i, name := range columns {
+		quoted := dialect.QuoteIdentifier(name)
+		if hexColumns[i] {
+			targets = append(targets, "@"+quoted)
+			sets = append(sets, fmt.Sprintf("%s = UNHEX(@%s)", quoted, quoted))
+			continue
+		}
+		targets = append(targets, quoted)
+	}
+	charset := config.Charset
+	if charset == "" {
+		charset = "utf8mb4"
+	}
+
+	// mysql reads the data files as they are, so it can't load encrypted ones
+	usage := "-- Run from this directory with mysql --local-infile=1, or with the restore command"
+	if len(files) > 0 && strings.HasSuffix(files[0].Name, encryptedSuffix) {
+		usage = "-- The data files are encrypted: run with the restore command and -identity-file,\n" +
+			"-- which decrypts them while loading (mysql --local-infile=1 cannot read them)"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `-- %s LOAD DATA dump of table %s
+-- Host: %s    Database: %s
+-- Generated: %s
+%s
+-- ------------------------------------------------------
+
+%s
+--
+-- Table structure for table %s
+--
+
+DROP TABLE IF EXISTS %s;
+
+%s;
+
+--
+-- Loading data for table %s
+--
+
+`,
+		dialect.Name(),
+		table,
+		config.Host,
+		config.Database,
+		time.Now().Format("2006-01-02 15:04:05"),
+		usage,
+		beginSettings,
+		table,
+		dialect.QuoteIdentifier(table),
+		createStmt,
+		table,
+	)
+
+	for _, file := range files {
+		fmt.Fprintf(&b, "LOAD DATA LOCAL INFILE %s INTO TABLE %s CHARACTER SET %s\n  FIELDS TERMINATED BY '\\t' ESCAPED BY '\\\\'\n  LINES TERMINATED BY '\\n'\n  (%s)",
+			mysqlQuote(file.Name), dialect.QuoteIdentifier(table), charset, strings.Join(targets, ", "))
+		if len(sets) > 0 {
+			fmt.Fprintf(&b, "\n  SET %s", strings.Join(sets, ", "))
+		}
+		b.WriteString(";\n")
+	}
+
+	fmt.Fprintf(&b, `
+--
+-- Dump completed on %s
+-- Total rows exported: %d
+--
+
+%s`,
+		time.Now().Format("2006-01-02 15:04:05"),
+		rows,
+		endSettings,
+	)
+	return b.String()
+}
+
+// writeLoadDataScript writes the .load.sql file, encrypted like the data
+// files it loads.
+func writeLoadDataScript(path, script string, config *Config) (ManifestFile, error) {
+	recipients, err := exportRecipients(config)
+	if err != nil {
+		return ManifestFile{}, err
+	}
+	if len(recipients) > 0 {
+		path += encryptedSuffix
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return ManifestFile{}, fmt.Errorf("failed to create load file: %v", err)
+	}
+	defer file.Close()
+
+	w, err := encryptWriter(file, recipients)
+	if err != nil {
+		return ManifestFile{}, fmt.Errorf("failed to encrypt %s: %v", path, err)
+	}
+	if _, err := w.Write([]byte(script)); err != nil {
+		return ManifestFile{}, fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	if err := w.Close(); err != nil {
+		return ManifestFile{}, fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	if err := file.Close(); err != nil {
+		return ManifestFile{}, fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return ManifestFile{Name: filepath.Base(path), Format: "load"}, nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportTableToLoadData(t *testing.T) {
+	db, config := openTestDB(t)
+	config.ExportPartRows = 2
+	seedSMSLog(t, db, 10, 20, 30)
+	mustExec(t, db, "UPDATE sms_log SET message = 'tab\there\nline \\ end' WHERE id = 1")
+	mustExec(t, db, "UPDATE sms_log SET message = NULL WHERE id = 2")
+
+	files, err := exportTableToLoadData(context.Background(), db, &SQLiteDialect{}, "sms_log", config, testLogger())
+	if err != nil {
+		t.Fatalf("exportTableToLoadData: %v", err)
+	}
+	if len(files) != 3 || files[0].Format != "tsv" || files[1].Format != "tsv" || files[2].Format != "load" {
+		t.Fatalf("exported %v, want two TSV parts and a load file", files)
+	}
+
+	data, err := os.ReadFile(filepath.Join(config.ExportPath, files[0].Name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("first part has %d lines, want 2: %q", len(lines), data)
+	}
+	// Tabs in values are escaped with a backslash, as SELECT ... INTO OUTFILE does
+	if !strings.HasPrefix(lines[0], "1\t23408000000000\t"+`tab\	here\nline \\ end`+"\t0000ff\t") {
+		t.Errorf("first row is %q, want escaped message and hex payload", lines[0])
+	}
+	if fields := strings.Split(lines[1], "\t"); len(fields) != 5 || fields[2] != `\N` {
+		t.Errorf("second row is %q, want \\N for the NULL message", lines[1])
+	}
+
+	loadPath := filepath.Join(config.ExportPath, files[2].Name)
+	script, err := os.ReadFile(loadPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`DROP TABLE IF EXISTS "sms_log";`,
+		"LOAD DATA LOCAL INFILE '" + files[1].Name + `' INTO TABLE "sms_log" CHARACTER SET utf8mb4`,
+		`("id", "msisdn", "message", @"payload", "created_at")`,
+		`SET "payload" = UNHEX(@"payload");`,
+		"-- Total rows exported: 3",
+	} {
+		if !strings.Contains(string(script), want) {
+			t.Errorf("load file lacks %s:\n%s", want, script)
+		}
+	}
+
+	loads, err := readLoadDataScript(loadPath, nil)
+	if err != nil || len(loads) != 2 || loads[0] != files[0].Name {
+		t.Errorf("readLoadDataScript = %v, %v, want both parts", loads, err)
+	}
+}
+
+func TestArchiveTableLoadDataExport(t *testing.T) {
+	db, config := openTestDB(t)
+	config.ExportLoadData = true
+	seedSMSLog(t, db, 100, 50, 40, 1)
+
+	if _, err := archiveTable(context.Background(), db, &SQLiteDialect{}, config, testLogger()); err != nil {
+		t.Fatalf("archiveTable: %v", err)
+	}
+	archive := archiveName("sms_log")
+	manifest, err := readManifest(exportFile(t, config, archive, "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := archiveCheck{Table: archive, Manifest: manifest, ManifestDir: config.ExportPath}
+	if problems := runVerifyArchive(t, config, check); len(problems) != 0 {
+		t.Fatalf("fresh archive reported problems: %v", problems)
+	}
+
+	// A missing data file is reported against the load file too
+	if err := os.Remove(exportFile(t, config, archive, "tsv")); err != nil {
+		t.Fatal(err)
+	}
+	problems := runVerifyArchive(t, config, check)
+	if !containsProblem(problems, "Load file") {
+		t.Errorf("missing TSV file not reported by the load file check, got %v", problems)
+	}
+}
+
+func TestLoadDataValues(t *testing.T) {
+	if !isLoadDataHexType("varbinary(16)") || !isLoadDataHexType("bit(1)") || !isLoadDataHexType("BLOB") || isLoadDataHexType("varchar(32)") {
+		t.Error("isLoadDataHexType misclassifies column types")
+	}
+
+	if got := formatMySQLTime(time.Time{}, "DATETIME", 0); got != "0000-00-00 00:00:00" {
+		t.Errorf("zero DATETIME is written as %q", got)
+	}
+
+	// With -loc=Africa/Lagos the driver reads both types as Lagos times;
+	// DATETIME keeps its wall clock and TIMESTAMP is written in UTC
+	lagos, err := time.LoadLocation("Africa/Lagos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := time.Date(2024, 3, 5, 14, 7, 9, 120000000, lagos)
+	if got := formatMySQLTime(read, "DATETIME", 2); got != "2024-03-05 14:07:09.12" {
+		t.Errorf("DATETIME read in Lagos is written as %q", got)
+	}
+	if got := formatMySQLTime(read, "TIMESTAMP", 0); got != "2024-03-05 13:07:09" {
+		t.Errorf("TIMESTAMP read in Lagos is written as %q", got)
+	}
+	if got := formatMySQLTime(read, "DATE", -1); got != "2024-03-05" {
+		t.Errorf("DATE read in Lagos is written as %q", got)
+	}
+}
+
+func TestLoadDataScriptHeader(t *testing.T) {
+	config := &Config{Host: "db1", Database: "sms_db"}
+	script := func(files ...ManifestFile) string {
+		return loadDataScript(&MySQLDialect{}, config, "sms_log", "CREATE TABLE `sms_log` (`id` int)", []string{"id"}, []bool{false}, files, 1)
+	}
+
+	plain := script(ManifestFile{Name: "sms_log.tsv", Format: "tsv"})
+	if !strings.Contains(plain, "with mysql --local-infile=1, or with the restore command") {
+		t.Errorf("plain load file does not offer mysql:\n%s", plain)
+	}
+
+	encrypted := script(ManifestFile{Name: "sms_log.tsv.age", Format: "tsv"})
+	if !strings.Contains(encrypted, "-- The data files are encrypted: run with the restore command and -identity-file") ||
+		strings.Contains(encrypted, "Run from this directory with mysql") {
+		t.Errorf("encrypted load file header:\n%s", encrypted)
+	}
+	if !strings.Contains(encrypted, "LOAD DATA LOCAL INFILE 'sms_log.tsv.age'") {
+		t.Errorf("encrypted load file does not load the .age file:\n%s", encrypted)
+	}
+}
+
+func TestCutMySQLString(t *testing.T) {
+	tests := []struct {
+		in, value, rest string
+	}{
+		{`'a.tsv' INTO TABLE t`, "a.tsv", " INTO TABLE t"},
+		{`'it''s\\x.tsv';`, `it's\x.tsv`, ";"},
+		{`"q\".tsv"`, `q".tsv`, ""},
+	}
+	for _, tt := range tests {
+		value, rest, err := cutMySQLString(tt.in)
+		if err != nil || value != tt.value || rest != tt.rest {
+			t.Errorf("cutMySQLString(%q) = %q, %q, %v, want %q, %q", tt.in, value, rest, err, tt.value, tt.rest)
+		}
+	}
+	if _, _, err := cutMySQLString(`'open`); err == nil {
+		t.Error("cutMySQLString accepted an unterminated string")
+	}
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"filippo.io/age"
+)
+
+// rangesPerWorker splits the table into more ranges than workers, so a
+// worker that finishes early picks up another range instead of idling.
+const rangesPerWorker = 4
+
+// exportProgressInterval is how many rows are exported between progress
+// log lines.
+const exportProgressInterval = 10000
+
+// exportProgress counts the rows written by all workers of an export.
+type exportProgress struct {
+	rows   atomic.Int64
+	logger *Logger
+}
+
+func newExportProgress(logger *Logger) *exportProgress {
+	return &exportProgress{logger: logger}
+}
+
+func (p *exportProgress) Add(n int64) {
+	if total := p.rows.Add(n); total%exportProgressInterval == 0 {
+		p.logger.Info("Exported %d rows...", total)
+	}
+}
+
+// keyRange is a primary key range (Lower, Upper]; a nil bound is open.
+type keyRange struct {
+	Lower, Upper any
+}
+
+// rowEncoder formats one scanned row of an export.
+type rowEncoder func(values []any, columnTypes []*sql.ColumnType) ([]byte, error)
+
+// exportRows writes every row of table to out. With more than one export
+// worker and a single-column primary key, the table is split into primary
+// key ranges read concurrently on their own connections from one shared
+// snapshot, and the ranges are written to out in key order.
+func exportRows(ctx context.Context, db *sql.DB, dialect Dialect, table string, columns *exportColumns, config *Config, out *partWriter, logger *Logger) (int64, error) {
+	quotedTable := dialect.QuoteIdentifier(table)
+	selectHead := maxExecutionTimeHint(config.MaxExecutionTime) + strings.Join(columns.quotedNames(dialect), ", ")
+	progress := newExportProgress(logger)
+
+	var pkColumns []string
+	if config.ExportWorkers > 1 {
+		var err error
+		pkColumns, err = dialect.PrimaryKey(ctx, db, table)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get primary key: %v", err)
+		}
+		if len(pkColumns) != 1 {
+			logger.Warning("Table %s has no single-column primary key, exporting with one worker", table)
+		}
+	}
+	if len(pkColumns) != 1 {
+		conn, release, err := snapshotConn(ctx, db, dialect)
+		if err != nil {
+			return 0, err
+		}
+		defer release()
+		query := fmt.Sprintf("SELECT %s FROM %s", selectHead, quotedTable)
+		return scanRows(ctx, conn, query, nil, columns.maskEncoder(out.format.NewEncoder()), out.WriteRow, progress)
+	}
+
+	conns, release, err := snapshotConns(ctx, db, dialect, table, config.ExportWorkers)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	pk := dialect.QuoteIdentifier(pkColumns[0])
+	ranges, err := splitKeyRanges(ctx, conns[0], quotedTable, pk, config.ExportWorkers*rangesPerWorker)
+	if err != nil {
+		return 0, fmt.Errorf("failed to split %s into ranges: %v", table, err)
+	}
+	logger.Info("Exporting %s in %d primary key ranges with %d workers", table, len(ranges), config.ExportWorkers)
+
+	// Ranges are buffered in temporary files and written to out in key
+	// order as soon as every range before them is done, so only the ranges
+	// finished out of order wait on disk. When the export is encrypted they
+	// are too, to a key that only lives as long as the export.
+	var tempRecipients []age.Recipient
+	var tempIdentity *age.X25519Identity
+	if len(out.recipients) > 0 {
+		if tempIdentity, err = age.GenerateX25519Identity(); err != nil {
+			return 0, fmt.Errorf("failed to generate temporary key: %v", err)
+		}
+		tempRecipients = []age.Recipient{tempIdentity.Recipient()}
+	}
+	parts := make([]*os.File, len(ranges))
+	done := make([]chan struct{}, len(ranges))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+	defer func() {
+		for _, part := range parts {
+			if part != nil {
+				part.Close()
+				os.Remove(part.Name())
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	// Workers must be gone before their files are removed
+	defer wg.Wait()
+	defer cancel()
+
+	var firstErr error
+	var errOnce sync.Once
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	jobs := make(chan int)
+	go func() {
+		defer close(jobs)
+		for i := range ranges {
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for _, conn := range conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			encode := columns.maskEncoder(out.format.NewEncoder())
+			for index := range jobs {
+				part, err := os.CreateTemp(config.ExportPath, ".export-*.tmp")
+				if err != nil {
+					fail(fmt.Errorf("failed to create temporary file: %v", err))
+					return
+				}
+				parts[index] = part
+
+				query, args := rangeQuery(dialect, selectHead, quotedTable, pk, ranges[index])
+				enc, err := encryptWriter(part, tempRecipients)
+				if err != nil {
+					fail(err)
+					return
+				}
+				w := bufio.NewWriter(enc)
+				_, err = scanRows(ctx, conn, query, args, encode, func(row []byte) error {
+					return writeRecord(w, row)
+				}, progress)
+				if err == nil {
+					err = w.Flush()
+				}
+				if err == nil {
+					err = enc.Close()
+				}
+				if err != nil {
+					fail(err)
+					return
+				}
+				close(done[index])
+			}
+		}()
+	}
+
+	var totalRows int64
+	for i, part := range done {
+		select {
+		case <-part:
+		case <-ctx.Done():
+			wg.Wait()
+			if firstErr != nil {
+				return 0, firstErr
+			}
+			return 0, ctx.Err()
+		}
+
+		rows, err := copyRange(parts[i], tempIdentity, out.WriteRow)
+		if err != nil {
+			fail(err)
+			return 0, err
+		}
+		parts[i].Close()
+		os.Remove(parts[i].Name())
+		parts[i] = nil
+
+		totalRows += rows
+		logger.Info("Exported range %d of %d (%d rows so far)...", i+1, len(ranges), totalRows)
+	}
+
+	return totalRows, nil
+}
+
+// copyRange passes the rows buffered in part to emit and returns how many
+// there were.
+func copyRange(part *os.File, tempIdentity *age.X25519Identity, emit func([]byte) error) (int64, error) {
+	if _, err := part.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	var r io.Reader = part
+	if tempIdentity != nil {
+		var err error
+		if r, err = age.Decrypt(part, tempIdentity); err != nil {
+			return 0, fmt.Errorf("failed to decrypt range: %v", err)
+		}
+	}
+	var rows int64
+	err := readRecords(bufio.NewReader(r), func(row []byte) error {
+		rows++
+		return emit(row)
+	})
+	if err != nil {
+		return rows, fmt.Errorf("failed to copy range: %v", err)
+	}
+	return rows, nil
+}
+
+// scanRows encodes the rows returned by query and passes them to emit.
+func scanRows(ctx context.Context, conn *sql.Conn, query string, args []any, encode rowEncoder, emit func([]byte) error, progress *exportProgress) (int64, error) {
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query data: %v", err)
+	}
+	defer rows.Close()
+
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get column types: %v", err)
+	}
+
+	values := make([]interface{}, len(columnTypes))
+	valuePtrs := make([]interface{}, len(columnTypes))
+	for i := range values {
+		valuePtrs[i] = &values[i]
+	}
+
+	var totalRows int64
+	for rows.Next() {
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return totalRows, fmt.Errorf("failed to scan row: %v", err)
+		}
+		row, err := encode(values, columnTypes)
+		if err != nil {
+			return totalRows, err
+		}
+		if err := emit(row); err != nil {
+			return totalRows, err
+		}
+		totalRows++
+		progress.Add(1)
+	}
+	if err := rows.Err(); err != nil {
+		return totalRows, fmt.Errorf("failed to read data: %v", err)
+	}
+	return totalRows, nil
+}
+
+// writeRecord and readRecords store encoded rows in the temporary range
+// files, each prefixed with its length since rows may contain newlines.
+func writeRecord(w *bufio.Writer, row []byte) error {
+	if _, err := w.Write(binary.AppendUvarint(nil, uint64(len(row)))); err != nil {
+		return err
+	}
+	_, err := w.Write(row)
+	return err
+}
+
+func readRecords(r *bufio.Reader, emit func([]byte) error) error {
+	var row []byte
+	for {
+		n, err := binary.ReadUvarint(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		row = slices.Grow(row[:0], int(n))[:n]
+		if _, err := io.ReadFull(r, row); err != nil {
+			return err
+		}
+		if err := emit(row); err != nil {
+			return err
+		}
+	}
+}
+
+// splitKeyRanges splits the table into at most n ranges of about the same
+// number of rows, reading the keys in one ordered pass on conn so the ranges
+// match its snapshot. The first range has no lower bound and the last no
+// upper bound, so rows outside the sampled keys are still covered.
+func splitKeyRanges(ctx context.Context, conn *sql.Conn, quotedTable, pk string, n int) ([]keyRange, error) {
+	var count int64
+	if err := conn.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s", quotedTable)).Scan(&count); err != nil {
+		return nil, err
+	}
+
+	// The i-th bound is the key of row count*i/n; several bounds fall on
+	// the same row when there are fewer rows than ranges
+	next := 1
+	target := func() int64 { return count * int64(next) / int64(n) }
+	for next < n && target() == 0 {
+		next++
+	}
+
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s ORDER BY %s", pk, quotedTable, pk))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bounds []any
+	for seen := int64(1); next < n && rows.Next(); seen++ {
+		if target() != seen {
+			continue
+		}
+		var bound any
+		if err := rows.Scan(&bound); err != nil {
+			return nil, err
+		}
+		bounds = append(bounds, bound)
+		for next < n && target() <= seen {
+			next++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	ranges := make([]keyRange, 0, len(bounds)+1)
+	var lower any
+	for _, bound := range bounds {
+		ranges = append(ranges, keyRange{Lower: lower, Upper: bound})
+		lower = bound
+	}
+	return append(ranges, keyRange{Lower: lower}), nil
+}
+
+// rangeQuery selects the rows of range r; selectHead is everything between
+// SELECT and FROM.
+func rangeQuery(dialect Dialect, selectHead, quotedTable, pk string, r keyRange) (string, []any) {
+	query := fmt.Sprintf("SELECT %s FROM %s", selectHead, quotedTable)
+	var args []any
+	switch {
+	case r.Lower != nil && r.Upper != nil:
+		query += fmt.Sprintf(" WHERE %s > ? AND %s <= ?", pk, pk)
+		args = []any{r.Lower, r.Upper}
+	case r.Lower != nil:
+		query += fmt.Sprintf(" WHERE %s > ?", pk)
+		args = []any{r.Lower}
+	case r.Upper != nil:
+		query += fmt.Sprintf(" WHERE %s <= ?", pk)
+		args = []any{r.Upper}
+	}
+	return dialect.Rebind(query + " ORDER BY " + pk), args
+}
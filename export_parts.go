@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// exportFormat writes the rows of an export in a file format. Begin and End
+// are called for every part, so each part can be read on its own.
+type exportFormat interface {
+	// NewEncoder returns a function that formats one scanned row. The
+	// returned bytes are only valid until its next call, and each encoder
+	// is used by a single goroutine.
+	NewEncoder() rowEncoder
+	Begin(w *countingWriter, part int) error
+	Row(w *countingWriter, row []byte) error
+	End(w *countingWriter, rows int64) error
+}
+
+// countingWriter counts the bytes written to a part, before encryption, so
+// it can be rolled over at -export-part-size.
+type countingWriter struct {
+	*bufio.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+func (w *countingWriter) WriteString(s string) (int, error) {
+	n, err := w.Writer.WriteString(s)
+	w.n += int64(n)
+	return n, err
+}
+
+// partWriter writes the rows of an export to path, or with a part size or
+// row limit, to numbered part files next to it: table_ts_part0001.sql,
+// table_ts_part0002.sql and so on. A part is only started when there is a
+// row for it, except for the first part of an empty table.
+type partWriter struct {
+	path     string
+	kind     string
+	format   exportFormat
+	maxRows  int64
+	maxBytes int64
+	logger   *Logger
+
+	// recipients the parts are encrypted to, if any
+	recipients []age.Recipient
+
+	file  *os.File
+	enc   io.WriteCloser
+	w     *countingWriter
+	rows  int64
+	files []ManifestFile
+}
+
+func newPartWriter(path, kind string, format exportFormat, config *Config, logger *Logger) (*partWriter, error) {
+	recipients, err := exportRecipients(config)
+	if err != nil {
+		return nil, err
+	}
+	return &partWriter{
+		path:       path,
+		kind:       kind,
+		format:     format,
+		maxRows:    config.ExportPartRows,
+		maxBytes:   config.ExportPartBytes,
+		logger:     logger,
+		recipients: recipients,
+	}, nil
+}
+
+func (p *partWriter) split() bool {
+	return p.maxRows > 0 || p.maxBytes > 0
+}
+
+func (p *partWriter) WriteRow(row []byte) error {
+	if p.file == nil {
+		if err := p.openPart(); err != nil {
+			return err
+		}
+	}
+	if err := p.format.Row(p.w, row); err != nil {
+		return err
+	}
+	p.rows++
+
+	if (p.maxRows > 0 && p.rows >= p.maxRows) || (p.maxBytes > 0 && p.w.n >= p.maxBytes) {
+		return p.closePart()
+	}
+	return nil
+}
+
+// Close finishes the current part and returns every file written.
+func (p *partWriter) Close() ([]ManifestFile, error) {
+	if p.file == nil && len(p.files) == 0 {
+		if err := p.openPart(); err != nil {
+			return nil, err
+		}
+	}
+	if p.file != nil {
+		if err := p.closePart(); err != nil {
+			return nil, err
+		}
+	}
+	return p.files, nil
+}
+
+// Abort closes the current part after a failed export, leaving the files
+// written so far for inspection.
+func (p *partWriter) Abort() {
+	if p.file != nil {
+		p.w.Flush()
+		p.enc.Close()
+		p.file.Close()
+		p.file = nil
+	}
+}
+
+func (p *partWriter) openPart() error {
+	part := len(p.files) + 1
+	filename := p.path
+	if p.split() {
+		ext := filepath.Ext(p.path)
+		filename = fmt.Sprintf("%s_part%04d%s", strings.TrimSuffix(p.path, ext), part, ext)
+	}
+	if len(p.recipients) > 0 {
+		filename += encryptedSuffix
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create %s file: %v", strings.ToUpper(p.kind), err)
+	}
+	enc, err := encryptWriter(file, p.recipients)
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to encrypt %s: %v", filename, err)
+	}
+	p.file = file
+	p.enc = enc
+	p.w = &countingWriter{Writer: bufio.NewWriterSize(enc, 1<<20)}
+	p.rows = 0
+
+	if p.split() {
+		p.logger.Info("Exporting part %d to file: %s", part, filename)
+	}
+	if err := p.format.Begin(p.w, part); err != nil {
+		p.Abort()
+		return fmt.Errorf("failed to write header: %v", err)
+	}
+	return nil
+}
+
+func (p *partWriter) closePart() error {
+	defer p.Abort()
+
+	if err := p.format.End(p.w, p.rows); err != nil {
+		return fmt.Errorf("failed to write footer: %v", err)
+	}
+	if err := p.w.Flush(); err != nil {
+		return fmt.Errorf("failed to write %s: %v", p.file.Name(), err)
+	}
+	if err := p.enc.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %v", p.file.Name(), err)
+	}
+	if err := p.file.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %v", p.file.Name(), err)
+	}
+
+	p.files = append(p.files, ManifestFile{Name: filepath.Base(p.file.Name()), Format: p.kind, Rows: p.rows})
+	p.file = nil
+	return nil
+}
+
+// parseByteSize parses a size such as 500M or 2G, with binary K, M, G and
+// T suffixes. A plain number is a number of bytes.
+func parseByteSize(size string) (int64, error) {
+	s := strings.TrimSuffix(strings.TrimSpace(strings.ToUpper(size)), "B")
+
+	multiplier := int64(1)
+	if n := len(s); n > 0 {
+		if i := strings.IndexByte("KMGT", s[n-1]); i >= 0 {
+			multiplier = int64(1) << (10 * (i + 1))
+			s = s[:n-1]
+		}
+	}
+
+	value, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	return value * multiplier, nil
+}
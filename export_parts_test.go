@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExportParts(t *testing.T) {
+	db, config := openTestDB(t)
+	ages := make([]int, 250)
+	for i := range ages {
+		ages[i] = i
+	}
+	seedSMSLog(t, db, ages...)
+	config.ExportPartRows = 40
+	config.ExportWorkers = 3
+
+	ctx := context.Background()
+	dialect := &SQLiteDialect{}
+	sqlFiles, err := exportTableToSQL(ctx, db, dialect, "sms_log", config, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	csvFiles, err := exportTableToCSV(ctx, db, dialect, "sms_log", config, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := archiveCheck{Table: "sms_log"}
+	for _, files := range [][]ManifestFile{sqlFiles, csvFiles} {
+		if len(files) != 7 {
+			t.Fatalf("exported %d parts, want 7: %+v", len(files), files)
+		}
+		var rows int64
+		for i, file := range files {
+			if want := fmt.Sprintf("_part%04d.%s", i+1, file.Format); !strings.HasSuffix(file.Name, want) {
+				t.Errorf("part %d is named %s, want suffix %s", i+1, file.Name, want)
+			}
+			if file.Rows > 40 {
+				t.Errorf("part %s has %d rows", file.Name, file.Rows)
+			}
+			rows += file.Rows
+
+			path := filepath.Join(config.ExportPath, file.Name)
+			if file.Format == "sql" {
+				check.SQLFiles = append(check.SQLFiles, path)
+			} else {
+				check.CSVFiles = append(check.CSVFiles, path)
+			}
+		}
+		if rows != 250 {
+			t.Errorf("parts have %d rows, want 250", rows)
+		}
+	}
+
+	// Each SQL part restores on its own, in order
+	restored, _ := openTestDB(t)
+	for i, path := range check.SQLFiles {
+		dump, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hasCreate := strings.Contains(string(dump), "CREATE TABLE"); hasCreate != (i == 0) {
+			t.Errorf("part %d contains CREATE TABLE: %v", i+1, hasCreate)
+		}
+		if !strings.HasPrefix(string(dump), "-- SQLite dump") || !strings.Contains(string(dump), "PRAGMA foreign_keys=OFF;") ||
+			!strings.Contains(string(dump), "BEGIN TRANSACTION;") || !strings.Contains(string(dump), "COMMIT;") {
+			t.Errorf("part %d is not a self-contained dump:\n%s", i+1, dump)
+		}
+		mustExec(t, restored, string(dump))
+	}
+	query := "SELECT id, msisdn, message, payload, created_at FROM sms_log ORDER BY id"
+	if got, want := queryAll(t, restored, query), queryAll(t, db, query); got != want {
+		t.Error("restored parts differ from the table")
+	}
+
+	if problems := runVerifyArchive(t, config, check); len(problems) != 0 {
+		t.Fatalf("parts reported problems: %v", problems)
+	}
+
+	// A missing part is reported
+	check.SQLFiles = check.SQLFiles[1:]
+	check.CSVFiles = check.CSVFiles[:6]
+	problems := runVerifyArchive(t, config, check)
+	if !containsProblem(problems, "has 210 rows with checksum") || !containsProblem(problems, "has 240 rows with checksum") {
+		t.Errorf("missing parts not reported, got %v", problems)
+	}
+}
+
+func TestExportPartSize(t *testing.T) {
+	db, config := openTestDB(t)
+	ages := make([]int, 100)
+	seedSMSLog(t, db, ages...)
+	config.ExportPartBytes = 2048
+
+	files, err := exportTableToCSV(context.Background(), db, &SQLiteDialect{}, "sms_log", config, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) < 2 {
+		t.Fatalf("exported %d parts, want several", len(files))
+	}
+	for _, file := range files {
+		info, err := os.Stat(filepath.Join(config.ExportPath, file.Name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// A part is closed by the row that reaches the limit
+		if info.Size() > 2048+100 {
+			t.Errorf("part %s is %d bytes", file.Name, info.Size())
+		}
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	for in, want := range map[string]int64{"0": 0, "512": 512, "64k": 64 << 10, "500M": 500 << 20, "2GB": 2 << 30, "1T": 1 << 40} {
+		if got, err := parseByteSize(in); err != nil || got != want {
+			t.Errorf("parseByteSize(%q) = %d, %v, want %d", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "M", "1.5G", "-1", "10X"} {
+		if _, err := parseByteSize(in); err == nil {
+			t.Errorf("parseByteSize(%q) did not fail", in)
+		}
+	}
+}
@@ -1,239 +1,297 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"encoding/hex"
 	"fmt"
 	"os"
 	"strings"
 	"time"
 )
 
-func exportTableToSQL(db *sql.DB, tableName string, config *Config, logger *Logger) error {
+// exportTableToSQL writes tableName to new files in config.ExportPath and
+// describes them for the manifest. Without a part limit the export is a
+// single file.
+func exportTableToSQL(ctx context.Context, db *sql.DB, dialect Dialect, tableName string, config *Config, logger *Logger) ([]ManifestFile, error) {
 	// Create export directory if it doesn't exist
 	if err := os.MkdirAll(config.ExportPath, 0o755); err != nil {
-		return fmt.Errorf("failed to create export directory: %v", err)
+		return nil, fmt.Errorf("failed to create export directory: %v", err)
 	}
 
 	// Generate filename
 	timestamp := time.Now().Format("20060102_150405")
 	filename := fmt.Sprintf("%s/%s_%s.sql", config.ExportPath, tableName, timestamp)
 
-	// Create the SQL file
-	file, err := os.Create(filename)
-	if err != nil {
-		return fmt.Errorf("failed to create SQL file: %v", err)
-	}
-	defer file.Close()
-
 	logger.Info("Exporting to file: %s", filename)
 
-	// Write SQL file header
-	header := fmt.Sprintf(`-- MySQL dump of table %s
--- Host: %s    Database: %s
--- Generated: %s
--- ------------------------------------------------------
-
-SET @OLD_UNIQUE_CHECKS=@@UNIQUE_CHECKS, UNIQUE_CHECKS=0;
-SET @OLD_FOREIGN_KEY_CHECKS=@@FOREIGN_KEY_CHECKS, FOREIGN_KEY_CHECKS=0;
-SET @OLD_SQL_MODE=@@SQL_MODE, SQL_MODE='NO_AUTO_VALUE_ON_ZERO';
-SET @OLD_TIME_ZONE=@@TIME_ZONE, TIME_ZONE='+00:00';
-
---
--- Table structure for table %s
---
-
-DROP TABLE IF EXISTS `+"`%s`"+`;
-
-`,
-		tableName,
-		config.Host,
-		config.Database,
-		time.Now().Format("2006-01-02 15:04:05"),
-		tableName,
-		tableName,
-	)
-
-	if _, err := file.WriteString(header); err != nil {
-		return fmt.Errorf("failed to write header: %v", err)
-	}
-
 	// Get CREATE TABLE statement
-	createStmt, err := getCreateTable(db, tableName)
+	createStmt, err := dialect.CreateTableStatement(ctx, db, tableName)
 	if err != nil {
-		return fmt.Errorf("failed to get CREATE TABLE: %v", err)
+		return nil, fmt.Errorf("failed to get CREATE TABLE: %v", err)
 	}
 
-	if _, err := file.WriteString(createStmt + ";\n\n"); err != nil {
-		return fmt.Errorf("failed to write CREATE TABLE: %v", err)
+	// Get the exported columns
+	tableColumns, err := dialect.Columns(ctx, db, tableName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get column names: %v", err)
 	}
-
-	// Lock table for consistent read
-	lockSQL := fmt.Sprintf("LOCK TABLES `%s` READ", tableName)
-	if _, err := db.Exec(lockSQL); err != nil {
-		return fmt.Errorf("failed to lock table: %v", err)
+	columns, err := exportColumnsFor(tableName, tableColumns, config)
+	if err != nil {
+		return nil, err
 	}
-	defer db.Exec("UNLOCK TABLES")
 
-	// Get column names
-	columns, err := getColumnNames(db, tableName)
+	format := &sqlFormat{
+		dialect:      dialect,
+		config:       config,
+		table:        tableName,
+		createStmt:   createStmt,
+		insertPrefix: fmt.Sprintf("INSERT INTO %s (%s) VALUES\n", dialect.QuoteIdentifier(tableName), strings.Join(columns.quotedNames(dialect), ",")),
+	}
+	out, err := newPartWriter(filename, "sql", format, config, logger)
 	if err != nil {
-		return fmt.Errorf("failed to get column names: %v", err)
+		return nil, err
 	}
-
-	// Write data header
-	dataHeader := fmt.Sprintf("--\n-- Dumping data for table `%s`\n--\n\nLOCK TABLES `%s` WRITE;\n", tableName, tableName)
-	if _, err := file.WriteString(dataHeader); err != nil {
-		return fmt.Errorf("failed to write data header: %v", err)
+	totalRows, err := exportRows(ctx, db, dialect, tableName, columns, config, out, logger)
+	if err != nil {
+		out.Abort()
+		return nil, err
+	}
+	files, err := out.Close()
+	if err != nil {
+		return nil, err
 	}
 
-	// Export data in batches
-	batchSize := 1000
-	offset := 0
-	totalRows := 0
+	logger.Info("Successfully exported %d rows to %d SQL files", totalRows, len(files))
+	return files, nil
+}
 
-	for {
-		rows, err := db.Query(fmt.Sprintf("SELECT * FROM `%s` LIMIT %d OFFSET %d", tableName, batchSize, offset))
-		if err != nil {
-			return fmt.Errorf("failed to query data: %v", err)
-		}
+// sqlFormat writes rows as INSERT statements of up to sqlInsertRows rows.
+// Every part starts and ends with the dialect's session settings and data
+// lock, so it can be restored on its own; only the first part drops and
+// creates the table.
+type sqlFormat struct {
+	dialect      Dialect
+	config       *Config
+	table        string
+	createStmt   string
+	insertPrefix string
+
+	// batchRows is the number of rows in the open INSERT statement
+	batchRows int
+}
 
-		// Get column types
-		columnTypes, err := rows.ColumnTypes()
-		if err != nil {
-			rows.Close()
-			return fmt.Errorf("failed to get column types: %v", err)
-		}
+const sqlInsertRows = 100
 
-		values := make([]interface{}, len(columns))
-		valuePtrs := make([]interface{}, len(columns))
-		for i := range values {
-			valuePtrs[i] = &values[i]
+func (f *sqlFormat) NewEncoder() rowEncoder {
+	var buf []byte
+	return func(values []any, columnTypes []*sql.ColumnType) ([]byte, error) {
+		buf = append(buf[:0], '(')
+		for i, val := range values {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = append(buf, f.dialect.FormatValue(val, columnTypes[i])...)
 		}
+		return append(buf, ')'), nil
+	}
+}
 
-		rowCount := 0
-		var insertStatements []string
+func (f *sqlFormat) Begin(w *countingWriter, part int) error {
+	beginSettings, _ := f.dialect.DumpSettings()
+	beginData, _ := f.dialect.DumpDataLock(f.table)
 
-		for rows.Next() {
-			if err := rows.Scan(valuePtrs...); err != nil {
-				rows.Close()
-				return fmt.Errorf("failed to scan row: %v", err)
-			}
+	partLine := ""
+	if part > 1 {
+		partLine = fmt.Sprintf("-- Part: %d (restore after part %d)\n", part, part-1)
+	}
 
-			// Build INSERT statement
-			valueStrings := make([]string, len(values))
-			for i, val := range values {
-				valueStrings[i] = formatSQLValue(val, columnTypes[i])
-			}
+	// Write SQL file header
+	header := fmt.Sprintf(`-- %s dump of table %s
+-- Host: %s    Database: %s
+-- Generated: %s
+%s-- ------------------------------------------------------
 
-			insertStatements = append(insertStatements, fmt.Sprintf("(%s)", strings.Join(valueStrings, ",")))
-			rowCount++
-			totalRows++
-
-			// Write in batches of 100 rows per INSERT statement
-			if len(insertStatements) >= 100 {
-				insertSQL := fmt.Sprintf("INSERT INTO `%s` (%s) VALUES\n%s;\n",
-					tableName,
-					strings.Join(columns, ","),
-					strings.Join(insertStatements, ",\n"))
-
-				if _, err := file.WriteString(insertSQL); err != nil {
-					rows.Close()
-					return fmt.Errorf("failed to write INSERT: %v", err)
-				}
-				insertStatements = nil
-			}
-		}
+%s
+`,
+		f.dialect.Name(),
+		f.table,
+		f.config.Host,
+		f.config.Database,
+		time.Now().Format("2006-01-02 15:04:05"),
+		partLine,
+		beginSettings,
+	)
 
-		rows.Close()
+	if part == 1 {
+		header += fmt.Sprintf(`--
+-- Table structure for table %s
+--
 
-		// Write remaining INSERT statements
-		if len(insertStatements) > 0 {
-			insertSQL := fmt.Sprintf("INSERT INTO `%s` (%s) VALUES\n%s;\n",
-				tableName,
-				strings.Join(columns, ","),
-				strings.Join(insertStatements, ",\n"))
+DROP TABLE IF EXISTS %s;
 
-			if _, err := file.WriteString(insertSQL); err != nil {
-				return fmt.Errorf("failed to write INSERT: %v", err)
-			}
-		}
+%s;
 
-		// If we got fewer rows than batch size, we're done
-		if rowCount < batchSize {
-			break
-		}
+`,
+			f.table,
+			f.dialect.QuoteIdentifier(f.table),
+			f.createStmt,
+		)
+	}
+
+	// Write data header
+	header += fmt.Sprintf("--\n-- Dumping data for table %s\n--\n\n%s", f.table, beginData)
+
+	f.batchRows = 0
+	_, err := w.WriteString(header)
+	return err
+}
+
+func (f *sqlFormat) Row(w *countingWriter, row []byte) error {
+	sep := ",\n"
+	if f.batchRows == 0 {
+		sep = f.insertPrefix
+	}
+	if _, err := w.WriteString(sep); err != nil {
+		return err
+	}
+	if _, err := w.Write(row); err != nil {
+		return err
+	}
 
-		offset += batchSize
-		logger.Info("Exported %d rows...", totalRows)
+	// Write in batches of 100 rows per INSERT statement
+	f.batchRows++
+	if f.batchRows >= sqlInsertRows {
+		f.batchRows = 0
+		_, err := w.WriteString(";\n")
+		return err
 	}
+	return nil
+}
 
-	// Write footer
-	footer := fmt.Sprintf(`UNLOCK TABLES;
+func (f *sqlFormat) End(w *countingWriter, rows int64) error {
+	// Write remaining INSERT statements
+	if f.batchRows > 0 {
+		f.batchRows = 0
+		if _, err := w.WriteString(";\n"); err != nil {
+			return err
+		}
+	}
+
+	_, endSettings := f.dialect.DumpSettings()
+	_, endData := f.dialect.DumpDataLock(f.table)
 
+	// Write footer
+	footer := fmt.Sprintf(`%s
 --
 -- Dump completed on %s
 -- Total rows exported: %d
 --
 
-SET SQL_MODE=@OLD_SQL_MODE;
-SET FOREIGN_KEY_CHECKS=@OLD_FOREIGN_KEY_CHECKS;
-SET UNIQUE_CHECKS=@OLD_UNIQUE_CHECKS;
-SET TIME_ZONE=@OLD_TIME_ZONE;
-`,
+%s`,
+		endData,
 		time.Now().Format("2006-01-02 15:04:05"),
-		totalRows,
+		rows,
+		endSettings,
 	)
 
-	if _, err := file.WriteString(footer); err != nil {
-		return fmt.Errorf("failed to write footer: %v", err)
+	_, err := w.WriteString(footer)
+	return err
+}
+
+// snapshotConn pins a connection and starts a snapshot on it, since a
+// transaction only covers the reads made on its own connection. Calling
+// release ends the snapshot and returns the connection to the pool.
+func snapshotConn(ctx context.Context, db *sql.DB, dialect Dialect) (*sql.Conn, func(), error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get connection: %v", err)
+	}
+
+	end, err := dialect.BeginSnapshot(ctx, conn)
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("failed to start snapshot: %v", err)
 	}
 
-	logger.Info("Successfully exported %d rows to %s", totalRows, filename)
-	return nil
+	return conn, func() {
+		end()
+		conn.Close()
+	}, nil
+}
+
+// snapshotConns is snapshotConn for n connections that all read the same
+// snapshot of table.
+func snapshotConns(ctx context.Context, db *sql.DB, dialect Dialect, table string, n int) ([]*sql.Conn, func(), error) {
+	conns := make([]*sql.Conn, 0, n)
+	closeAll := func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}
+	for i := 0; i < n; i++ {
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			closeAll()
+			return nil, nil, fmt.Errorf("failed to get connection: %v", err)
+		}
+		conns = append(conns, conn)
+	}
+
+	end, err := dialect.BeginSharedSnapshot(ctx, db, table, conns)
+	if err != nil {
+		closeAll()
+		return nil, nil, fmt.Errorf("failed to start snapshot: %v", err)
+	}
+
+	return conns, func() {
+		end()
+		closeAll()
+	}, nil
 }
 
-func getColumnNames(db *sql.DB, tableName string) ([]string, error) {
-	query := fmt.Sprintf("SELECT COLUMN_NAME FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = '%s' ORDER BY ORDINAL_POSITION", tableName)
-	rows, err := db.Query(query)
+// getColumnNames returns the quoted column names of tableName.
+func getColumnNames(ctx context.Context, db *sql.DB, dialect Dialect, tableName string) ([]string, error) {
+	columns, err := dialect.Columns(ctx, db, tableName)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	var columns []string
-	for rows.Next() {
-		var columnName string
-		if err := rows.Scan(&columnName); err != nil {
-			return nil, err
-		}
-		columns = append(columns, fmt.Sprintf("`%s`", columnName))
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = dialect.QuoteIdentifier(column.Name)
 	}
 
-	return columns, nil
+	return names, nil
 }
 
-func formatSQLValue(val interface{}, colType *sql.ColumnType) string {
+// formatSQLValue returns val as a MySQL literal. typeName is the
+// DatabaseTypeName of the column and fsp its fractional seconds precision,
+// or -1 when unknown; they decide how binary, BIT, DECIMAL and temporal
+// values are written so they load back unchanged.
+func formatSQLValue(val interface{}, typeName string, fsp int64) string {
 	if val == nil {
 		return "NULL"
 	}
 
 	switch v := val.(type) {
 	case []byte:
-		// Handle binary data and strings
-		str := string(v)
-		// Escape special characters
-		str = strings.ReplaceAll(str, "\\", "\\\\")
-		str = strings.ReplaceAll(str, "'", "\\'")
-		str = strings.ReplaceAll(str, "\n", "\\n")
-		str = strings.ReplaceAll(str, "\r", "\\r")
-		str = strings.ReplaceAll(str, "\x00", "\\0")
-		str = strings.ReplaceAll(str, "\x1a", "\\Z")
-		return fmt.Sprintf("'%s'", str)
-	case time.Time:
-		if v.IsZero() {
-			return "NULL"
+		switch typeName {
+		case "BINARY", "VARBINARY", "TINYBLOB", "BLOB", "MEDIUMBLOB", "LONGBLOB", "GEOMETRY", "VECTOR":
+			// Hex keeps bytes that are not valid in the connection charset
+			if len(v) == 0 {
+				return "''"
+			}
+			return "0x" + hex.EncodeToString(v)
+		case "BIT":
+			return mysqlBitLiteral(v)
+		case "DECIMAL":
+			// The exact digits sent by the server
+			return string(v)
 		}
-		return fmt.Sprintf("'%s'", v.Format("2006-01-02 15:04:05"))
+		return mysqlQuote(string(v))
+	case time.Time:
+		return "'" + formatMySQLTime(v, typeName, fsp) + "'"
 	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
 		return fmt.Sprintf("%v", v)
 	case float32, float64:
@@ -244,20 +302,69 @@ func formatSQLValue(val interface{}, colType *sql.ColumnType) string {
 		}
 		return "0"
 	case string:
-		// Escape special characters
-		str := v
-		str = strings.ReplaceAll(str, "\\", "\\\\")
-		str = strings.ReplaceAll(str, "'", "\\'")
-		str = strings.ReplaceAll(str, "\n", "\\n")
-		str = strings.ReplaceAll(str, "\r", "\\r")
-		str = strings.ReplaceAll(str, "\x00", "\\0")
-		str = strings.ReplaceAll(str, "\x1a", "\\Z")
-		return fmt.Sprintf("'%s'", str)
+		return mysqlQuote(v)
 	default:
 		// For any other type, convert to string and escape
-		str := fmt.Sprintf("%v", v)
-		str = strings.ReplaceAll(str, "\\", "\\\\")
-		str = strings.ReplaceAll(str, "'", "\\'")
-		return fmt.Sprintf("'%s'", str)
+		return mysqlQuote(fmt.Sprintf("%v", v))
+	}
+}
+
+// mysqlQuote returns s as a quoted string literal with MySQL's backslash
+// escapes.
+func mysqlQuote(s string) string {
+	s = strings.ReplaceAll(s, "\\", "\\\\")
+	s = strings.ReplaceAll(s, "'", "\\'")
+	s = strings.ReplaceAll(s, "\n", "\\n")
+	s = strings.ReplaceAll(s, "\r", "\\r")
+	s = strings.ReplaceAll(s, "\x00", "\\0")
+	s = strings.ReplaceAll(s, "\x1a", "\\Z")
+	return "'" + s + "'"
+}
+
+// mysqlBitLiteral returns a BIT value, sent big-endian, as b'...'.
+func mysqlBitLiteral(v []byte) string {
+	var bits strings.Builder
+	for _, b := range v {
+		fmt.Fprintf(&bits, "%08b", b)
+	}
+	digits := strings.TrimLeft(bits.String(), "0")
+	if digits == "" {
+		digits = "0"
+	}
+	return "b'" + digits + "'"
+}
+
+// isZeroDate reports whether t is a zero date as scanned by the driver,
+// which returns exactly time.Time{}. Real dates carry the location they were
+// read in, so a genuine 0001-01-01 00:00:00 is not one (see mysqlConfig).
+func isZeroDate(t time.Time) bool {
+	return t == time.Time{}
+}
+
+// formatMySQLTime formats a DATE, DATETIME or TIMESTAMP value. Zero dates are
+// written back as zero dates. TIMESTAMP values are written in UTC, the time
+// zone the dump sets; this relies on the session time_zone matching -loc
+// (see checkSessionTimeZone).
+func formatMySQLTime(t time.Time, typeName string, fsp int64) string {
+	if isZeroDate(t) {
+		if typeName == "DATE" {
+			return "0000-00-00"
+		}
+		return "0000-00-00 00:00:00"
+	}
+	if typeName == "DATE" {
+		return t.Format("2006-01-02")
+	}
+	if typeName == "TIMESTAMP" {
+		t = t.UTC()
+	}
+
+	layout := "2006-01-02 15:04:05"
+	switch {
+	case fsp > 0:
+		layout += "." + strings.Repeat("0", int(min(fsp, 6)))
+	case fsp < 0:
+		layout += ".999999"
 	}
+	return t.Format(layout)
 }
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatSQLValue(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	moment := time.Date(2024, 3, 5, 14, 7, 9, 120000000, time.UTC)
+
+	for _, tc := range []struct {
+		val      any
+		typeName string
+		fsp      int64
+		want     string
+	}{
+		{nil, "VARCHAR", 0, "NULL"},
+		{[]byte("it's a \\ test\n\x00"), "VARCHAR", 0, `'it\'s a \\ test\n\0'`},
+		{[]byte{0x00, 0xff, 0x80, '\''}, "VARBINARY", 0, "0x00ff8027"},
+		{[]byte{0xde, 0xad}, "LONGBLOB", 0, "0xdead"},
+		{[]byte{}, "BLOB", 0, "''"},
+		{[]byte{0x05}, "BIT", 0, "b'101'"},
+		{[]byte{0x01, 0x00}, "BIT", 0, "b'100000000'"},
+		{[]byte{0x00, 0x00}, "BIT", 0, "b'0'"},
+		{[]byte("12345678901234567890.123456789"), "DECIMAL", 9, "12345678901234567890.123456789"},
+		{[]byte("-0.10"), "DECIMAL", 2, "-0.10"},
+		{[]byte(`{"a": "it's", "b": [1, 2.50]}`), "JSON", 0, `'{"a": "it\'s", "b": [1, 2.50]}'`},
+		{time.Time{}, "DATE", 0, "'0000-00-00'"},
+		{time.Time{}, "DATETIME", 6, "'0000-00-00 00:00:00'"},
+		{time.Time{}, "TIMESTAMP", 0, "'0000-00-00 00:00:00'"},
+		// A real first day of year 1, as read in the driver's UTC
+		{time.Date(1, 1, 1, 0, 0, 0, 0, driverUTC), "DATE", 0, "'0001-01-01'"},
+		{time.Date(1, 1, 1, 0, 0, 0, 0, driverUTC), "DATETIME", 0, "'0001-01-01 00:00:00'"},
+		{moment, "DATE", 0, "'2024-03-05'"},
+		{moment, "DATETIME", 0, "'2024-03-05 14:07:09'"},
+		{moment, "DATETIME", 6, "'2024-03-05 14:07:09.120000'"},
+		{moment, "DATETIME", 3, "'2024-03-05 14:07:09.120'"},
+		{moment, "", -1, "'2024-03-05 14:07:09.12'"},
+		{moment.In(berlin), "DATETIME", 0, "'2024-03-05 15:07:09'"},
+		{moment.In(berlin), "TIMESTAMP", 0, "'2024-03-05 14:07:09'"},
+		{int64(-42), "BIGINT", 0, "-42"},
+		{uint64(18446744073709551615), "UNSIGNED BIGINT", 0, "18446744073709551615"},
+		{float32(0.1), "FLOAT", 0, "0.1"},
+		{true, "", -1, "1"},
+	} {
+		if got := formatSQLValue(tc.val, tc.typeName, tc.fsp); got != tc.want {
+			t.Errorf("formatSQLValue(%#v, %s, %d) = %s, want %s", tc.val, tc.typeName, tc.fsp, got, tc.want)
+		}
+	}
+}
+
+// TestFormatSQLValueBytes checks the literals written for the bytes that
+// need escaping or would not survive the connection charset.
+func TestFormatSQLValueBytes(t *testing.T) {
+	for _, tc := range []struct {
+		val      []byte
+		typeName string
+		want     string
+	}{
+		{[]byte("\x00\x1a\\'\"\n\r\t%_"), "VARCHAR", `'\0\Z\\\'"\n\r` + "\t" + `%_'`},
+		{[]byte("caf\xc3\xa9 \xff\xfe"), "TEXT", "'caf\xc3\xa9 \xff\xfe'"},
+		{[]byte("\\\\''"), "CHAR", `'\\\\\'\''`},
+		{[]byte{0x00, 0x27, 0x5c, 0x0a, 0x80, 0xff}, "VARBINARY", "0x00275c0a80ff"},
+		{[]byte{0x00}, "BINARY", "0x00"},
+		{[]byte{0x27}, "MEDIUMBLOB", "0x27"},
+		{[]byte{0x00, 0x00, 0x01}, "BIT", "b'1'"},
+		{[]byte{0x80}, "BIT", "b'10000000'"},
+		{[]byte{0xff, 0x00}, "BIT", "b'1111111100000000'"},
+	} {
+		if got := formatSQLValue(tc.val, tc.typeName, 0); got != tc.want {
+			t.Errorf("formatSQLValue(%q, %s) = %q, want %q", tc.val, tc.typeName, got, tc.want)
+		}
+	}
+}
@@ -2,6 +2,24 @@ module dbarchiving
 
 go 1.25.0
 
-require github.com/go-sql-driver/mysql v1.9.3
+require (
+	filippo.io/age v1.2.1
+	github.com/go-sql-driver/mysql v1.9.3
+	github.com/lib/pq v1.12.3
+	golang.org/x/term v0.36.0
+	modernc.org/sqlite v1.48.0
+)
 
-require filippo.io/edwards25519 v1.1.0 // indirect
+require (
+	filippo.io/edwards25519 v1.1.0 // indirect
+	github.com/dustin/go-humanize v1.0.1 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
+	github.com/ncruces/go-strftime v1.0.0 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
+	golang.org/x/crypto v0.24.0 // indirect
+	golang.org/x/sys v0.42.0 // indirect
+	modernc.org/libc v1.70.0 // indirect
+	modernc.org/mathutil v1.7.1 // indirect
+	modernc.org/memory v1.11.0 // indirect
+)
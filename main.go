@@ -1,46 +1,156 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
-	"regexp"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
-
-	_ "github.com/go-sql-driver/mysql"
 )
 
 type Config struct {
-	Host       string
-	Port       int
-	User       string
-	Password   string
-	Database   string
-	Table      string
-	DaysToKeep int
-	DryRun     bool
-	ExportSQL  bool
-	ExportCSV  bool
-	ExportPath string
+	Driver          string
+	Host            string
+	Port            int
+	User            string
+	Password        string
+	Database        string
+	Table           string
+	DaysToKeep      int
+	DryRun          bool
+	ExportSQL       bool
+	ExportCSV       bool
+	ExportLoadData  bool
+	ExportPath      string
+	ExportWorkers   int
+	ExportPartRows  int64
+	ExportPartSize  string
+	ExportPartBytes int64
+	CSV             CSVOptions
+
+	ExportInclude     string
+	ExportExclude     string
+	ExportMask        string
+	ExportMaskSaltEnv string
+
+	EncryptRecipients     string
+	EncryptRecipientsFile string
+	IdentityFile          string
+
+	PasswordFile   string
+	PasswordEnv    string
+	DefaultsFile   string
+	PromptPassword bool
+
+	Socket          string
+	TLSMode         string
+	TLSCA           string
+	TLSCert         string
+	TLSKey          string
+	TLSServerName   string
+	ConnectTimeout  time.Duration
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	Charset         string
+	Collation       string
+	Location        string
+	SessionTimeZone string
+
+	CountTimeout     time.Duration
+	CopyTimeout      time.Duration
+	DeleteTimeout    time.Duration
+	RenameTimeout    time.Duration
+	ExportTimeout    time.Duration
+	MaxExecutionTime time.Duration
+
+	ArchiveDropForeignKeys bool
+	ArchiveAutoIncrement   string
+	ArchiveEngine          string
+	ArchiveRowFormat       string
+	ArchiveKeyBlockSize    int
+	ArchiveDropIndexes     bool
+
+	Cascade bool
+
+	PartitionMode     string
+	PartitionAhead    int
+	PartitionInterval string
+
+	Verify string
+
+	ChunkSize        int
+	Replicas         string
+	MaxReplicaLag    time.Duration
+	HeartbeatTable   string
+	HeartbeatUTC     bool
+	ThrottleInterval time.Duration
+	MaxLoad          string
+	CriticalLoad     string
+
+	WebhookURL         string
+	WebhookTemplate    string
+	WebhookContentType string
+	WebhookOn          string
+	WebhookTimeout     time.Duration
+}
+
+// ArchiveResult describes what an archive run did, for logging and notifications.
+type ArchiveResult struct {
+	ArchiveTable string
+	ArchiveCount int64
+	KeepCount    int64
+	Step         string
+	RolledBack   bool
+	Interrupted  bool
+	Dependents   []DependentResult
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		os.Exit(runVerify(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		os.Exit(runRestore(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "decrypt" {
+		os.Exit(runDecrypt(os.Args[2:]))
+	}
+
 	config := parseFlags()
 	logger := NewLogger()
+	startedAt := time.Now()
+	ctx := interruptContext(logger)
 
 	logger.Info("Starting database archive process")
 	logger.Info("Table: %s, Days to keep: %d, Dry run: %v", config.Table, config.DaysToKeep, config.DryRun)
 
-	db, err := connectDB(config, logger)
+	dialect, err := newDialect(config.Driver)
+	if err != nil {
+		logger.Error("%v", err)
+		os.Exit(1)
+	}
+
+	db, err := connectDB(ctx, dialect, config, logger)
 	if err != nil {
 		logger.Error("Failed to connect to database: %v", err)
+		sendNotification(config, newNotification(config, nil, err, startedAt), logger)
 		os.Exit(1)
 	}
 	defer db.Close()
 
-	if err := archiveTable(db, config, logger); err != nil {
+	result, err := archiveTable(ctx, db, dialect, config, logger)
+	if err != nil && ctx.Err() != nil {
+		result.Interrupted = true
+	}
+	logReport(logger, result, err)
+	sendNotification(config, newNotification(config, result, err, startedAt), logger)
+	if err != nil {
 		logger.Error("Archive failed: %v", err)
 		os.Exit(1)
 	}
@@ -48,20 +158,98 @@ func main() {
 	logger.Info("Archive process completed successfully")
 }
 
+// interruptContext returns a context that is cancelled on the first SIGINT or
+// SIGTERM. A second signal terminates the process immediately.
+func interruptContext(logger *Logger) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		signal.Stop(signals)
+		logger.Warning("Received %s, stopping at the next safe point (repeat to force quit)", sig)
+		cancel()
+	}()
+
+	return ctx
+}
+
+func logReport(logger *Logger, result *ArchiveResult, err error) {
+	status := "completed"
+	if err != nil {
+		status = "failed"
+	}
+	if result.Interrupted {
+		status = "interrupted"
+	}
+
+	logger.Info("Run report: status=%s last_step=%q archive_table=%s archived=%d kept=%d rolled_back=%v",
+		status, result.Step, result.ArchiveTable, result.ArchiveCount, result.KeepCount, result.RolledBack)
+	for _, dependent := range result.Dependents {
+		logger.Info("Run report: dependent_table=%s archive_table=%s archived=%d", dependent.Table, dependent.ArchiveTable, dependent.ArchiveCount)
+	}
+}
+
 func parseFlags() *Config {
 	config := &Config{}
 
-	flag.StringVar(&config.Host, "host", "localhost", "Database host")
-	flag.IntVar(&config.Port, "port", 3306, "Database port")
-	flag.StringVar(&config.User, "user", "root", "Database user")
-	flag.StringVar(&config.Password, "password", "", "Database password")
-	flag.StringVar(&config.Database, "database", "", "Database name")
+	connectionFlags(flag.CommandLine, config)
 	flag.StringVar(&config.Table, "table", "", "Table name to archive")
 	flag.IntVar(&config.DaysToKeep, "days", 90, "Number of days to keep in the original table")
 	flag.BoolVar(&config.DryRun, "dry-run", false, "Run without making changes")
 	flag.BoolVar(&config.ExportSQL, "export-sql", false, "Export archived table to SQL file")
 	flag.BoolVar(&config.ExportCSV, "export-csv", false, "Export archived table to CSV file")
+	flag.BoolVar(&config.ExportLoadData, "export-load-data", false, "Export archived table to TSV files and a .load.sql file that loads them with LOAD DATA LOCAL INFILE (MySQL)")
 	flag.StringVar(&config.ExportPath, "export-path", "./archives", "Path to save exported SQL files")
+	flag.IntVar(&config.ExportWorkers, "export-workers", 1, "Connections used to export each table in parallel by primary key range")
+	flag.Int64Var(&config.ExportPartRows, "export-part-rows", 0, "Start a new export part file after this many rows (0 = no limit)")
+	flag.StringVar(&config.ExportPartSize, "export-part-size", "", "Start a new export part file after this size, e.g. 500M or 2G (default: no limit)")
+	csvFlags(flag.CommandLine, config)
+	flag.StringVar(&config.ExportInclude, "export-include", "", "Comma-separated columns to export, optionally as table.column (default: all)")
+	flag.StringVar(&config.ExportExclude, "export-exclude", "", "Comma-separated columns to leave out of exports, optionally as table.column")
+	flag.StringVar(&config.ExportMask, "export-mask", "", "Comma-separated column=rule masks for exports: hash, redact, truncate:N or last:N, e.g. msisdn=last:4")
+	flag.StringVar(&config.ExportMaskSaltEnv, "export-mask-salt-env", "EXPORT_MASK_SALT", "Environment variable holding the salt for the hash mask")
+	flag.StringVar(&config.EncryptRecipients, "encrypt-recipients", "", "Comma-separated age public keys (age1...) to encrypt exports to")
+	flag.StringVar(&config.EncryptRecipientsFile, "encrypt-recipients-file", "", "File with age public keys to encrypt exports to, one per line")
+	flag.DurationVar(&config.CountTimeout, "count-timeout", 0, "Time limit for counting and verifying records (0 = no limit)")
+	flag.DurationVar(&config.CopyTimeout, "copy-timeout", 0, "Time limit for copying records to the new table (0 = no limit)")
+	flag.DurationVar(&config.DeleteTimeout, "delete-timeout", 0, "Time limit for deleting archived records, and for moving dependent rows with -cascade (0 = no limit)")
+	flag.DurationVar(&config.RenameTimeout, "rename-timeout", 0, "Metadata lock wait limit for the table rename, rounded up to seconds (0 = server default)")
+	flag.DurationVar(&config.ExportTimeout, "export-timeout", 0, "Time limit for each export (0 = no limit)")
+	flag.DurationVar(&config.MaxExecutionTime, "max-execution-time", 0, "MAX_EXECUTION_TIME hint added to SELECT statements (0 = none)")
+	flag.BoolVar(&config.ArchiveDropForeignKeys, "archive-drop-foreign-keys", false, "Create the archive table without foreign keys")
+	flag.StringVar(&config.ArchiveAutoIncrement, "archive-auto-increment", AutoIncrementKeep, "AUTO_INCREMENT on the archive table: keep, reset (drop the counter) or drop (also the column attribute)")
+	flag.StringVar(&config.ArchiveEngine, "archive-engine", "", "Storage engine for the archive table, e.g. ARCHIVE or InnoDB (default: same as source)")
+	flag.StringVar(&config.ArchiveRowFormat, "archive-row-format", "", "ROW_FORMAT for the archive table, e.g. COMPRESSED")
+	flag.IntVar(&config.ArchiveKeyBlockSize, "archive-key-block-size", 0, "KEY_BLOCK_SIZE for a compressed archive table, e.g. 8")
+	flag.BoolVar(&config.ArchiveDropIndexes, "archive-drop-indexes", false, "Create the archive table without secondary indexes")
+	flag.BoolVar(&config.Cascade, "cascade", false, "Also archive rows in tables that reference archived rows through foreign keys")
+	flag.StringVar(&config.PartitionMode, "partition-mode", PartitionModeOff, "Archive whole RANGE partitions: off, exchange (into archive tables) or drop (after exporting)")
+	flag.IntVar(&config.PartitionAhead, "partition-ahead", 0, "In partition mode, make sure partitions exist for this many future intervals")
+	flag.StringVar(&config.PartitionInterval, "partition-interval", "month", "Range of each future partition: day, week, month or year")
+	flag.StringVar(&config.Verify, "verify", VerifyCount, "How to verify the copy before deleting: count or checksum (compares every row)")
+	flag.IntVar(&config.ChunkSize, "chunk-size", 0, fmt.Sprintf("Copy and delete in chunks of this many rows (0 = single statement, %d when throttling)", defaultThrottleChunkSize))
+	flag.StringVar(&config.Replicas, "replicas", "", "Comma-separated replica host[:port] list to monitor for replication lag")
+	flag.DurationVar(&config.MaxReplicaLag, "max-replica-lag", 10*time.Second, "Pause copy and delete while any replica lags more than this")
+	flag.StringVar(&config.HeartbeatTable, "heartbeat-table", "", "pt-heartbeat style table (e.g. percona.heartbeat) to measure lag instead of SHOW REPLICA STATUS")
+	flag.BoolVar(&config.HeartbeatUTC, "heartbeat-utc", true, "The heartbeat ts column holds UTC (pt-heartbeat --utc); false compares it with NOW() on the replica")
+	flag.DurationVar(&config.ThrottleInterval, "throttle-interval", time.Second, "How often to re-check while throttled")
+	flag.StringVar(&config.MaxLoad, "max-load", "", "Pause while any status variable exceeds its limit, e.g. Threads_running=25")
+	flag.StringVar(&config.CriticalLoad, "critical-load", "", "Abort and roll back when any status variable exceeds its limit, e.g. Threads_running=100")
+	flag.StringVar(&config.WebhookURL, "webhook-url", "", "URL to POST a notification to when the run finishes")
+	flag.StringVar(&config.WebhookTemplate, "webhook-template", "", "Path to a text/template file for the webhook payload (default: Slack-compatible JSON)")
+	flag.StringVar(&config.WebhookContentType, "webhook-content-type", "application/json", "Content-Type header of the webhook request")
+	flag.StringVar(&config.WebhookOn, "webhook-on", "all", "Comma-separated statuses to notify on: success, failure, nothing_to_archive, dry_run or all")
+	flag.DurationVar(&config.WebhookTimeout, "webhook-timeout", 10*time.Second, "Timeout for the webhook request")
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprint(flag.CommandLine.Output(), credentialHelp)
+		fmt.Fprintf(flag.CommandLine.Output(), "\nRun %s verify -h to check an existing archive table and its exports,\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "%s restore -h to load an export back, or %s decrypt -h to read an encrypted export.\n", os.Args[0], os.Args[0])
+	}
 
 	flag.Parse()
 
@@ -71,21 +259,113 @@ func parseFlags() *Config {
 		os.Exit(1)
 	}
 
+	if err := validateArchiveOptions(config); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if config.Verify != VerifyCount && config.Verify != VerifyChecksum {
+		fmt.Printf("Error: invalid -verify %q (want count or checksum)\n", config.Verify)
+		os.Exit(1)
+	}
+
+	if config.ExportLoadData && config.Driver != DriverMySQL {
+		fmt.Println("Error: -export-load-data needs -driver=mysql, since LOAD DATA is MySQL syntax")
+		os.Exit(1)
+	}
+
+	if config.ExportWorkers < 1 {
+		fmt.Printf("Error: invalid -export-workers %d (want at least 1)\n", config.ExportWorkers)
+		os.Exit(1)
+	}
+
+	if err := validateCSVOptions(&config.CSV); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if _, err := parseExportColumnRules(config); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if _, err := exportRecipients(config); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if config.ExportPartRows < 0 {
+		fmt.Printf("Error: invalid -export-part-rows %d\n", config.ExportPartRows)
+		os.Exit(1)
+	}
+	if config.ExportPartSize != "" {
+		size, err := parseByteSize(config.ExportPartSize)
+		if err != nil {
+			fmt.Printf("Error: invalid -export-part-size: %v\n", err)
+			os.Exit(1)
+		}
+		config.ExportPartBytes = size
+	}
+
+	throttling := config.Replicas != "" || config.MaxLoad != "" || config.CriticalLoad != ""
+	if throttling && config.ChunkSize == 0 {
+		config.ChunkSize = defaultThrottleChunkSize
+	}
+
+	if err := resolveConnectionFlags(flag.CommandLine, config); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
 	return config
 }
 
-func connectDB(config *Config, logger *Logger) (*sql.DB, error) {
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true",
-		config.User, config.Password, config.Host, config.Port, config.Database)
+// connectionFlags defines the flags selecting and connecting to the
+// database server on fs.
+func connectionFlags(fs *flag.FlagSet, config *Config) {
+	fs.StringVar(&config.Driver, "driver", DriverMySQL, "Database server type: mysql, postgres or sqlite")
+	fs.StringVar(&config.Host, "host", "localhost", "Database host")
+	fs.IntVar(&config.Port, "port", 3306, "Database port (default 5432 with -driver=postgres)")
+	fs.StringVar(&config.User, "user", "root", "Database user")
+	fs.StringVar(&config.Password, "password", "", "Database password (insecure, see credential sources below)")
+	fs.StringVar(&config.PasswordFile, "password-file", "", "Read the database password from this file")
+	fs.StringVar(&config.PasswordEnv, "password-env", "DB_PASSWORD", "Environment variable to read the database password from")
+	fs.StringVar(&config.DefaultsFile, "defaults-file", "", "MySQL option file with a [client] section (default ~/.my.cnf when present)")
+	fs.BoolVar(&config.PromptPassword, "prompt-password", false, "Prompt for the database password on the terminal")
+	fs.StringVar(&config.Database, "database", "", "Database name")
+	fs.StringVar(&config.Socket, "socket", "", "Unix socket path (overrides host and port)")
+	fs.StringVar(&config.TLSMode, "tls", "false", "TLS mode: false, true, skip-verify or preferred")
+	fs.StringVar(&config.TLSCA, "tls-ca", "", "Path to the CA certificate used to verify the server (enables TLS)")
+	fs.StringVar(&config.TLSCert, "tls-cert", "", "Path to the client certificate (enables TLS)")
+	fs.StringVar(&config.TLSKey, "tls-key", "", "Path to the client private key (enables TLS)")
+	fs.StringVar(&config.TLSServerName, "tls-server-name", "", "Server name to verify in the certificate (default: host)")
+	fs.DurationVar(&config.ConnectTimeout, "connect-timeout", 10*time.Second, "Timeout for establishing a connection")
+	fs.DurationVar(&config.ReadTimeout, "read-timeout", 0, "I/O read timeout (0 = no timeout)")
+	fs.DurationVar(&config.WriteTimeout, "write-timeout", 0, "I/O write timeout (0 = no timeout)")
+	fs.StringVar(&config.Charset, "charset", "", "Connection character set, e.g. utf8mb4")
+	fs.StringVar(&config.Collation, "collation", "", "Connection collation, e.g. utf8mb4_unicode_ci")
+	fs.StringVar(&config.Location, "loc", "UTC", "Time zone used to interpret DATETIME values, e.g. Local or Africa/Lagos")
+	fs.StringVar(&config.SessionTimeZone, "session-time-zone", "", "Value for the session time_zone variable, e.g. +00:00 (default: +00:00 with -loc=UTC, else the server default)")
+}
 
-	logger.Info("Connecting to database %s@%s:%d/%s", config.User, config.Host, config.Port, config.Database)
+// resolveConnectionFlags checks the connection flags parsed by fs against
+// the driver and fills in defaults and credentials.
+func resolveConnectionFlags(fs *flag.FlagSet, config *Config) error {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
 
-	db, err := sql.Open("mysql", dsn)
-	if err != nil {
-		return nil, err
+	if err := validateDriverFlags(config, explicit); err != nil {
+		return err
+	}
+	if config.Driver == DriverPostgres && !explicit["port"] {
+		config.Port = 5432
 	}
+	return resolveCredentials(config, explicit)
+}
 
-	if err := db.Ping(); err != nil {
+func connectDB(ctx context.Context, dialect Dialect, config *Config, logger *Logger) (*sql.DB, error) {
+	db, err := dialect.Open(ctx, config, logger)
+	if err != nil {
 		return nil, err
 	}
 
@@ -93,128 +373,339 @@ func connectDB(config *Config, logger *Logger) (*sql.DB, error) {
 	return db, nil
 }
 
-func archiveTable(db *sql.DB, config *Config, logger *Logger) error {
+func archiveTable(ctx context.Context, db *sql.DB, dialect Dialect, config *Config, logger *Logger) (*ArchiveResult, error) {
 	suffix := time.Now().Format("20060102")
 	newTableName := fmt.Sprintf("%s_%s", config.Table, suffix)
 	archiveTableName := fmt.Sprintf("%s_archive_%s", config.Table, suffix)
+	result := &ArchiveResult{ArchiveTable: archiveTableName}
 
 	// Step 1: Get the CREATE TABLE statement
+	if err := beginStep(ctx, result, "create statement"); err != nil {
+		return result, err
+	}
 	logger.Info("Step 1: Retrieving CREATE TABLE statement for %s", config.Table)
-	createStmt, err := getCreateTable(db, config.Table)
+	createStmt, err := dialect.CreateTableStatement(ctx, db, config.Table)
 	if err != nil {
-		return fmt.Errorf("failed to get CREATE TABLE: %v", err)
+		return result, fmt.Errorf("failed to get CREATE TABLE: %v", err)
+	}
+
+	if config.PartitionMode != PartitionModeOff {
+		result.ArchiveTable = ""
+		if err := archivePartitions(ctx, db, dialect, config, createStmt, result, logger); err != nil {
+			return result, err
+		}
+		result.Step = "done"
+		return result, nil
 	}
 
 	// Step 2: Count records to archive and keep
+	if err := beginStep(ctx, result, "count"); err != nil {
+		return result, err
+	}
 	logger.Info("Step 2: Counting records")
-	archiveCount, keepCount, dateColumn, err := countRecords(db, config, logger)
+	countCtx, cancel := stepContext(ctx, config.CountTimeout)
+	archiveCount, keepCount, dateColumn, err := countRecords(countCtx, db, dialect, config, logger)
+	cancel()
 	if err != nil {
-		return fmt.Errorf("failed to count records: %v", err)
+		return result, stepError("count", countCtx, config.CountTimeout, fmt.Errorf("failed to count records: %v", err))
 	}
 
 	logger.Info("Records to archive: %d, Records to keep: %d", archiveCount, keepCount)
+	result.ArchiveCount = archiveCount
+	result.KeepCount = keepCount
 
 	if archiveCount == 0 {
 		logger.Warning("No records to archive. Exiting.")
-		return nil
+		return result, nil
+	}
+
+	cutoffDate := time.Now().AddDate(0, 0, -config.DaysToKeep)
+
+	var dependents []*dependentTable
+	if config.Cascade {
+		logger.Info("Step 2b: Finding tables that reference %s", config.Table)
+		planCtx, cancel := stepContext(ctx, config.CountTimeout)
+		dependents, err = planCascade(planCtx, db, dialect, config.Table, dateColumn, cutoffDate, suffix, logger)
+		cancel()
+		if err != nil {
+			return result, stepError("count", planCtx, config.CountTimeout, fmt.Errorf("failed to plan cascade: %v", err))
+		}
+		for _, dependent := range dependents {
+			logger.Info("Dependent table %s: %d records reference archived rows", dependent.Name, dependent.Count)
+		}
+	}
+
+	if config.Driver == DriverMySQL {
+		if err := checkTableReferences(ctx, db, config.Table, archiveTableName, logger); err != nil {
+			return result, err
+		}
 	}
 
 	if config.DryRun {
 		logger.Info("DRY RUN MODE - No changes will be made")
 		logger.Info("Would create archive table: %s", newTableName)
 		logger.Info("Would move %d records to archive", archiveCount)
-		logger.Info("Would rename original table to: %s", archiveTableName)
-		return nil
+		for _, dependent := range dependents {
+			logger.Info("Would move %d records from %s to %s", dependent.Count, dependent.Name, dependent.ArchiveTable)
+		}
+		logger.Info("Would rename %s to: %s", newTableName, archiveTableName)
+		return result, nil
 	}
 
+	// Until the delete in Step 6 succeeds the original table is untouched, so
+	// rolling back only needs to move dependent rows back and drop the new
+	// table. Both must run even when ctx has been cancelled.
+	rollback := func() {
+		if len(result.Dependents) > 0 {
+			if err := restoreDependents(context.WithoutCancel(ctx), db, result.Dependents, logger); err != nil {
+				logger.Error("Rollback failed: %v", err)
+				return
+			}
+			result.Dependents = nil
+		}
+		logger.Warning("Rolling back: dropping new table %s", newTableName)
+		dropSQL := fmt.Sprintf("DROP TABLE IF EXISTS %s", dialect.QuoteIdentifier(newTableName))
+		if err := executeSQL(context.WithoutCancel(ctx), db, dropSQL, logger); err != nil {
+			logger.Error("Rollback failed: %v", err)
+			return
+		}
+		result.RolledBack = true
+	}
+
+	throttler, err := newThrottler(ctx, db, config, logger)
+	if err != nil {
+		return result, err
+	}
+	defer throttler.Close()
+
 	// Step 3: Create new table with modified name
+	if err := beginStep(ctx, result, "create table"); err != nil {
+		return result, err
+	}
 	logger.Info("Step 3: Creating new table %s", newTableName)
-	newCreateStmt := modifyCreateStatement(createStmt, config.Table, newTableName, suffix)
-	if err := executeSQL(db, newCreateStmt, logger); err != nil {
-		return fmt.Errorf("failed to create new table: %v", err)
+	newCreateStmt, changes, err := dialect.ArchiveTableStatement(createStmt, config.Table, newTableName, suffix, config)
+	if err != nil {
+		return result, err
+	}
+	for _, change := range changes {
+		logger.Info("Archive table: %s", change)
+	}
+	if err := executeSQL(ctx, db, newCreateStmt, logger); err != nil {
+		return result, fmt.Errorf("failed to create new table: %v", err)
 	}
 
 	// Step 4: Copy old records to new table
+	if err := beginStep(ctx, result, "copy"); err != nil {
+		rollback()
+		return result, err
+	}
 	logger.Info("Step 4: Copying old records to %s", newTableName)
-	cutoffDate := time.Now().AddDate(0, 0, -config.DaysToKeep)
-	if err := copyOldRecords(db, config.Table, newTableName, dateColumn, cutoffDate, logger); err != nil {
-		logger.Error("Failed to copy records, dropping new table")
-		executeSQL(db, fmt.Sprintf("DROP TABLE IF EXISTS `%s`", newTableName), logger)
-		return fmt.Errorf("failed to copy records: %v", err)
+	copyCtx, cancel := stepContext(ctx, config.CopyTimeout)
+	err = copyOldRecords(copyCtx, db, dialect, config.Table, newTableName, dateColumn, cutoffDate, config.ChunkSize, throttler, logger)
+	cancel()
+	if err != nil {
+		logger.Error("Failed to copy records")
+		rollback()
+		return result, stepError("copy", copyCtx, config.CopyTimeout, fmt.Errorf("failed to copy records: %v", err))
 	}
 
 	// Step 5: Verify the copy
+	if err := beginStep(ctx, result, "verify"); err != nil {
+		rollback()
+		return result, err
+	}
 	logger.Info("Step 5: Verifying copied records")
-	copiedCount, err := getTableCount(db, newTableName)
+	verifyCtx, cancel := stepContext(ctx, config.CountTimeout)
+	copiedCount, err := getTableCount(verifyCtx, db, dialect, newTableName)
+	cancel()
 	if err != nil {
-		return fmt.Errorf("failed to verify copied records: %v", err)
+		rollback()
+		return result, stepError("verify", verifyCtx, config.CountTimeout, fmt.Errorf("failed to verify copied records: %v", err))
 	}
 
-	if copiedCount != keepCount {
+	if copiedCount != archiveCount {
 		logger.Error("Record count mismatch! Expected: %d, Got: %d", archiveCount, copiedCount)
-		return fmt.Errorf("record count mismatch")
+		rollback()
+		return result, fmt.Errorf("record count mismatch")
+	}
+
+	if config.Verify == VerifyChecksum {
+		logger.Info("Step 5a: Comparing checksums of %s and %s", config.Table, newTableName)
+		verifyCtx, cancel := stepContext(ctx, config.CountTimeout)
+		mismatches, err := verifyChecksums(verifyCtx, db, dialect, config.Table, newTableName, dateColumn, cutoffDate, config.ChunkSize, logger)
+		cancel()
+		if err != nil {
+			rollback()
+			return result, stepError("verify", verifyCtx, config.CountTimeout, fmt.Errorf("failed to compare checksums: %v", err))
+		}
+		if len(mismatches) > 0 {
+			for _, mismatch := range mismatches {
+				logger.Error("Checksum mismatch in primary key range %s: %d source rows (checksum %s), %d archived rows (checksum %s)",
+					mismatch.Range(), mismatch.SourceRows, mismatch.SourceChecksum, mismatch.ArchiveRows, mismatch.ArchiveChecksum)
+			}
+			rollback()
+			return result, fmt.Errorf("checksum mismatch in %s", mismatchedRanges(mismatches))
+		}
 	}
 
 	logger.Info("Verification successful: %d records copied", copiedCount)
 
-	// Step 6: Delete old records from original table
-	logger.Info("Step 6: Deleting archived records from %s", config.Table)
-	if err := deleteOldRecords(db, config.Table, dateColumn, cutoffDate, logger); err != nil {
-		return fmt.Errorf("failed to delete old records: %v", err)
+	// Dependent rows must be gone before their parents can be deleted
+	if len(dependents) > 0 {
+		if err := beginStep(ctx, result, "cascade"); err != nil {
+			rollback()
+			return result, err
+		}
+		logger.Info("Step 5b: Archiving rows from %d dependent tables", len(dependents))
+		cascadeCtx, cancel := stepContext(ctx, config.DeleteTimeout)
+		result.Dependents, err = archiveDependents(cascadeCtx, db, dialect, dependents, suffix, config, throttler, logger)
+		cancel()
+		if err != nil {
+			rollback()
+			return result, stepError("cascade", cascadeCtx, config.DeleteTimeout, fmt.Errorf("failed to archive dependent tables: %v", err))
+		}
 	}
 
-	// Step 7: Rename original table
-	logger.Info("Step 7: Renaming original table to %s", archiveTableName)
-	renameSQL := fmt.Sprintf("RENAME TABLE `%s` TO `%s`", config.Table, archiveTableName)
-	if err := executeSQL(db, renameSQL, logger); err != nil {
-		return fmt.Errorf("failed to rename table: %v", err)
+	// Step 6: Delete old records from original table
+	if err := beginStep(ctx, result, "delete"); err != nil {
+		rollback()
+		return result, err
+	}
+	logger.Info("Step 6: Deleting archived records from %s", config.Table)
+	deleteCtx, cancel := stepContext(ctx, config.DeleteTimeout)
+	deletedCount, err := deleteOldRecords(deleteCtx, db, dialect, config.Table, dateColumn, cutoffDate, config.ChunkSize, throttler, logger)
+	cancel()
+	if err != nil {
+		if deletedCount > 0 {
+			// Committed chunks are gone from the original table; the new
+			// table holds their only copy and must be kept.
+			logger.Error("%d archived records were already deleted from %s; their copies remain in %s", deletedCount, config.Table, newTableName)
+		} else {
+			// Nothing was committed: a failed or killed DELETE is rolled
+			// back by the server
+			rollback()
+		}
+		return result, stepError("delete", deleteCtx, config.DeleteTimeout, fmt.Errorf("failed to delete old records: %v", err))
 	}
 
-	// Step 8: Rename new table to original name
-	logger.Info("Step 8: Renaming %s to %s", newTableName, config.Table)
-	renameSQL = fmt.Sprintf("RENAME TABLE `%s` TO `%s`", newTableName, config.Table)
-	if err := executeSQL(db, renameSQL, logger); err != nil {
-		return fmt.Errorf("failed to rename new table: %v", err)
+	// The archived records are already gone from the original table, so the
+	// rename must not be interrupted either. Its timeout is enforced by the
+	// server instead.
+	result.Step = "rename"
+	logger.Info("Step 7: Renaming %s to %s", newTableName, archiveTableName)
+	if err := dialect.RenameTable(context.WithoutCancel(ctx), db, newTableName, archiveTableName, config.RenameTimeout, logger); err != nil {
+		logger.Error("Archived records remain in %s", newTableName)
+		return result, fmt.Errorf("failed to rename table: %v", err)
 	}
 
-	logger.Info("Archive complete! Old table renamed to %s, new table is now %s", archiveTableName, config.Table)
+	logger.Info("Archive complete! Archived records are in %s, %s keeps %d records", archiveTableName, config.Table, keepCount)
 
 	// Step 9: Export archived table if requested
-	if config.ExportSQL || config.ExportCSV {
+	if config.ExportSQL || config.ExportCSV || config.ExportLoadData {
+		if err := beginStep(ctx, result, "export"); err != nil {
+			return result, fmt.Errorf("archive completed but exports were skipped: %v", err)
+		}
+
+		var exported []ManifestFile
 		if config.ExportSQL {
 			logger.Info("Step 9a: Exporting archived table to SQL file")
-			if err := exportTableToSQL(db, archiveTableName, config, logger); err != nil {
+			exportCtx, cancel := stepContext(ctx, config.ExportTimeout)
+			files, err := exportTableToSQL(exportCtx, db, dialect, archiveTableName, config, logger)
+			cancel()
+			if err != nil {
 				logger.Error("Failed to export SQL: %v", err)
 				// Don't fail the entire process if export fails
 			} else {
 				logger.Info("SQL export completed successfully")
+				exported = append(exported, files...)
 			}
 		}
 
 		if config.ExportCSV {
 			logger.Info("Step 9b: Exporting archived table to CSV file")
-			if err := exportTableToCSV(db, archiveTableName, config, logger); err != nil {
+			exportCtx, cancel := stepContext(ctx, config.ExportTimeout)
+			files, err := exportTableToCSV(exportCtx, db, dialect, archiveTableName, config, logger)
+			cancel()
+			if err != nil {
 				logger.Error("Failed to export CSV: %v", err)
 				// Don't fail the entire process if export fails
 			} else {
 				logger.Info("CSV export completed successfully")
+				exported = append(exported, files...)
 			}
 		}
+
+		if config.ExportLoadData {
+			logger.Info("Step 9c: Exporting archived table to TSV files for LOAD DATA")
+			exportCtx, cancel := stepContext(ctx, config.ExportTimeout)
+			files, err := exportTableToLoadData(exportCtx, db, dialect, archiveTableName, config, logger)
+			cancel()
+			if err != nil {
+				logger.Error("Failed to export TSV: %v", err)
+				// Don't fail the entire process if export fails
+			} else {
+				logger.Info("TSV export completed successfully")
+				exported = append(exported, files...)
+			}
+		}
+
+		if len(exported) > 0 {
+			logger.Info("Step 9d: Writing manifest for %s", archiveTableName)
+			exportCtx, cancel := stepContext(ctx, config.ExportTimeout)
+			err := writeManifest(exportCtx, db, dialect, archiveTableName, exported, config, logger)
+			cancel()
+			if err != nil {
+				logger.Error("Failed to write manifest: %v", err)
+			}
+		}
+
+		if ctx.Err() != nil {
+			return result, fmt.Errorf("archive completed but exports were interrupted: %v", ctx.Err())
+		}
 	}
 
-	return nil
+	result.Step = "done"
+	return result, nil
 }
 
-func getCreateTable(db *sql.DB, tableName string) (string, error) {
-	var table, createStmt string
-	query := fmt.Sprintf("SHOW CREATE TABLE `%s`", tableName)
-	err := db.QueryRow(query).Scan(&table, &createStmt)
-	return createStmt, err
+// stepContext bounds a single step by timeout; zero means no limit.
+func stepContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// stepError makes it explicit in the error when a step ran out of time.
+func stepError(step string, stepCtx context.Context, timeout time.Duration, err error) error {
+	if errors.Is(stepCtx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%s step exceeded its %s timeout: %v", step, timeout, err)
+	}
+	return err
 }
 
-func countRecords(db *sql.DB, config *Config, logger *Logger) (archiveCount, keepCount int64, dateColumn string, err error) {
+// maxExecutionTimeHint returns an optimizer hint limiting a SELECT to limit,
+// to be placed directly after the SELECT keyword.
+func maxExecutionTimeHint(limit time.Duration) string {
+	if limit <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("/*+ MAX_EXECUTION_TIME(%d) */ ", limit.Milliseconds())
+}
+
+// beginStep records step as the current step of the run, or returns an error
+// if the run was interrupted before the step could start.
+func beginStep(ctx context.Context, result *ArchiveResult, step string) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("interrupted before %s step: %v", step, err)
+	}
+	result.Step = step
+	return nil
+}
+
+func countRecords(ctx context.Context, db *sql.DB, dialect Dialect, config *Config, logger *Logger) (archiveCount, keepCount int64, dateColumn string, err error) {
 	// Detect the date column to use
-	dateColumn, err = detectDateColumn(db, config.Table)
+	dateColumn, err = detectDateColumn(ctx, db, dialect, config.Table)
 	if err != nil {
 		return 0, 0, "", err
 	}
@@ -225,15 +716,17 @@ func countRecords(db *sql.DB, config *Config, logger *Logger) (archiveCount, kee
 	logger.Info("Cutoff date: %s", cutoffDate.Format("2006-01-02"))
 
 	// Count records to archive (older than cutoff)
-	query := fmt.Sprintf("SELECT COUNT(*) FROM `%s` WHERE `%s` < ?", config.Table, dateColumn)
-	err = db.QueryRow(query, cutoffDate).Scan(&archiveCount)
+	hint := maxExecutionTimeHint(config.MaxExecutionTime)
+	table, column := dialect.QuoteIdentifier(config.Table), dialect.QuoteIdentifier(dateColumn)
+	query := dialect.Rebind(fmt.Sprintf("SELECT %sCOUNT(*) FROM %s WHERE %s < ?", hint, table, column))
+	err = db.QueryRowContext(ctx, query, dialect.TimeArg(cutoffDate)).Scan(&archiveCount)
 	if err != nil {
 		return 0, 0, "", err
 	}
 
 	// Count records to keep (newer than or equal to cutoff)
-	query = fmt.Sprintf("SELECT COUNT(*) FROM `%s` WHERE `%s` >= ?", config.Table, dateColumn)
-	err = db.QueryRow(query, cutoffDate).Scan(&keepCount)
+	query = dialect.Rebind(fmt.Sprintf("SELECT %sCOUNT(*) FROM %s WHERE %s >= ?", hint, table, column))
+	err = db.QueryRowContext(ctx, query, dialect.TimeArg(cutoffDate)).Scan(&keepCount)
 	if err != nil {
 		return 0, 0, "", err
 	}
@@ -241,26 +734,20 @@ func countRecords(db *sql.DB, config *Config, logger *Logger) (archiveCount, kee
 	return archiveCount, keepCount, dateColumn, nil
 }
 
-func detectDateColumn(db *sql.DB, tableName string) (string, error) {
+func detectDateColumn(ctx context.Context, db *sql.DB, dialect Dialect, tableName string) (string, error) {
 	// Priority order for date columns
 	dateColumns := []string{"smsdate", "request_time", "deli_date", "created_at", "updated_at", "req_date", "res_date", "date_created", "created"}
 
-	query := fmt.Sprintf("SHOW COLUMNS FROM `%s`", tableName)
-	rows, err := db.Query(query)
+	columns, err := dialect.Columns(ctx, db, tableName)
 	if err != nil {
 		return "", err
 	}
-	defer rows.Close()
 
 	availableColumns := make(map[string]bool)
-	for rows.Next() {
-		var field, colType string
-		var null, key, def, extra sql.NullString
-		if err := rows.Scan(&field, &colType, &null, &key, &def, &extra); err != nil {
-			return "", err
-		}
-		if strings.Contains(strings.ToLower(colType), "date") || strings.Contains(strings.ToLower(colType), "time") {
-			availableColumns[field] = true
+	for _, column := range columns {
+		colType := strings.ToLower(column.Type)
+		if strings.Contains(colType, "date") || strings.Contains(colType, "time") {
+			availableColumns[column.Name] = true
 		}
 	}
 
@@ -279,52 +766,41 @@ func detectDateColumn(db *sql.DB, tableName string) (string, error) {
 	return "", fmt.Errorf("no suitable date column found in table %s", tableName)
 }
 
-func modifyCreateStatement(createStmt, oldName, newName, suffix string) string {
-	// Replace table name
-	createStmt = strings.Replace(createStmt, fmt.Sprintf("CREATE TABLE `%s`", oldName), fmt.Sprintf("CREATE TABLE `%s`", newName), 1)
-
-	// Update index names with suffix (KEY, UNIQUE KEY, INDEX)
-	// re := regexp.MustCompile(`(KEY|INDEX|UNIQUE KEY)\s+` + "`" + `([^`]+)` + "`")
-	re := regexp.MustCompile("(KEY|INDEX|UNIQUE KEY)\\s+`([^`]+)`")
-	createStmt = re.ReplaceAllStringFunc(createStmt, func(match string) string {
-		// Extract the index name
-		parts := re.FindStringSubmatch(match)
-		if len(parts) >= 3 {
-			indexType := parts[1]
-			indexName := parts[2]
-
-			// Don't modify PRIMARY KEY
-			if strings.ToUpper(indexType) == "PRIMARY KEY" {
-				return match
-			}
-
-			newIndexName := fmt.Sprintf("%s_%s", indexName, suffix)
-			return fmt.Sprintf("%s `%s`", indexType, newIndexName)
-		}
-		return match
-	})
+// modifyCreateStatement rewrites a SHOW CREATE TABLE statement for newName,
+// appending suffix to index and constraint names. Foreign key and check
+// constraint names must be unique per schema, so they cannot be reused.
+func modifyCreateStatement(createStmt, oldName, newName, suffix string) (string, error) {
+	table, err := parseCreateTable(createStmt)
+	if err != nil {
+		return "", err
+	}
+	if table.Name != oldName {
+		return "", fmt.Errorf("statement creates %s, expected %s", table.Name, oldName)
+	}
 
-	// Update foreign key constraint names with suffix
-	// Match: CONSTRAINT `constraint_name` FOREIGN KEY
-	fkRe := regexp.MustCompile(`CONSTRAINT\s+` + "`" + `([^` + "`" + `]+)` + "`" + `\s+FOREIGN KEY`)
-	createStmt = fkRe.ReplaceAllStringFunc(createStmt, func(match string) string {
-		parts := fkRe.FindStringSubmatch(match)
-		if len(parts) >= 2 {
-			constraintName := parts[1]
-			newConstraintName := fmt.Sprintf("%s_%s", constraintName, suffix)
-			return fmt.Sprintf("CONSTRAINT `%s` FOREIGN KEY", newConstraintName)
+	table.Name = newName
+	for _, def := range table.Definitions {
+		switch def.Kind {
+		case IndexDefinition, UniqueIndexDefinition, FulltextIndexDefinition, SpatialIndexDefinition, ForeignKeyDefinition, CheckDefinition:
+			if def.Name != "" {
+				def.SetName(suffixedName(def.Name, suffix))
+			}
 		}
-		return match
-	})
+	}
 
-	return createStmt
+	return table.String(), nil
 }
 
-func copyOldRecords(db *sql.DB, sourceTable, destTable, dateColumn string, cutoffDate time.Time, logger *Logger) error {
-	query := fmt.Sprintf("INSERT INTO `%s` SELECT * FROM `%s` WHERE `%s` < ?", destTable, sourceTable, dateColumn)
+func copyOldRecords(ctx context.Context, db *sql.DB, dialect Dialect, sourceTable, destTable, dateColumn string, cutoffDate time.Time, chunkSize int, throttler *Throttler, logger *Logger) error {
+	if chunkSize > 0 {
+		return copyOldRecordsChunked(ctx, db, dialect, sourceTable, destTable, dateColumn, cutoffDate, chunkSize, throttler, logger)
+	}
+
+	query := dialect.Rebind(fmt.Sprintf("INSERT INTO %s SELECT * FROM %s WHERE %s < ?",
+		dialect.QuoteIdentifier(destTable), dialect.QuoteIdentifier(sourceTable), dialect.QuoteIdentifier(dateColumn)))
 	logger.Info("Executing: %s with cutoff %s", query, cutoffDate.Format("2006-01-02"))
 
-	result, err := db.Exec(query, cutoffDate)
+	result, err := execKillable(ctx, db, dialect, query, dialect.TimeArg(cutoffDate))
 	if err != nil {
 		return err
 	}
@@ -335,31 +811,96 @@ func copyOldRecords(db *sql.DB, sourceTable, destTable, dateColumn string, cutof
 	return nil
 }
 
-func deleteOldRecords(db *sql.DB, table, dateColumn string, cutoffDate time.Time, logger *Logger) error {
-	query := fmt.Sprintf("DELETE FROM `%s` WHERE `%s` < ?", table, dateColumn)
+// deleteOldRecords returns the number of rows deleted even on error, since a
+// chunked delete may fail after earlier chunks were committed.
+func deleteOldRecords(ctx context.Context, db *sql.DB, dialect Dialect, table, dateColumn string, cutoffDate time.Time, chunkSize int, throttler *Throttler, logger *Logger) (int64, error) {
+	if chunkSize > 0 {
+		return deleteOldRecordsChunked(ctx, db, dialect, table, dateColumn, cutoffDate, chunkSize, throttler, logger)
+	}
+
+	query := dialect.Rebind(fmt.Sprintf("DELETE FROM %s WHERE %s < ?", dialect.QuoteIdentifier(table), dialect.QuoteIdentifier(dateColumn)))
 	logger.Info("Executing: %s with cutoff %s", query, cutoffDate.Format("2006-01-02"))
 
-	result, err := db.Exec(query, cutoffDate)
+	result, err := execKillable(ctx, db, dialect, query, dialect.TimeArg(cutoffDate))
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	rowsAffected, _ := result.RowsAffected()
 	logger.Info("Deleted %d rows", rowsAffected)
 
-	return nil
+	return rowsAffected, nil
 }
 
-func getTableCount(db *sql.DB, tableName string) (int64, error) {
+func getTableCount(ctx context.Context, db *sql.DB, dialect Dialect, tableName string) (int64, error) {
 	var count int64
-	query := fmt.Sprintf("SELECT COUNT(*) FROM `%s`", tableName)
-	err := db.QueryRow(query).Scan(&count)
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %s", dialect.QuoteIdentifier(tableName))
+	err := db.QueryRowContext(ctx, query).Scan(&count)
 	return count, err
 }
 
-func executeSQL(db *sql.DB, query string, logger *Logger) error {
+func executeSQL(ctx context.Context, db *sql.DB, query string, logger *Logger) error {
 	logger.Info("Executing SQL: %s", truncateSQL(query, 200))
-	_, err := db.Exec(query)
+	_, err := db.ExecContext(ctx, query)
+	return err
+}
+
+// execKillable runs a long statement on a dedicated connection. If ctx is
+// cancelled the statement is stopped on the server (KILL QUERY on MySQL), so
+// the server rolls it back instead of running it to completion after the
+// client has gone away.
+func execKillable(ctx context.Context, db *sql.DB, dialect Dialect, query string, args ...any) (sql.Result, error) {
+	var result sql.Result
+	err := runKillable(ctx, db, dialect, func(runCtx context.Context, conn *sql.Conn) error {
+		var err error
+		result, err = conn.ExecContext(runCtx, query, args...)
+		return err
+	})
+	return result, err
+}
+
+// runKillable calls fn with a dedicated connection and stops the statement
+// it is running on the server when ctx is cancelled. fn must use runCtx,
+// which is only cancelled when the driver has to roll back by itself.
+func runKillable(ctx context.Context, db *sql.DB, dialect Dialect, fn func(runCtx context.Context, conn *sql.Conn) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	connectionID, err := dialect.ConnectionID(ctx, conn)
+	if errors.Is(err, errors.ErrUnsupported) {
+		// The driver rolls the statement back itself when ctx is cancelled
+		return fn(ctx, conn)
+	}
+	if err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	killed := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			dialect.CancelQuery(context.Background(), db, connectionID)
+			killed <- true
+		case <-done:
+			killed <- false
+		}
+	}()
+
+	err = fn(context.WithoutCancel(ctx), conn)
+	close(done)
+
+	if <-killed {
+		// Never return a connection that may still carry a pending kill to the pool
+		conn.Raw(func(any) error { return driver.ErrBadConn })
+		if err != nil {
+			return fmt.Errorf("%v (%v)", ctx.Err(), err)
+		}
+	}
+
 	return err
 }
 
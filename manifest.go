@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Manifest describes an archive table and its exports at the time they
+// were written, so both can be checked later with the verify command.
+type Manifest struct {
+	Driver    string         `json:"driver"`
+	Database  string         `json:"database"`
+	Table     string         `json:"table"`
+	Columns   []string       `json:"columns"`
+	Rows      int64          `json:"rows"`
+	Checksum  uint64         `json:"checksum"`
+	CreatedAt time.Time      `json:"created_at"`
+	Files     []ManifestFile `json:"files"`
+	CSV       *CSVOptions    `json:"csv,omitempty"`
+
+	// ExportColumns and Masks are set when the exports leave out or mask
+	// columns of the table
+	ExportColumns []string `json:"export_columns,omitempty"`
+	Masks         []string `json:"masks,omitempty"`
+}
+
+// ManifestFile is an export listed in a manifest. Name is relative to the
+// directory of the manifest.
+type ManifestFile struct {
+	Name   string `json:"name"`
+	Format string `json:"format"`
+	Rows   int64  `json:"rows"`
+	SHA256 string `json:"sha256"`
+}
+
+// writeManifest checksums table and records it together with the exported
+// files in a manifest next to them.
+func writeManifest(ctx context.Context, db *sql.DB, dialect Dialect, table string, files []ManifestFile, config *Config, logger *Logger) error {
+	columns, err := dialect.Columns(ctx, db, table)
+	if err != nil {
+		return fmt.Errorf("failed to get columns: %v", err)
+	}
+	rows, checksum, err := tableChecksum(ctx, db, dialect, table, columns)
+	if err != nil {
+		return fmt.Errorf("failed to checksum %s: %v", table, err)
+	}
+
+	manifest := Manifest{
+		Driver:    config.Driver,
+		Database:  config.Database,
+		Table:     table,
+		Rows:      rows,
+		Checksum:  checksum,
+		CreatedAt: time.Now().UTC(),
+	}
+	for _, column := range columns {
+		manifest.Columns = append(manifest.Columns, column.Name)
+	}
+	exportColumns, err := exportColumnsFor(table, columns, config)
+	if err != nil {
+		return err
+	}
+	if !exportColumns.All(columns) {
+		manifest.ExportColumns = exportColumns.Names
+		for _, mask := range exportColumns.Masks {
+			manifest.Masks = append(manifest.Masks, mask.String())
+		}
+	}
+	for _, file := range files {
+		file.SHA256, err = fileSHA256(filepath.Join(config.ExportPath, file.Name))
+		if err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, file)
+		if file.Format == "csv" {
+			manifest.CSV = &config.CSV
+		}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	filename := fmt.Sprintf("%s/%s_%s.manifest.json", config.ExportPath, table, time.Now().Format("20060102_150405"))
+	if err := os.WriteFile(filename, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write manifest: %v", err)
+	}
+
+	logger.Info("Wrote manifest %s (%d rows, checksum %d)", filename, rows, checksum)
+	return nil
+}
+
+func readManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %v", path, err)
+	}
+	return &manifest, nil
+}
+
+// tableChecksum returns the row count of table and the sum of the CRC32
+// checksums of its rows, as computed by checksumRows.
+func tableChecksum(ctx context.Context, db *sql.DB, dialect Dialect, table string, columns []Column) (int64, uint64, error) {
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = dialect.QuoteIdentifier(column.Name)
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(quoted, ", "), dialect.QuoteIdentifier(table))
+	return checksumRows(ctx, db, dialect, query)
+}
+
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
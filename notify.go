@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	StatusSuccess          = "success"
+	StatusFailure          = "failure"
+	StatusNothingToArchive = "nothing_to_archive"
+	StatusDryRun           = "dry_run"
+)
+
+// defaultWebhookTemplate produces a payload accepted by Slack and Teams incoming webhooks.
+const defaultWebhookTemplate = `{"text": {{json .Summary}}}`
+
+// Notification is the data made available to webhook payload templates.
+type Notification struct {
+	Status       string
+	Host         string
+	Database     string
+	Table        string
+	ArchiveTable string
+	ArchiveCount int64
+	KeepCount    int64
+	Step         string
+	Interrupted  bool
+	RolledBack   bool
+	DryRun       bool
+	StartedAt    time.Time
+	Duration     time.Duration
+	Error        string
+}
+
+func newNotification(config *Config, result *ArchiveResult, runErr error, startedAt time.Time) *Notification {
+	n := &Notification{
+		Status:    StatusSuccess,
+		Host:      config.Host,
+		Database:  config.Database,
+		Table:     config.Table,
+		DryRun:    config.DryRun,
+		StartedAt: startedAt,
+		Duration:  time.Since(startedAt).Round(time.Millisecond),
+	}
+
+	if result != nil {
+		n.ArchiveTable = result.ArchiveTable
+		n.ArchiveCount = result.ArchiveCount
+		n.KeepCount = result.KeepCount
+		n.Step = result.Step
+		n.Interrupted = result.Interrupted
+		n.RolledBack = result.RolledBack
+	}
+
+	switch {
+	case runErr != nil:
+		n.Status = StatusFailure
+		n.Error = runErr.Error()
+	case result == nil || result.ArchiveCount == 0:
+		n.Status = StatusNothingToArchive
+	case config.DryRun:
+		n.Status = StatusDryRun
+	}
+
+	return n
+}
+
+// Summary returns a one-line human readable description of the run.
+func (n *Notification) Summary() string {
+	prefix := fmt.Sprintf("db-archiving %s.%s", n.Database, n.Table)
+	if n.DryRun {
+		prefix += " (dry run)"
+	}
+
+	switch n.Status {
+	case StatusFailure:
+		verb := "failed"
+		if n.Interrupted {
+			verb = "was interrupted"
+		}
+		summary := fmt.Sprintf("%s %s", prefix, verb)
+		if n.Step != "" {
+			summary += fmt.Sprintf(" during %s step", n.Step)
+		}
+		if n.RolledBack {
+			summary += " and was rolled back"
+		}
+		return fmt.Sprintf("%s after %s: %s", summary, n.Duration, n.Error)
+	case StatusNothingToArchive:
+		return fmt.Sprintf("%s found nothing to archive (%d records kept, %s)", prefix, n.KeepCount, n.Duration)
+	case StatusDryRun:
+		return fmt.Sprintf("%s would archive %d records to %s, %d records kept (%s)", prefix, n.ArchiveCount, n.ArchiveTable, n.KeepCount, n.Duration)
+	default:
+		return fmt.Sprintf("%s archived %d records to %s, %d records kept (%s)", prefix, n.ArchiveCount, n.ArchiveTable, n.KeepCount, n.Duration)
+	}
+}
+
+func shouldNotify(config *Config, status string) bool {
+	if config.WebhookURL == "" {
+		return false
+	}
+	for _, s := range strings.Split(config.WebhookOn, ",") {
+		s = strings.TrimSpace(s)
+		if s == "all" || s == status {
+			return true
+		}
+	}
+	return false
+}
+
+func sendNotification(config *Config, n *Notification, logger *Logger) {
+	if !shouldNotify(config, n.Status) {
+		return
+	}
+
+	payload, err := renderWebhookPayload(config.WebhookTemplate, n)
+	if err != nil {
+		logger.Error("Failed to render webhook payload: %v", err)
+		return
+	}
+
+	if err := postWebhook(config, payload); err != nil {
+		logger.Error("Failed to send webhook notification: %v", err)
+		return
+	}
+
+	logger.Info("Webhook notification sent (%s)", n.Status)
+}
+
+func renderWebhookPayload(templatePath string, n *Notification) ([]byte, error) {
+	text := defaultWebhookTemplate
+	if templatePath != "" {
+		content, err := os.ReadFile(templatePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read webhook template: %v", err)
+		}
+		text = string(content)
+	}
+
+	tmpl, err := template.New("webhook").Funcs(template.FuncMap{
+		"json": jsonValue,
+	}).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse webhook template: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, n); err != nil {
+		return nil, fmt.Errorf("failed to execute webhook template: %v", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// jsonValue encodes v as a JSON literal so templates can embed arbitrary text safely.
+func jsonValue(v any) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func postWebhook(config *Config, payload []byte) error {
+	client := &http.Client{Timeout: config.WebhookTimeout}
+
+	req, err := http.NewRequest(http.MethodPost, config.WebhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", config.WebhookContentType)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
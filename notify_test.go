@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// webhookServer records the requests it receives and answers them with status.
+func webhookServer(t *testing.T, status int, delay time.Duration) (*httptest.Server, chan []byte) {
+	t.Helper()
+
+	bodies := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Content-Type"); got != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", got)
+		}
+		body, _ := io.ReadAll(r.Body)
+		bodies <- body
+		time.Sleep(delay)
+		w.WriteHeader(status)
+		io.WriteString(w, http.StatusText(status))
+	}))
+	t.Cleanup(server.Close)
+	return server, bodies
+}
+
+func webhookConfig(url string) *Config {
+	return &Config{
+		Host:               "db1",
+		Database:           "app",
+		Table:              "sms_log",
+		WebhookURL:         url,
+		WebhookOn:          "all",
+		WebhookTimeout:     time.Second,
+		WebhookContentType: "application/json",
+	}
+}
+
+func bufferLogger() (*Logger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	return &Logger{Logger: log.New(&buf, "", 0)}, &buf
+}
+
+func TestSendNotificationPayload(t *testing.T) {
+	server, bodies := webhookServer(t, http.StatusOK, 0)
+	config := webhookConfig(server.URL)
+	config.WebhookTemplate = filepath.Join(t.TempDir(), "webhook.tmpl")
+	tmpl := `{"status": {{json .Status}}, "table": {{json .ArchiveTable}}, "archived": {{.ArchiveCount}}, "kept": {{.KeepCount}}, "error": {{json .Error}}}`
+	if err := os.WriteFile(config.WebhookTemplate, []byte(tmpl), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		result *ArchiveResult
+		err    error
+		want   map[string]any
+	}{
+		{
+			"success",
+			&ArchiveResult{ArchiveTable: "sms_log_archive", ArchiveCount: 3, KeepCount: 2},
+			nil,
+			map[string]any{"status": StatusSuccess, "table": "sms_log_archive", "archived": 3.0, "kept": 2.0, "error": ""},
+		},
+		{
+			"failure",
+			&ArchiveResult{ArchiveTable: "sms_log_archive", Step: "copy"},
+			errors.New("copy failed"),
+			map[string]any{"status": StatusFailure, "table": "sms_log_archive", "archived": 0.0, "kept": 0.0, "error": "copy failed"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, logs := bufferLogger()
+			sendNotification(config, newNotification(config, tt.result, tt.err, time.Now()), logger)
+
+			var got map[string]any
+			if err := json.Unmarshal(<-bodies, &got); err != nil {
+				t.Fatalf("payload is not JSON: %v", err)
+			}
+			for key, want := range tt.want {
+				if got[key] != want {
+					t.Errorf("payload %s = %v, want %v", key, got[key], want)
+				}
+			}
+			if !strings.Contains(logs.String(), "Webhook notification sent") {
+				t.Errorf("success not logged: %s", logs)
+			}
+		})
+	}
+}
+
+func TestSendNotificationDefaultTemplate(t *testing.T) {
+	server, bodies := webhookServer(t, http.StatusOK, 0)
+	config := webhookConfig(server.URL)
+	config.DryRun = true
+
+	result := &ArchiveResult{ArchiveTable: "sms_log_archive", ArchiveCount: 3, KeepCount: 2}
+	sendNotification(config, newNotification(config, result, nil, time.Now()), testLogger())
+
+	var payload struct{ Text string }
+	if err := json.Unmarshal(<-bodies, &payload); err != nil {
+		t.Fatalf("payload is not JSON: %v", err)
+	}
+	if !strings.Contains(payload.Text, "app.sms_log (dry run) would archive 3 records to sms_log_archive") {
+		t.Errorf("dry run summary is %q", payload.Text)
+	}
+}
+
+func TestSendNotificationErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		delay  time.Duration
+		want   string
+	}{
+		{"non-2xx", http.StatusInternalServerError, 0, "webhook returned 500"},
+		{"timeout", http.StatusOK, 500 * time.Millisecond, "Client.Timeout"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, bodies := webhookServer(t, tt.status, tt.delay)
+			config := webhookConfig(server.URL)
+			config.WebhookTimeout = 100 * time.Millisecond
+
+			// sendNotification only logs, so a failing webhook never fails the run
+			logger, logs := bufferLogger()
+			sendNotification(config, newNotification(config, &ArchiveResult{ArchiveCount: 1}, nil, time.Now()), logger)
+			<-bodies
+			if !strings.Contains(logs.String(), "[ERROR] Failed to send webhook notification") || !strings.Contains(logs.String(), tt.want) {
+				t.Errorf("error not logged, got: %s", logs)
+			}
+		})
+	}
+}
+
+func TestShouldNotify(t *testing.T) {
+	config := webhookConfig("http://example.invalid")
+	config.WebhookOn = "failure, dry_run"
+	if !shouldNotify(config, StatusFailure) || !shouldNotify(config, StatusDryRun) || shouldNotify(config, StatusSuccess) {
+		t.Error("shouldNotify does not follow -webhook-on")
+	}
+	config.WebhookURL = ""
+	if shouldNotify(config, StatusFailure) {
+		t.Error("shouldNotify is true without a webhook URL")
+	}
+}
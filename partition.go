@@ -0,0 +1,468 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	PartitionModeOff      = "off"
+	PartitionModeExchange = "exchange"
+	PartitionModeDrop     = "drop"
+)
+
+// partition is one partition of a RANGE partitioned table. Description is
+// the VALUES LESS THAN bound as reported by INFORMATION_SCHEMA.PARTITIONS.
+type partition struct {
+	Name        string
+	Description string
+}
+
+// partitionScheme describes how a table is partitioned.
+type partitionScheme struct {
+	Method     string
+	Expression string
+	Partitions []partition
+}
+
+func (s *partitionScheme) columns() bool {
+	return s.Method == "RANGE COLUMNS"
+}
+
+// archivePartitions archives a RANGE partitioned table by moving every
+// partition that lies entirely before the cutoff out of the table with
+// EXCHANGE PARTITION, instead of copying and deleting rows.
+func archivePartitions(ctx context.Context, db *sql.DB, dialect Dialect, config *Config, createStmt string, result *ArchiveResult, logger *Logger) error {
+	switch config.PartitionMode {
+	case PartitionModeExchange, PartitionModeDrop:
+	default:
+		return fmt.Errorf("invalid -partition-mode %q (want off, exchange or drop)", config.PartitionMode)
+	}
+	if config.PartitionMode == PartitionModeDrop && !config.ExportSQL && !config.ExportCSV && !config.ExportLoadData {
+		return fmt.Errorf("-partition-mode=drop requires -export-sql, -export-csv or -export-load-data")
+	}
+
+	table, err := parseCreateTable(createStmt)
+	if err != nil {
+		return fmt.Errorf("failed to parse CREATE TABLE: %v", err)
+	}
+	if table.Partition == "" {
+		return fmt.Errorf("table %s is not partitioned", config.Table)
+	}
+
+	dateColumn, err := detectDateColumn(ctx, db, dialect, config.Table)
+	if err != nil {
+		return err
+	}
+	logger.Info("Using date column: %s", dateColumn)
+
+	scheme, err := getPartitionScheme(ctx, db, config.Table, dateColumn)
+	if err != nil {
+		return err
+	}
+	logger.Info("Table is partitioned by %s (%s) into %d partitions", scheme.Method, scheme.Expression, len(scheme.Partitions))
+
+	// Step 2: Find the partitions entirely older than the cutoff
+	if err := beginStep(ctx, result, "count"); err != nil {
+		return err
+	}
+	logger.Info("Step 2: Finding partitions older than the cutoff")
+	cutoffDate := time.Now().AddDate(0, 0, -config.DaysToKeep)
+	expired, err := expiredPartitions(ctx, db, scheme, dateColumn, cutoffDate)
+	if err != nil {
+		return fmt.Errorf("failed to evaluate partition bounds: %v", err)
+	}
+	for _, p := range expired {
+		logger.Info("Partition %s (values less than %s) is older than %s", p.Name, p.Description, cutoffDate.Format("2006-01-02"))
+	}
+	if len(expired) == 0 {
+		logger.Warning("No partitions entirely older than %s", cutoffDate.Format("2006-01-02"))
+	}
+
+	if config.DryRun {
+		logger.Info("DRY RUN MODE - No changes will be made")
+		for _, p := range expired {
+			if config.PartitionMode == PartitionModeDrop {
+				logger.Info("Would export and drop partition %s", p.Name)
+			} else {
+				logger.Info("Would exchange partition %s into %s", p.Name, partitionArchiveTable(config.Table, p.Name))
+			}
+		}
+		return addFuturePartitions(ctx, db, dialect, config, scheme, dateColumn, logger)
+	}
+
+	// Step 3: Move each expired partition out of the table
+	var archiveTables []string
+	for i, p := range expired {
+		if err := beginStep(ctx, result, "exchange"); err != nil {
+			return err
+		}
+		archiveTable := partitionArchiveTable(config.Table, p.Name)
+		logger.Info("Step 3: Exchanging partition %s into %s", p.Name, archiveTable)
+
+		// MySQL refuses to drop the last remaining partition
+		last := i == len(scheme.Partitions)-1
+		count, err := exchangePartition(ctx, db, dialect, config.Table, p.Name, archiveTable, !last, logger)
+		if err != nil {
+			return err
+		}
+		logger.Info("Moved %d records from partition %s to %s", count, p.Name, archiveTable)
+		result.ArchiveCount += count
+		archiveTables = append(archiveTables, archiveTable)
+	}
+	result.ArchiveTable = strings.Join(archiveTables, ",")
+
+	// Step 4: Export the archive tables, dropping them in drop mode
+	if config.ExportSQL || config.ExportCSV || config.ExportLoadData {
+		for _, archiveTable := range archiveTables {
+			if err := beginStep(ctx, result, "export"); err != nil {
+				return fmt.Errorf("partitions archived but exports were skipped: %v", err)
+			}
+			logger.Info("Step 4: Exporting %s", archiveTable)
+			if !exportArchiveTable(ctx, db, dialect, archiveTable, config, logger) {
+				if config.PartitionMode == PartitionModeDrop {
+					return fmt.Errorf("export of %s failed; keeping the table", archiveTable)
+				}
+				continue
+			}
+			if config.PartitionMode == PartitionModeDrop {
+				dropSQL := fmt.Sprintf("DROP TABLE %s", dialect.QuoteIdentifier(archiveTable))
+				if err := executeSQL(context.WithoutCancel(ctx), db, dropSQL, logger); err != nil {
+					return fmt.Errorf("failed to drop exported table %s: %v", archiveTable, err)
+				}
+			}
+		}
+	}
+
+	// Step 5: Make room for future rows
+	if err := beginStep(ctx, result, "add partitions"); err != nil {
+		return err
+	}
+	return addFuturePartitions(ctx, db, dialect, config, scheme, dateColumn, logger)
+}
+
+// exportArchiveTable runs the requested exports of table, writes their
+// manifest and reports whether all of them succeeded.
+func exportArchiveTable(ctx context.Context, db *sql.DB, dialect Dialect, table string, config *Config, logger *Logger) bool {
+	ok := true
+	var exported []ManifestFile
+	if config.ExportSQL {
+		exportCtx, cancel := stepContext(ctx, config.ExportTimeout)
+		files, err := exportTableToSQL(exportCtx, db, dialect, table, config, logger)
+		cancel()
+		if err != nil {
+			logger.Error("Failed to export SQL: %v", err)
+			ok = false
+		} else {
+			exported = append(exported, files...)
+		}
+	}
+	if config.ExportCSV {
+		exportCtx, cancel := stepContext(ctx, config.ExportTimeout)
+		files, err := exportTableToCSV(exportCtx, db, dialect, table, config, logger)
+		cancel()
+		if err != nil {
+			logger.Error("Failed to export CSV: %v", err)
+			ok = false
+		} else {
+			exported = append(exported, files...)
+		}
+	}
+	if config.ExportLoadData {
+		exportCtx, cancel := stepContext(ctx, config.ExportTimeout)
+		files, err := exportTableToLoadData(exportCtx, db, dialect, table, config, logger)
+		cancel()
+		if err != nil {
+			logger.Error("Failed to export TSV: %v", err)
+			ok = false
+		} else {
+			exported = append(exported, files...)
+		}
+	}
+	if len(exported) > 0 {
+		exportCtx, cancel := stepContext(ctx, config.ExportTimeout)
+		err := writeManifest(exportCtx, db, dialect, table, exported, config, logger)
+		cancel()
+		if err != nil {
+			logger.Error("Failed to write manifest: %v", err)
+			ok = false
+		}
+	}
+	return ok
+}
+
+func partitionArchiveTable(table, partitionName string) string {
+	return suffixedName(table, "archive_"+partitionName)
+}
+
+// exchangeStatements returns the statements that create archiveTable as an
+// empty, non-partitioned copy of table and swap partitionName into it.
+func exchangeStatements(dialect Dialect, table, partitionName, archiveTable string) []string {
+	return []string{
+		fmt.Sprintf("CREATE TABLE %s LIKE %s", dialect.QuoteIdentifier(archiveTable), dialect.QuoteIdentifier(table)),
+		fmt.Sprintf("ALTER TABLE %s REMOVE PARTITIONING", dialect.QuoteIdentifier(archiveTable)),
+		fmt.Sprintf("ALTER TABLE %s EXCHANGE PARTITION %s WITH TABLE %s",
+			dialect.QuoteIdentifier(table), dialect.QuoteIdentifier(partitionName), dialect.QuoteIdentifier(archiveTable)),
+	}
+}
+
+// exchangePartition swaps the rows of partitionName into a new, empty,
+// non-partitioned copy of table and optionally drops the emptied partition.
+// It returns the number of rows moved.
+func exchangePartition(ctx context.Context, db *sql.DB, dialect Dialect, table, partitionName, archiveTable string, dropPartition bool, logger *Logger) (int64, error) {
+	statements := exchangeStatements(dialect, table, partitionName, archiveTable)
+	if err := executeSQL(ctx, db, statements[0], logger); err != nil {
+		return 0, fmt.Errorf("failed to create %s: %v", archiveTable, err)
+	}
+
+	// Once created, the exchange must not be abandoned halfway
+	ctx = context.WithoutCancel(ctx)
+
+	for _, query := range statements[1:] {
+		if err := executeSQL(ctx, db, query, logger); err != nil {
+			logger.Warning("Dropping %s after failed exchange", archiveTable)
+			if dropErr := executeSQL(ctx, db, fmt.Sprintf("DROP TABLE IF EXISTS %s", dialect.QuoteIdentifier(archiveTable)), logger); dropErr != nil {
+				logger.Error("Failed to drop %s: %v", archiveTable, dropErr)
+			}
+			return 0, fmt.Errorf("failed to exchange partition %s: %v", partitionName, err)
+		}
+	}
+
+	if dropPartition {
+		dropSQL := fmt.Sprintf("ALTER TABLE %s DROP PARTITION %s", dialect.QuoteIdentifier(table), dialect.QuoteIdentifier(partitionName))
+		if err := executeSQL(ctx, db, dropSQL, logger); err != nil {
+			return 0, fmt.Errorf("partition %s was exchanged into %s but could not be dropped: %v", partitionName, archiveTable, err)
+		}
+	} else {
+		logger.Warning("Keeping empty partition %s, the last partition of %s", partitionName, table)
+	}
+
+	return getTableCount(ctx, db, dialect, archiveTable)
+}
+
+func getPartitionScheme(ctx context.Context, db *sql.DB, table, dateColumn string) (*partitionScheme, error) {
+	query := `SELECT PARTITION_NAME, PARTITION_METHOD, PARTITION_EXPRESSION, PARTITION_DESCRIPTION, SUBPARTITION_METHOD
+		FROM INFORMATION_SCHEMA.PARTITIONS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?
+		ORDER BY PARTITION_ORDINAL_POSITION`
+	rows, err := db.QueryContext(ctx, query, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read partitions: %v", err)
+	}
+	defer rows.Close()
+
+	scheme := &partitionScheme{}
+	for rows.Next() {
+		var name, method, expression, description, subpartitionMethod sql.NullString
+		if err := rows.Scan(&name, &method, &expression, &description, &subpartitionMethod); err != nil {
+			return nil, err
+		}
+		if subpartitionMethod.Valid {
+			return nil, fmt.Errorf("subpartitioned tables are not supported")
+		}
+		scheme.Method = method.String
+		scheme.Expression = expression.String
+		scheme.Partitions = append(scheme.Partitions, partition{Name: name.String, Description: description.String})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case scheme.Method != "RANGE" && scheme.Method != "RANGE COLUMNS":
+		return nil, fmt.Errorf("partition method %s is not supported, only RANGE and RANGE COLUMNS", scheme.Method)
+	case scheme.columns() && strings.Contains(scheme.Expression, ","):
+		return nil, fmt.Errorf("RANGE COLUMNS on several columns is not supported")
+	case !strings.Contains(strings.ToLower(scheme.Expression), strings.ToLower(dateColumn)):
+		return nil, fmt.Errorf("partition expression %s does not use date column %s", scheme.Expression, dateColumn)
+	}
+
+	return scheme, nil
+}
+
+// expiredPartitions returns the leading partitions whose upper bound is at
+// or below the partition value of cutoffDate, so every row they can hold is
+// older than the cutoff.
+func expiredPartitions(ctx context.Context, db *sql.DB, scheme *partitionScheme, dateColumn string, cutoffDate time.Time) ([]partition, error) {
+	cutoffValue, err := partitionValue(ctx, db, scheme, dateColumn, cutoffDate)
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []partition
+	for _, p := range scheme.Partitions {
+		if p.Description == "MAXVALUE" {
+			break
+		}
+		below, err := boundLess(ctx, db, scheme, cutoffValue, p.Description)
+		if err != nil {
+			return nil, err
+		}
+		if below {
+			break
+		}
+		expired = append(expired, p)
+	}
+	return expired, nil
+}
+
+// partitionValue returns the SQL literal the partitioning expression yields
+// for a row dated t. The expression is evaluated by the server against a
+// one-row derived table whose only column has the date column's name.
+func partitionValue(ctx context.Context, db *sql.DB, scheme *partitionScheme, dateColumn string, t time.Time) (string, error) {
+	layout := "2006-01-02 15:04:05"
+	if t.Equal(truncateDay(t)) {
+		layout = "2006-01-02"
+	}
+	if scheme.columns() {
+		return "'" + t.Format(layout) + "'", nil
+	}
+
+	query := fmt.Sprintf("SELECT CAST((%s) AS CHAR) FROM (SELECT ? AS %s) AS cutoff", scheme.Expression, quoteIdentifier(dateColumn))
+	var value sql.NullString
+	if err := db.QueryRowContext(ctx, query, t.Format(layout)).Scan(&value); err != nil {
+		return "", err
+	}
+	if !value.Valid {
+		return "", fmt.Errorf("partition expression %s is NULL for %s", scheme.Expression, t.Format(layout))
+	}
+	return value.String, nil
+}
+
+// boundLess reports whether partition bound a sorts before bound b.
+func boundLess(ctx context.Context, db *sql.DB, scheme *partitionScheme, a, b string) (bool, error) {
+	query := fmt.Sprintf("SELECT (%s) < (%s)", a, b)
+	if scheme.columns() {
+		query = fmt.Sprintf("SELECT CAST(%s AS DATETIME) < CAST(%s AS DATETIME)", a, b)
+	}
+	var less bool
+	err := db.QueryRowContext(ctx, query).Scan(&less)
+	return less, err
+}
+
+// addFuturePartitions makes sure partitions exist for the current interval
+// and the -partition-ahead intervals after it, splitting a trailing MAXVALUE
+// partition if there is one.
+func addFuturePartitions(ctx context.Context, db *sql.DB, dialect Dialect, config *Config, scheme *partitionScheme, dateColumn string, logger *Logger) error {
+	if config.PartitionAhead <= 0 {
+		return nil
+	}
+
+	var maxPartition, lastBound string
+	existing := map[string]bool{}
+	for _, p := range scheme.Partitions {
+		existing[strings.ToLower(p.Name)] = true
+		if p.Description == "MAXVALUE" {
+			maxPartition = p.Name
+		} else {
+			lastBound = p.Description
+		}
+	}
+
+	var definitions []string
+	start, err := intervalStart(time.Now(), config.PartitionInterval)
+	if err != nil {
+		return err
+	}
+	for i := 0; i <= config.PartitionAhead; i++ {
+		end, _ := nextInterval(start, config.PartitionInterval)
+		bound, err := partitionValue(ctx, db, scheme, dateColumn, end)
+		if err != nil {
+			return fmt.Errorf("failed to compute partition bound: %v", err)
+		}
+
+		covered := false
+		if lastBound != "" {
+			less, err := boundLess(ctx, db, scheme, lastBound, bound)
+			if err != nil {
+				return fmt.Errorf("failed to compare partition bounds: %v", err)
+			}
+			covered = !less
+		}
+
+		name := partitionName(start, config.PartitionInterval)
+		if !covered {
+			if existing[name] {
+				return fmt.Errorf("partition %s already exists with a lower bound", name)
+			}
+			definitions = append(definitions, fmt.Sprintf("PARTITION %s VALUES LESS THAN (%s)", dialect.QuoteIdentifier(name), bound))
+			lastBound = bound
+		}
+		start = end
+	}
+
+	if len(definitions) == 0 {
+		logger.Info("Partitions already exist for the next %d intervals", config.PartitionAhead)
+		return nil
+	}
+
+	query := addPartitionsStatement(dialect, config.Table, maxPartition, definitions)
+	if config.DryRun {
+		logger.Info("Would add future partitions: %s", query)
+		return nil
+	}
+	logger.Info("Adding %d future partitions", len(definitions))
+	if err := executeSQL(ctx, db, query, logger); err != nil {
+		return fmt.Errorf("failed to add future partitions: %v", err)
+	}
+	return nil
+}
+
+// addPartitionsStatement adds the partitions in definitions to table, by
+// splitting maxPartition if the table ends in a MAXVALUE partition.
+func addPartitionsStatement(dialect Dialect, table, maxPartition string, definitions []string) string {
+	if maxPartition == "" {
+		return fmt.Sprintf("ALTER TABLE %s ADD PARTITION (%s)", dialect.QuoteIdentifier(table), strings.Join(definitions, ", "))
+	}
+	definitions = append(definitions[:len(definitions):len(definitions)], fmt.Sprintf("PARTITION %s VALUES LESS THAN MAXVALUE", dialect.QuoteIdentifier(maxPartition)))
+	return fmt.Sprintf("ALTER TABLE %s REORGANIZE PARTITION %s INTO (%s)",
+		dialect.QuoteIdentifier(table), dialect.QuoteIdentifier(maxPartition), strings.Join(definitions, ", "))
+}
+
+func truncateDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// intervalStart returns the start of the -partition-interval period holding t.
+func intervalStart(t time.Time, interval string) (time.Time, error) {
+	day := truncateDay(t)
+	switch interval {
+	case "day":
+		return day, nil
+	case "week":
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7), nil
+	case "month":
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location()), nil
+	case "year":
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, t.Location()), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid -partition-interval %q (want day, week, month or year)", interval)
+}
+
+func nextInterval(start time.Time, interval string) (time.Time, error) {
+	switch interval {
+	case "day":
+		return start.AddDate(0, 0, 1), nil
+	case "week":
+		return start.AddDate(0, 0, 7), nil
+	case "month":
+		return start.AddDate(0, 1, 0), nil
+	case "year":
+		return start.AddDate(1, 0, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid -partition-interval %q (want day, week, month or year)", interval)
+}
+
+// partitionName names a partition after the period it holds, e.g. p202401.
+func partitionName(start time.Time, interval string) string {
+	switch interval {
+	case "month":
+		return "p" + start.Format("200601")
+	case "year":
+		return "p" + start.Format("2006")
+	}
+	return "p" + start.Format("20060102")
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestIntervalStart(t *testing.T) {
+	// A Wednesday afternoon
+	at := time.Date(2024, time.February, 14, 15, 4, 5, 6, time.UTC)
+	tests := []struct {
+		interval string
+		want     time.Time
+	}{
+		{"day", time.Date(2024, time.February, 14, 0, 0, 0, 0, time.UTC)},
+		{"week", time.Date(2024, time.February, 12, 0, 0, 0, 0, time.UTC)},
+		{"month", time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{"year", time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := intervalStart(at, tt.interval)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("intervalStart(%s) = %s, %v, want %s", tt.interval, got, err, tt.want)
+		}
+	}
+
+	// Weeks start on Monday, so a Sunday belongs to the week before
+	sunday := time.Date(2024, time.February, 18, 23, 0, 0, 0, time.UTC)
+	if got, _ := intervalStart(sunday, "week"); !got.Equal(time.Date(2024, time.February, 12, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("week of Sunday starts %s", got)
+	}
+	monday := time.Date(2024, time.February, 12, 0, 0, 0, 0, time.UTC)
+	if got, _ := intervalStart(monday, "week"); !got.Equal(monday) {
+		t.Errorf("week of Monday starts %s", got)
+	}
+
+	if _, err := intervalStart(at, "quarter"); err == nil {
+		t.Error("intervalStart accepted quarter")
+	}
+}
+
+func TestNextInterval(t *testing.T) {
+	start := time.Date(2024, time.January, 31, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		start    time.Time
+		interval string
+		want     time.Time
+	}{
+		{time.Date(2024, time.February, 28, 0, 0, 0, 0, time.UTC), "day", time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2024, time.December, 30, 0, 0, 0, 0, time.UTC), "week", time.Date(2025, time.January, 6, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2024, time.December, 1, 0, 0, 0, 0, time.UTC), "month", time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC), "year", time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := nextInterval(tt.start, tt.interval)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("nextInterval(%s, %s) = %s, %v, want %s", tt.start, tt.interval, got, err, tt.want)
+		}
+	}
+
+	if _, err := nextInterval(start, "hour"); err == nil {
+		t.Error("nextInterval accepted hour")
+	}
+}
+
+func TestPartitionName(t *testing.T) {
+	start := time.Date(2024, time.March, 4, 0, 0, 0, 0, time.UTC)
+	tests := []struct{ interval, want string }{
+		{"day", "p20240304"},
+		{"week", "p20240304"},
+		{"month", "p202403"},
+		{"year", "p2024"},
+	}
+	for _, tt := range tests {
+		if got := partitionName(start, tt.interval); got != tt.want {
+			t.Errorf("partitionName(%s) = %s, want %s", tt.interval, got, tt.want)
+		}
+	}
+}
+
+func TestAddPartitionsStatement(t *testing.T) {
+	dialect := &MySQLDialect{}
+	definitions := []string{
+		"PARTITION `p202403` VALUES LESS THAN (739342)",
+		"PARTITION `p202404` VALUES LESS THAN (739372)",
+	}
+
+	got := addPartitionsStatement(dialect, "sms_log", "", definitions)
+	want := "ALTER TABLE `sms_log` ADD PARTITION (PARTITION `p202403` VALUES LESS THAN (739342), PARTITION `p202404` VALUES LESS THAN (739372))"
+	if got != want {
+		t.Errorf("ADD PARTITION statement:\n got %s\nwant %s", got, want)
+	}
+
+	got = addPartitionsStatement(dialect, "sms_log", "pmax", definitions)
+	want = "ALTER TABLE `sms_log` REORGANIZE PARTITION `pmax` INTO (" +
+		"PARTITION `p202403` VALUES LESS THAN (739342), PARTITION `p202404` VALUES LESS THAN (739372), " +
+		"PARTITION `pmax` VALUES LESS THAN MAXVALUE)"
+	if got != want {
+		t.Errorf("REORGANIZE statement:\n got %s\nwant %s", got, want)
+	}
+	if len(definitions) != 2 {
+		t.Errorf("definitions changed to %v", definitions)
+	}
+}
+
+func TestExchangeStatements(t *testing.T) {
+	got := exchangeStatements(&MySQLDialect{}, "sms_log", "p202401", "sms_log_archive_p202401")
+	want := []string{
+		"CREATE TABLE `sms_log_archive_p202401` LIKE `sms_log`",
+		"ALTER TABLE `sms_log_archive_p202401` REMOVE PARTITIONING",
+		"ALTER TABLE `sms_log` EXCHANGE PARTITION `p202401` WITH TABLE `sms_log_archive_p202401`",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("exchange statements:\n got %q\nwant %q", got, want)
+	}
+}
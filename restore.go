@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/go-sql-driver/mysql"
+)
+
+// loadDataPrefix starts the statements of a .load.sql file that read a
+// data file from the client.
+const loadDataPrefix = "LOAD DATA LOCAL INFILE "
+
+// runRestore implements the restore command, which runs a .load.sql file
+// or SQL export against a MySQL database, and returns the exit code.
+func runRestore(args []string) int {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	config := &Config{}
+	connectionFlags(fs, config)
+	fs.StringVar(&config.IdentityFile, "identity-file", "", "File with the age private key(s) to read encrypted (.age) exports with")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of %s restore [flags] FILE.load.sql:\n", os.Args[0])
+		fs.PrintDefaults()
+		fmt.Fprint(fs.Output(), credentialHelp)
+	}
+	fs.Parse(args)
+
+	if config.Database == "" || fs.NArg() != 1 {
+		fmt.Println("Error: the database flag and a file to restore are required")
+		fs.Usage()
+		return 1
+	}
+	if err := resolveConnectionFlags(fs, config); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	if config.Driver != DriverMySQL {
+		fmt.Println("Error: restore needs -driver=mysql, since LOAD DATA is MySQL syntax")
+		return 1
+	}
+
+	var identities []age.Identity
+	if config.IdentityFile != "" {
+		var err error
+		if identities, err = readIdentities(config.IdentityFile); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return 1
+		}
+	}
+
+	logger := NewLogger()
+	ctx := interruptContext(logger)
+	dialect, err := newDialect(config.Driver)
+	if err != nil {
+		logger.Error("%v", err)
+		return 1
+	}
+	db, err := connectDB(ctx, dialect, config, logger)
+	if err != nil {
+		logger.Error("Failed to connect to database: %v", err)
+		return 1
+	}
+	defer db.Close()
+
+	if err := restoreFile(ctx, db, fs.Arg(0), identities, logger); err != nil {
+		logger.Error("Restore failed: %v", err)
+		return 1
+	}
+	return 0
+}
+
+// restoreFile runs the statements of path on one connection, so the session
+// settings at its start apply to all of them. The data files of LOAD DATA
+// LOCAL INFILE statements are read relative to path and streamed to the
+// server through the driver, decrypting them if they are encrypted.
+func restoreFile(ctx context.Context, db *sql.DB, path string, identities []age.Identity, logger *Logger) error {
+	script, closer, err := openExport(path, identities)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	defer closer.Close()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %v", err)
+	}
+	defer conn.Close()
+
+	logger.Info("Restoring %s", path)
+	r := bufio.NewReaderSize(script, 1<<20)
+	var loaded int
+	for {
+		stmt, err := readStatement(r, true)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", path, err)
+		}
+
+		if !strings.HasPrefix(stmt, loadDataPrefix) {
+			if _, err := conn.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("failed to execute %s: %v", truncateSQL(stmt, 100), err)
+			}
+			continue
+		}
+
+		name, rest, err := cutMySQLString(strings.TrimPrefix(stmt, loadDataPrefix))
+		if err != nil {
+			return fmt.Errorf("malformed LOAD DATA statement %s: %v", truncateSQL(stmt, 100), err)
+		}
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(filepath.Dir(path), name)
+		}
+		loaded++
+		logger.Info("Loading %s", name)
+		rows, err := loadDataFile(ctx, conn, name, rest, identities)
+		if err != nil {
+			return fmt.Errorf("failed to load %s: %v", name, err)
+		}
+		logger.Info("Loaded %d rows from %s", rows, name)
+	}
+
+	logger.Info("Restore of %s complete, %d data files loaded", path, loaded)
+	return nil
+}
+
+// loadDataFile runs a LOAD DATA LOCAL INFILE statement for the data file
+// name, where rest is the statement after the file name.
+func loadDataFile(ctx context.Context, conn *sql.Conn, name, rest string, identities []age.Identity) (int64, error) {
+	data, closer, err := openExport(name, identities)
+	if err != nil {
+		return 0, err
+	}
+	defer closer.Close()
+
+	// The driver asks for the file by the name in the statement
+	handler := "restore/" + filepath.Base(name)
+	mysql.RegisterReaderHandler(handler, func() io.Reader { return data })
+	defer mysql.DeregisterReaderHandler(handler)
+
+	result, err := conn.ExecContext(ctx, loadDataPrefix+mysqlQuote("Reader::"+handler)+rest)
+	if err != nil {
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && (mysqlErr.Number == 1148 || mysqlErr.Number == 3948) {
+			return 0, fmt.Errorf("%v (set local_infile=ON on the server)", err)
+		}
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// readLoadDataScript returns the data files a .load.sql file loads, as
+// written in its LOAD DATA statements.
+func readLoadDataScript(path string, identities []age.Identity) ([]string, error) {
+	script, closer, err := openExport(path, identities)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	var files []string
+	r := bufio.NewReader(script)
+	for {
+		stmt, err := readStatement(r, true)
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if rest, ok := strings.CutPrefix(stmt, loadDataPrefix); ok {
+			name, _, err := cutMySQLString(rest)
+			if err != nil {
+				return nil, fmt.Errorf("malformed LOAD DATA statement %s: %v", truncateSQL(stmt, 100), err)
+			}
+			files = append(files, name)
+		}
+	}
+}
+
+// cutMySQLString splits a quoted string literal from the start of s and
+// returns its value and what follows it.
+func cutMySQLString(s string) (string, string, error) {
+	if s == "" || (s[0] != '\'' && s[0] != '"') {
+		return "", "", errors.New("no quoted file name")
+	}
+	quote := s[0]
+	var value strings.Builder
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s):
+			i++
+			switch s[i] {
+			case 'n':
+				value.WriteByte('\n')
+			case 'r':
+				value.WriteByte('\r')
+			case 't':
+				value.WriteByte('\t')
+			case '0':
+				value.WriteByte(0)
+			case 'Z':
+				value.WriteByte(0x1a)
+			default:
+				value.WriteByte(s[i])
+			}
+		case c == quote && i+1 < len(s) && s[i+1] == quote:
+			value.WriteByte(quote)
+			i++
+		case c == quote:
+			return value.String(), s[i+1:], nil
+		default:
+			value.WriteByte(c)
+		}
+	}
+	return "", "", errors.New("unterminated quoted file name")
+}
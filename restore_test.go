@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/go-sql-driver/mysql"
+)
+
+// recordingDB is a database/sql driver that records the statements it is
+// sent, standing in for a MySQL server in the restore tests.
+type recordingDB struct {
+	mu    sync.Mutex
+	conns int
+	execs []recordedExec
+	// loadErr fails every LOAD DATA statement
+	loadErr error
+}
+
+type recordedExec struct {
+	conn  int
+	query string
+}
+
+func (d *recordingDB) Connect(context.Context) (driver.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.conns++
+	return &recordingConn{db: d, id: d.conns}, nil
+}
+
+func (d *recordingDB) Driver() driver.Driver { return nil }
+
+// queries returns the statements executed so far and fails the test if
+// they did not all run on the same connection.
+func (d *recordingDB) queries(t *testing.T) []string {
+	t.Helper()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var queries []string
+	for _, exec := range d.execs {
+		if exec.conn != d.execs[0].conn {
+			t.Errorf("%s ran on connection %d, not %d", exec.query, exec.conn, d.execs[0].conn)
+		}
+		queries = append(queries, exec.query)
+	}
+	return queries
+}
+
+type recordingConn struct {
+	db *recordingDB
+	id int
+}
+
+func (c *recordingConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements are not supported")
+}
+
+func (c *recordingConn) Close() error { return nil }
+
+func (c *recordingConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+func (c *recordingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.db.execs = append(c.db.execs, recordedExec{conn: c.id, query: query})
+	if strings.HasPrefix(query, loadDataPrefix) {
+		if c.db.loadErr != nil {
+			return nil, c.db.loadErr
+		}
+		return driver.RowsAffected(2), nil
+	}
+	return driver.RowsAffected(0), nil
+}
+
+func openRecordingDB(t *testing.T) (*sql.DB, *recordingDB) {
+	t.Helper()
+	recorder := &recordingDB{}
+	db := sql.OpenDB(recorder)
+	// Spare connections show up if restoreFile does not stick to one
+	db.SetMaxIdleConns(5)
+	t.Cleanup(func() { db.Close() })
+	return db, recorder
+}
+
+// writeExportFile writes content to dir/name, encrypted to recipients when
+// name ends in .age, and returns its path.
+func writeExportFile(t *testing.T, dir, name, content string, recipients []age.Recipient) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if !strings.HasSuffix(name, encryptedSuffix) {
+		recipients = nil
+	}
+	w, err := encryptWriter(file, recipients)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const restoreRest = " INTO TABLE `sms_log` CHARACTER SET utf8mb4\n  FIELDS TERMINATED BY '\\t' ESCAPED BY '\\\\'\n  LINES TERMINATED BY '\\n'\n  (`id`, `message`)"
+
+func TestRestoreFile(t *testing.T) {
+	dir := t.TempDir()
+	otherDir := t.TempDir()
+	writeExportFile(t, dir, "part 1.tsv", "1\tfirst\n", nil)
+	absolute := writeExportFile(t, otherDir, "part2.tsv", "2\tsecond\n", nil)
+	script := writeExportFile(t, dir, "sms_log.load.sql", `-- header; with a semicolon
+SET NAMES utf8mb4;
+DROP TABLE IF EXISTS `+"`sms_log`"+`;
+CREATE TABLE `+"`sms_log` (`id` int, `message` text COMMENT 'a;b')"+`;
+LOAD DATA LOCAL INFILE 'part 1.tsv'`+restoreRest+`;
+LOAD DATA LOCAL INFILE '`+absolute+`'`+restoreRest+`;
+`, nil)
+
+	db, recorder := openRecordingDB(t)
+	if err := restoreFile(context.Background(), db, script, nil, testLogger()); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"SET NAMES utf8mb4",
+		"DROP TABLE IF EXISTS `sms_log`",
+		"CREATE TABLE `sms_log` (`id` int, `message` text COMMENT 'a;b')",
+		// The driver is handed the files through reader handlers
+		"LOAD DATA LOCAL INFILE 'Reader::restore/part 1.tsv'" + restoreRest,
+		"LOAD DATA LOCAL INFILE 'Reader::restore/part2.tsv'" + restoreRest,
+	}
+	if got := recorder.queries(t); !reflect.DeepEqual(got, want) {
+		t.Errorf("executed:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestRestoreFileMissingDataFile(t *testing.T) {
+	dir := t.TempDir()
+	script := writeExportFile(t, dir, "sms_log.load.sql", "LOAD DATA LOCAL INFILE 'gone.tsv'"+restoreRest+";\n", nil)
+
+	db, _ := openRecordingDB(t)
+	err := restoreFile(context.Background(), db, script, nil, testLogger())
+	// Relative names are read next to the script
+	if err == nil || !strings.Contains(err.Error(), filepath.Join(dir, "gone.tsv")) {
+		t.Errorf("restoreFile error = %v, want the missing file next to the script", err)
+	}
+}
+
+func TestRestoreFileEncrypted(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipients := []age.Recipient{identity.Recipient()}
+	dir := t.TempDir()
+	writeExportFile(t, dir, "part1.tsv.age", "1\tfirst\n", recipients)
+	script := writeExportFile(t, dir, "sms_log.load.sql.age", "LOAD DATA LOCAL INFILE 'part1.tsv.age'"+restoreRest+";\n", recipients)
+
+	db, recorder := openRecordingDB(t)
+	if err := restoreFile(context.Background(), db, script, nil, testLogger()); err == nil || !strings.Contains(err.Error(), "-identity-file") {
+		t.Errorf("restoreFile without identities error = %v", err)
+	}
+
+	other, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restoreFile(context.Background(), db, script, []age.Identity{other}, testLogger()); err == nil || !strings.Contains(err.Error(), "decrypt") {
+		t.Errorf("restoreFile with the wrong identity error = %v", err)
+	}
+	if got := recorder.queries(t); len(got) != 0 {
+		t.Fatalf("ran %q without being able to decrypt", got)
+	}
+
+	if err := restoreFile(context.Background(), db, script, []age.Identity{identity}, testLogger()); err != nil {
+		t.Fatal(err)
+	}
+	if got := recorder.queries(t); len(got) != 1 || !strings.HasPrefix(got[0], "LOAD DATA LOCAL INFILE 'Reader::restore/part1.tsv.age'") {
+		t.Errorf("executed %q", got)
+	}
+}
+
+func TestLoadDataFile(t *testing.T) {
+	dir := t.TempDir()
+	path := writeExportFile(t, dir, "part1.tsv", "1\tfirst\n2\tsecond\n", nil)
+
+	db, recorder := openRecordingDB(t)
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	rows, err := loadDataFile(context.Background(), conn, path, restoreRest, nil)
+	if err != nil || rows != 2 {
+		t.Errorf("loadDataFile = %d, %v, want 2 rows", rows, err)
+	}
+
+	recorder.loadErr = &mysql.MySQLError{Number: 3948, Message: "Loading local data is disabled"}
+	if _, err := loadDataFile(context.Background(), conn, path, restoreRest, nil); err == nil || !strings.Contains(err.Error(), "local_infile=ON") {
+		t.Errorf("disabled local_infile reported as %v", err)
+	}
+
+	recorder.loadErr = &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}
+	if _, err := loadDataFile(context.Background(), conn, path, restoreRest, nil); err == nil || strings.Contains(err.Error(), "local_infile") {
+		t.Errorf("duplicate key reported as %v", err)
+	}
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// defaultThrottleChunkSize is used when throttling is enabled without -chunk-size,
+// since throttling can only pause between statements.
+const defaultThrottleChunkSize = 1000
+
+type replica struct {
+	addr string
+	db   *sql.DB
+}
+
+var statusVariablePattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// loadThreshold is a limit on a numeric SHOW GLOBAL STATUS variable.
+type loadThreshold struct {
+	variable string
+	limit    int64
+}
+
+// Throttler pauses the chunked copy and delete phases while any configured
+// replica lags behind the source by more than the allowed threshold, or while
+// the source exceeds its max load. Exceeding the critical load aborts the run.
+type Throttler struct {
+	source         *sql.DB
+	replicas       []replica
+	maxLag         time.Duration
+	heartbeatTable string
+	heartbeatUTC   bool
+	maxLoad        []loadThreshold
+	criticalLoad   []loadThreshold
+	interval       time.Duration
+	logger         *Logger
+}
+
+func newThrottler(ctx context.Context, db *sql.DB, config *Config, logger *Logger) (*Throttler, error) {
+	t := &Throttler{
+		source:         db,
+		maxLag:         config.MaxReplicaLag,
+		heartbeatTable: config.HeartbeatTable,
+		heartbeatUTC:   config.HeartbeatUTC,
+		interval:       config.ThrottleInterval,
+		logger:         logger,
+	}
+
+	var err error
+	if t.maxLoad, err = parseLoadThresholds(config.MaxLoad); err != nil {
+		return nil, fmt.Errorf("invalid -max-load: %v", err)
+	}
+	if t.criticalLoad, err = parseLoadThresholds(config.CriticalLoad); err != nil {
+		return nil, fmt.Errorf("invalid -critical-load: %v", err)
+	}
+	if len(t.maxLoad) > 0 || len(t.criticalLoad) > 0 {
+		// A misspelt variable must fail before the new table is created
+		status, err := globalStatus(ctx, db, t.maxLoad, t.criticalLoad)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read server status: %v", err)
+		}
+		if err := checkStatusVariables(status, t.maxLoad, t.criticalLoad); err != nil {
+			return nil, err
+		}
+		logger.Info("Monitoring server load (max: %s, critical: %s)", config.MaxLoad, config.CriticalLoad)
+	}
+
+	for _, addr := range splitList(config.Replicas) {
+		db, err := connectReplica(ctx, config, addr)
+		if err != nil {
+			t.Close()
+			return nil, fmt.Errorf("failed to connect to replica %s: %v", addr, err)
+		}
+		t.replicas = append(t.replicas, replica{addr: addr, db: db})
+		logger.Info("Monitoring replication lag on %s (max %s)", addr, t.maxLag)
+	}
+
+	return t, nil
+}
+
+// connectReplica opens a connection to addr using the same credentials and
+// connection options as the source server.
+func connectReplica(ctx context.Context, config *Config, addr string) (*sql.DB, error) {
+	cfg, err := mysqlConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, strconv.Itoa(config.Port))
+	}
+	cfg.Net = "tcp"
+	cfg.Addr = addr
+	if cfg.TLS != nil && config.TLSServerName == "" {
+		cfg.TLS = cfg.TLS.Clone()
+		cfg.TLS.ServerName, _, _ = net.SplitHostPort(addr)
+	}
+
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return nil, err
+	}
+	db := sql.OpenDB(connector)
+	db.SetMaxOpenConns(1)
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Enabled reports whether the throttler has anything to check.
+func (t *Throttler) Enabled() bool {
+	return t != nil && (len(t.replicas) > 0 || len(t.maxLoad) > 0 || len(t.criticalLoad) > 0)
+}
+
+// Wait blocks until every replica is within the allowed lag and the source is
+// below its max load, or ctx is done. It fails when the critical load is hit.
+func (t *Throttler) Wait(ctx context.Context) error {
+	if !t.Enabled() {
+		return nil
+	}
+
+	throttled := false
+	for {
+		reason, err := t.check(ctx)
+		if err != nil {
+			return err
+		}
+		if reason == "" {
+			if throttled {
+				t.logger.Info("Throttle released, resuming")
+			}
+			return nil
+		}
+
+		if !throttled {
+			t.logger.Warning("Throttling: %s", reason)
+			throttled = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(t.interval):
+		}
+	}
+}
+
+// check returns a description of why work should pause, or "" if it may
+// continue. Exceeding the critical load is reported as an error.
+func (t *Throttler) check(ctx context.Context) (string, error) {
+	if len(t.maxLoad) > 0 || len(t.criticalLoad) > 0 {
+		status, err := globalStatus(ctx, t.source, t.maxLoad, t.criticalLoad)
+		if err != nil {
+			return "", fmt.Errorf("failed to read server status: %v", err)
+		}
+		if err := checkStatusVariables(status, t.maxLoad, t.criticalLoad); err != nil {
+			return "", err
+		}
+		for _, threshold := range t.criticalLoad {
+			if value := status[threshold.variable]; value > threshold.limit {
+				return "", fmt.Errorf("critical load: %s=%d exceeds %d", threshold.variable, value, threshold.limit)
+			}
+		}
+		for _, threshold := range t.maxLoad {
+			if value := status[threshold.variable]; value > threshold.limit {
+				return fmt.Sprintf("server load %s=%d exceeds %d", threshold.variable, value, threshold.limit), nil
+			}
+		}
+	}
+
+	for _, r := range t.replicas {
+		lag, running, err := t.replicaLag(ctx, r.db)
+		if err != nil {
+			return "", fmt.Errorf("failed to check lag on replica %s: %v", r.addr, err)
+		}
+		if !running {
+			return fmt.Sprintf("replication is not running on %s", r.addr), nil
+		}
+		if lag > t.maxLag {
+			return fmt.Sprintf("replica %s is %s behind (max %s)", r.addr, lag.Round(time.Millisecond), t.maxLag), nil
+		}
+	}
+	return "", nil
+}
+
+func (t *Throttler) replicaLag(ctx context.Context, db *sql.DB) (time.Duration, bool, error) {
+	if t.heartbeatTable != "" {
+		return heartbeatLag(ctx, db, t.heartbeatTable, t.heartbeatUTC)
+	}
+	return replicaStatusLag(ctx, db)
+}
+
+// heartbeatLag reads the lag from a pt-heartbeat style table whose ts column
+// holds the time of the last heartbeat written on the source: UTC when
+// pt-heartbeat runs with --utc, otherwise the local time of the source,
+// which is compared with NOW() on the replica.
+func heartbeatLag(ctx context.Context, db *sql.DB, table string, utc bool) (time.Duration, bool, error) {
+	var micros sql.NullInt64
+	if err := db.QueryRowContext(ctx, heartbeatLagQuery(table, utc)).Scan(&micros); err != nil {
+		return 0, false, err
+	}
+	if !micros.Valid {
+		return 0, false, nil
+	}
+	lag, err := heartbeatDelay(micros.Int64, utc)
+	return lag, true, err
+}
+
+func heartbeatLagQuery(table string, utc bool) string {
+	now := "NOW(6)"
+	if utc {
+		now = "UTC_TIMESTAMP(6)"
+	}
+	return fmt.Sprintf("SELECT TIMESTAMPDIFF(MICROSECOND, MAX(ts), %s) FROM %s", now, quoteQualifiedName(table))
+}
+
+// maxHeartbeatSkew is how far a heartbeat may be ahead of the replica clock
+// before it is taken for a time zone mismatch rather than clock skew.
+const maxHeartbeatSkew = time.Minute
+
+// heartbeatDelay turns the age of the last heartbeat into a lag. A heartbeat
+// from the future means ts is not in the time zone it is compared in.
+func heartbeatDelay(micros int64, utc bool) (time.Duration, error) {
+	lag := time.Duration(micros) * time.Microsecond
+	if lag < -maxHeartbeatSkew {
+		if utc {
+			return 0, fmt.Errorf("last heartbeat is %s ahead of UTC; run pt-heartbeat with --utc or pass -heartbeat-utc=false", -lag)
+		}
+		return 0, fmt.Errorf("last heartbeat is %s ahead of the replica's NOW(); check the time zones of source and replica", -lag)
+	}
+	return max(lag, 0), nil
+}
+
+// replicaStatusLag reads Seconds_Behind_Source from SHOW REPLICA STATUS,
+// falling back to SHOW SLAVE STATUS on servers older than MySQL 8.0.22.
+func replicaStatusLag(ctx context.Context, db *sql.DB) (time.Duration, bool, error) {
+	status, err := queryReplicaStatus(ctx, db, "SHOW REPLICA STATUS")
+	if err != nil {
+		status, err = queryReplicaStatus(ctx, db, "SHOW SLAVE STATUS")
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	if status == nil {
+		return 0, false, fmt.Errorf("server is not a replica")
+	}
+
+	value, ok := status["Seconds_Behind_Source"]
+	if !ok {
+		value = status["Seconds_Behind_Master"]
+	}
+	if !value.Valid {
+		return 0, false, nil
+	}
+
+	seconds, err := strconv.ParseInt(value.String, 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("unexpected replication lag %q", value.String)
+	}
+	return time.Duration(seconds) * time.Second, true, nil
+}
+
+func queryReplicaStatus(ctx context.Context, db *sql.DB, query string) (map[string]sql.NullString, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+
+	values := make([]sql.NullString, len(columns))
+	valuePtrs := make([]interface{}, len(columns))
+	for i := range values {
+		valuePtrs[i] = &values[i]
+	}
+	if err := rows.Scan(valuePtrs...); err != nil {
+		return nil, err
+	}
+
+	status := make(map[string]sql.NullString, len(columns))
+	for i, column := range columns {
+		status[column] = values[i]
+	}
+	return status, nil
+}
+
+// globalStatus reads the status variables named in the given thresholds.
+func globalStatus(ctx context.Context, db *sql.DB, thresholds ...[]loadThreshold) (map[string]int64, error) {
+	// SHOW statements cannot take placeholders; names are validated by parseLoadThresholds
+	var names []string
+	for _, list := range thresholds {
+		for _, threshold := range list {
+			names = append(names, "'"+threshold.variable+"'")
+		}
+	}
+
+	query := fmt.Sprintf("SHOW GLOBAL STATUS WHERE Variable_name IN (%s)", strings.Join(names, ","))
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	status := make(map[string]int64)
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("status variable %s is not numeric: %q", name, value)
+		}
+		status[strings.ToLower(name)] = n
+	}
+
+	return status, rows.Err()
+}
+
+// checkStatusVariables returns an error for the first threshold whose
+// variable is missing from status, which would otherwise read as 0.
+func checkStatusVariables(status map[string]int64, thresholds ...[]loadThreshold) error {
+	for _, list := range thresholds {
+		for _, threshold := range list {
+			if _, ok := status[threshold.variable]; !ok {
+				return fmt.Errorf("unknown status variable %s", threshold.variable)
+			}
+		}
+	}
+	return nil
+}
+
+// parseLoadThresholds parses a list such as "Threads_running=25,Threads_connected=500".
+func parseLoadThresholds(value string) ([]loadThreshold, error) {
+	var thresholds []loadThreshold
+	for _, item := range splitList(value) {
+		name, limit, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("expected variable=limit, got %q", item)
+		}
+		name = strings.TrimSpace(name)
+		if !statusVariablePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid status variable %q", name)
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(limit), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid limit for %s: %q", name, limit)
+		}
+		thresholds = append(thresholds, loadThreshold{variable: strings.ToLower(name), limit: n})
+	}
+	return thresholds, nil
+}
+
+func (t *Throttler) Close() {
+	if t == nil {
+		return
+	}
+	for _, r := range t.replicas {
+		r.db.Close()
+	}
+}
+
+// quoteQualifiedName quotes a possibly schema-qualified name such as percona.heartbeat.
+func quoteQualifiedName(name string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = "`" + strings.ReplaceAll(part, "`", "``") + "`"
+	}
+	return strings.Join(parts, ".")
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
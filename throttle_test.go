@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitList(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"a", []string{"a"}},
+		{" a , b,,c ", []string{"a", "b", "c"}},
+		{" , ", nil},
+	}
+	for _, tt := range tests {
+		if got := splitList(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitList(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestQuoteQualifiedName(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"heartbeat", "`heartbeat`"},
+		{"percona.heartbeat", "`percona`.`heartbeat`"},
+		{"we`ird.t", "`we``ird`.`t`"},
+	}
+	for _, tt := range tests {
+		if got := quoteQualifiedName(tt.in); got != tt.want {
+			t.Errorf("quoteQualifiedName(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestHeartbeatLagQuery(t *testing.T) {
+	if got := heartbeatLagQuery("percona.heartbeat", true); got != "SELECT TIMESTAMPDIFF(MICROSECOND, MAX(ts), UTC_TIMESTAMP(6)) FROM `percona`.`heartbeat`" {
+		t.Errorf("UTC query is %s", got)
+	}
+	if got := heartbeatLagQuery("heartbeat", false); !strings.Contains(got, "MAX(ts), NOW(6))") {
+		t.Errorf("local time query is %s", got)
+	}
+}
+
+func TestHeartbeatDelay(t *testing.T) {
+	tests := []struct {
+		micros  int64
+		utc     bool
+		want    time.Duration
+		wantErr string
+	}{
+		{2500000, true, 2500 * time.Millisecond, ""},
+		{0, true, 0, ""},
+		// Small clock skew counts as no lag
+		{-30000000, true, 0, ""},
+		// ts written in local time east of UTC
+		{-int64(time.Hour / time.Microsecond), true, 0, "--utc"},
+		{-int64(time.Hour / time.Microsecond), false, 0, "NOW()"},
+	}
+	for _, tt := range tests {
+		got, err := heartbeatDelay(tt.micros, tt.utc)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("heartbeatDelay(%d, %v) error = %v, want %q", tt.micros, tt.utc, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("heartbeatDelay(%d, %v) = %s, %v, want %s", tt.micros, tt.utc, got, err, tt.want)
+		}
+	}
+}
+
+func TestThrottlerDisabled(t *testing.T) {
+	var throttler *Throttler
+	if throttler.Enabled() {
+		t.Error("nil throttler is enabled")
+	}
+	if err := throttler.Wait(context.Background()); err != nil {
+		t.Errorf("Wait on a nil throttler: %v", err)
+	}
+	throttler.Close()
+
+	if (&Throttler{}).Enabled() {
+		t.Error("throttler without replicas or load limits is enabled")
+	}
+}
+
+func TestParseLoadThresholds(t *testing.T) {
+	got, err := parseLoadThresholds(" Threads_running=25, threads_connected = 800 ,")
+	want := []loadThreshold{{"threads_running", 25}, {"threads_connected", 800}}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("parseLoadThresholds = %v, %v, want %v", got, err, want)
+	}
+	if got, err := parseLoadThresholds(""); err != nil || got != nil {
+		t.Errorf("parseLoadThresholds(\"\") = %v, %v", got, err)
+	}
+
+	for _, bad := range []string{"Threads_running", "Threads_running=many", "Threads_running=1.5", "Threads'running=1", "=5"} {
+		if _, err := parseLoadThresholds(bad); err == nil {
+			t.Errorf("parseLoadThresholds(%q) accepted", bad)
+		}
+	}
+}
+
+func TestCheckStatusVariables(t *testing.T) {
+	maxLoad := []loadThreshold{{"threads_running", 25}}
+	criticalLoad := []loadThreshold{{"threads_runing", 100}}
+	status := map[string]int64{"threads_running": 0}
+
+	if err := checkStatusVariables(status, maxLoad); err != nil {
+		t.Errorf("known variable reported: %v", err)
+	}
+	if err := checkStatusVariables(status, maxLoad, criticalLoad); err == nil || !strings.Contains(err.Error(), "threads_runing") {
+		t.Errorf("misspelt variable not reported: %v", err)
+	}
+}
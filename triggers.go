@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// trigger is a trigger on the archived table, from INFORMATION_SCHEMA.TRIGGERS.
+type trigger struct {
+	Name   string
+	Timing string
+	Event  string
+}
+
+// getTriggers returns the triggers defined on table in the order they fire.
+func getTriggers(ctx context.Context, db *sql.DB, table string) ([]trigger, error) {
+	query := `SELECT TRIGGER_NAME, ACTION_TIMING, EVENT_MANIPULATION FROM INFORMATION_SCHEMA.TRIGGERS
+		WHERE EVENT_OBJECT_SCHEMA = DATABASE() AND EVENT_OBJECT_TABLE = ?
+		ORDER BY ACTION_TIMING, EVENT_MANIPULATION, ACTION_ORDER`
+	rows, err := db.QueryContext(ctx, query, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var triggers []trigger
+	for rows.Next() {
+		var t trigger
+		if err := rows.Scan(&t.Name, &t.Timing, &t.Event); err != nil {
+			return nil, err
+		}
+		triggers = append(triggers, t)
+	}
+	return triggers, rows.Err()
+}
+
+// checkTableReferences reports the triggers, views and table-level grants
+// of table. The table is never renamed, so all of them keep working on the
+// live data; the archive table gets none of them.
+func checkTableReferences(ctx context.Context, db *sql.DB, table, archiveTable string, logger *Logger) error {
+	triggers, err := getTriggers(ctx, db, table)
+	if err != nil {
+		return fmt.Errorf("failed to read triggers: %v", err)
+	}
+	for _, t := range triggers {
+		logger.Info("Trigger %s (%s %s) stays on %s", t.Name, t.Timing, t.Event, table)
+		if t.Event == "DELETE" {
+			logger.Warning("Trigger %s fires %s DELETE for every record deleted from %s in Step 6", t.Name, t.Timing, table)
+		}
+	}
+
+	views, err := referencingViews(ctx, db, table)
+	if err != nil {
+		return fmt.Errorf("failed to look up views: %v", err)
+	}
+	if len(views) > 0 {
+		logger.Info("Views referencing %s keep reading the live table, not %s: %s", table, archiveTable, strings.Join(views, ", "))
+	}
+
+	query := `SELECT DISTINCT GRANTEE FROM INFORMATION_SCHEMA.TABLE_PRIVILEGES
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?
+		ORDER BY GRANTEE`
+	grantees, err := queryStrings(ctx, db, query, table)
+	if err != nil {
+		return fmt.Errorf("failed to look up table privileges: %v", err)
+	}
+	if len(grantees) > 0 {
+		logger.Warning("Table-level grants on %s do not cover %s: %s", table, archiveTable, strings.Join(grantees, ", "))
+	}
+
+	return nil
+}
+
+// referencingViews returns the views of the current database whose
+// definition mentions table. The definitions are matched here rather than
+// with LIKE, where the _ in most table names is a wildcard, and rather than
+// through VIEW_TABLE_USAGE, which only exists on MySQL 8.0.13 and later.
+func referencingViews(ctx context.Context, db *sql.DB, table string) ([]string, error) {
+	query := `SELECT TABLE_NAME, VIEW_DEFINITION FROM INFORMATION_SCHEMA.VIEWS
+		WHERE TABLE_SCHEMA = DATABASE()
+		ORDER BY TABLE_NAME`
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var views []string
+	for rows.Next() {
+		var name, definition string
+		if err := rows.Scan(&name, &definition); err != nil {
+			return nil, err
+		}
+		if viewReferences(definition, table) {
+			views = append(views, name)
+		}
+	}
+	return views, rows.Err()
+}
+
+// viewReferences reports whether a view definition as stored by MySQL, with
+// every identifier quoted, mentions table.
+func viewReferences(definition, table string) bool {
+	return strings.Contains(definition, quoteIdentifier(table))
+}
+
+func queryStrings(ctx context.Context, db *sql.DB, query string, args ...any) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	return values, rows.Err()
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestViewReferences(t *testing.T) {
+	tests := []struct {
+		definition string
+		want       bool
+	}{
+		{"select `shop`.`sms_log`.`id` AS `id` from `shop`.`sms_log`", true},
+		{"select `l`.`id` AS `id` from (`shop`.`users` `u` join `shop`.`sms_log` `l`)", true},
+		// With LIKE, _ would have matched any character here
+		{"select `shop`.`smsxlog`.`id` AS `id` from `shop`.`smsxlog`", false},
+		{"select `shop`.`sms_log_2024`.`id` AS `id` from `shop`.`sms_log_2024`", false},
+		{"select 'sms_log' AS `name`", false},
+	}
+	for _, tt := range tests {
+		if got := viewReferences(tt.definition, "sms_log"); got != tt.want {
+			t.Errorf("viewReferences(%q) = %v, want %v", tt.definition, got, tt.want)
+		}
+	}
+
+	if !viewReferences("select `we``ird`.`a` AS `a` from `shop`.`we``ird`", "we`ird") {
+		t.Error("view on a table with a backtick in its name not found")
+	}
+}
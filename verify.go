@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/crc32"
+	"strings"
+	"time"
+)
+
+const (
+	VerifyCount    = "count"
+	VerifyChecksum = "checksum"
+
+	defaultChecksumChunkSize = 10000
+
+	// maxChecksumMismatches caps the ranges verifyChecksums collects, so a
+	// copy that is wrong throughout is not compared to the end
+	maxChecksumMismatches = 100
+)
+
+// checksumMismatch is a primary key range whose rows differ between the
+// source and the archive table. A nil bound means the range is open.
+type checksumMismatch struct {
+	Lower, Upper                    any
+	SourceRows, ArchiveRows         int64
+	SourceChecksum, ArchiveChecksum string
+}
+
+func (m checksumMismatch) Range() string {
+	lower, upper := "-inf", "+inf"
+	if m.Lower != nil {
+		lower = fmt.Sprintf("%v", m.Lower)
+	}
+	if m.Upper != nil {
+		upper = fmt.Sprintf("%v", m.Upper)
+	}
+	return fmt.Sprintf("(%s, %s]", lower, upper)
+}
+
+// mismatchedRanges describes mismatches for an error message, naming the
+// first few ranges.
+func mismatchedRanges(mismatches []checksumMismatch) string {
+	const named = 3
+	var ranges []string
+	for i := 0; i < len(mismatches) && i < named; i++ {
+		ranges = append(ranges, mismatches[i].Range())
+	}
+	description := fmt.Sprintf("%d primary key ranges: %s", len(mismatches), strings.Join(ranges, ", "))
+	if len(mismatches) == 1 {
+		description = "primary key range " + ranges[0]
+	}
+	if len(mismatches) > named {
+		description += ", ..."
+	}
+	if len(mismatches) == maxChecksumMismatches {
+		description = "at least " + description
+	}
+	return description
+}
+
+// verifyChecksums compares the rows older than cutoffDate in sourceTable
+// with the rows of destTable, chunkSize primary key values at a time, and
+// returns the ranges that differ, up to maxChecksumMismatches. The checksum of each range
+// is computed by the server (see Dialect.ChecksumAggregate), so rows are
+// never sent to the client. Tables without a single-column primary key are
+// compared as one range.
+func verifyChecksums(ctx context.Context, db *sql.DB, dialect Dialect, sourceTable, destTable, dateColumn string, cutoffDate time.Time, chunkSize int, logger *Logger) ([]checksumMismatch, error) {
+	if chunkSize <= 0 {
+		chunkSize = defaultChecksumChunkSize
+	}
+
+	columns, err := dialect.Columns(ctx, db, sourceTable)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get columns: %v", err)
+	}
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = dialect.QuoteIdentifier(column.Name)
+	}
+	selectHead := "COUNT(*), " + dialect.ChecksumAggregate(quoted)
+
+	pkColumns, err := dialect.PrimaryKey(ctx, db, sourceTable)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get primary key: %v", err)
+	}
+
+	source, dest, column := dialect.QuoteIdentifier(sourceTable), dialect.QuoteIdentifier(destTable), dialect.QuoteIdentifier(dateColumn)
+	cutoff := dialect.TimeArg(cutoffDate)
+
+	if len(pkColumns) != 1 {
+		logger.Warning("Table %s has no single-column primary key, comparing checksums over the whole table", sourceTable)
+		mismatch, err := compareRange(ctx, db, dialect,
+			fmt.Sprintf("SELECT %s FROM %s WHERE %s < ?", selectHead, source, column), []any{cutoff},
+			fmt.Sprintf("SELECT %s FROM %s", selectHead, dest), nil)
+		if err != nil || mismatch == nil {
+			return nil, err
+		}
+		return []checksumMismatch{*mismatch}, nil
+	}
+
+	logger.Info("Comparing checksums in chunks of %d rows by primary key %s", chunkSize, pkColumns[0])
+
+	pk := dialect.QuoteIdentifier(pkColumns[0])
+	boundQuery := dialect.Rebind(fmt.Sprintf("SELECT %s FROM %s WHERE %s < ? AND %s > ? ORDER BY %s LIMIT 1 OFFSET %d",
+		pk, source, column, pk, pk, chunkSize-1))
+	firstBoundQuery := dialect.Rebind(fmt.Sprintf("SELECT %s FROM %s WHERE %s < ? ORDER BY %s LIMIT 1 OFFSET %d",
+		pk, source, column, pk, chunkSize-1))
+
+	var lower any
+	var chunks int
+	var mismatches []checksumMismatch
+	for {
+		var upper any
+		var err error
+		if lower == nil {
+			err = db.QueryRowContext(ctx, firstBoundQuery, cutoff).Scan(&upper)
+		} else {
+			err = db.QueryRowContext(ctx, boundQuery, cutoff, lower).Scan(&upper)
+		}
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+
+		var conditions []string
+		var args []any
+		if lower != nil {
+			conditions = append(conditions, pk+" > ?")
+			args = append(args, lower)
+		}
+		if upper != nil {
+			conditions = append(conditions, pk+" <= ?")
+			args = append(args, upper)
+		}
+
+		sourceQuery := fmt.Sprintf("SELECT %s FROM %s WHERE %s", selectHead, source, strings.Join(append([]string{column + " < ?"}, conditions...), " AND "))
+		sourceArgs := append([]any{cutoff}, args...)
+		destQuery := fmt.Sprintf("SELECT %s FROM %s", selectHead, dest)
+		if len(conditions) > 0 {
+			destQuery += " WHERE " + strings.Join(conditions, " AND ")
+		}
+
+		mismatch, err := compareRange(ctx, db, dialect, sourceQuery, sourceArgs, destQuery, args)
+		if err != nil {
+			return nil, err
+		}
+		chunks++
+		if mismatch != nil {
+			mismatch.Lower, mismatch.Upper = lower, upper
+			mismatches = append(mismatches, *mismatch)
+			if len(mismatches) == maxChecksumMismatches {
+				logger.Info("Compared %d chunks, stopping after %d mismatched ranges", chunks, len(mismatches))
+				return mismatches, nil
+			}
+		}
+
+		if chunks%100 == 0 {
+			logger.Info("Compared %d chunks...", chunks)
+		}
+
+		if upper == nil {
+			break
+		}
+		lower = upper
+	}
+
+	logger.Info("Compared %d chunks", chunks)
+	return mismatches, nil
+}
+
+// compareRange runs the two checksum queries and returns a mismatch when
+// their row counts or checksums differ, or nil when they match.
+func compareRange(ctx context.Context, db *sql.DB, dialect Dialect, sourceQuery string, sourceArgs []any, destQuery string, destArgs []any) (*checksumMismatch, error) {
+	var m checksumMismatch
+	if err := db.QueryRowContext(ctx, dialect.Rebind(sourceQuery), sourceArgs...).Scan(&m.SourceRows, &m.SourceChecksum); err != nil {
+		return nil, fmt.Errorf("failed to checksum source rows: %v", err)
+	}
+	if err := db.QueryRowContext(ctx, dialect.Rebind(destQuery), destArgs...).Scan(&m.ArchiveRows, &m.ArchiveChecksum); err != nil {
+		return nil, fmt.Errorf("failed to checksum archive rows: %v", err)
+	}
+
+	if m.SourceRows == m.ArchiveRows && m.SourceChecksum == m.ArchiveChecksum {
+		return nil, nil
+	}
+	return &m, nil
+}
+
+// checksumRows returns the number of rows returned by query and the sum of
+// their CRC32 checksums, computed on the client from the formatted values.
+// Exports are checked against it, since their rows can only be hashed on
+// the client.
+func checksumRows(ctx context.Context, db *sql.DB, dialect Dialect, query string, args ...any) (int64, uint64, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+
+	colTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	values := make([]any, len(colTypes))
+	pointers := make([]any, len(colTypes))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+
+	var count int64
+	var sum uint64
+	var row strings.Builder
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return 0, 0, err
+		}
+
+		row.Reset()
+		for i, val := range values {
+			row.WriteString(dialect.FormatValue(val, colTypes[i]))
+			row.WriteByte(0)
+		}
+		sum += uint64(crc32.ChecksumIEEE([]byte(row.String())))
+		count++
+	}
+
+	return count, sum, rows.Err()
+}
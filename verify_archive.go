@@ -0,0 +1,776 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// archiveCheck is what the verify command compares: an archive table, the
+// manifest written with its exports, and any other exports of it.
+type archiveCheck struct {
+	Table       string
+	Manifest    *Manifest
+	ManifestDir string
+	SQLFiles    []string
+	CSVFiles    []string
+}
+
+// exportCheck is an export file to verify. Rows is -1 and SHA256 empty
+// when the file is not listed in a manifest.
+type exportCheck struct {
+	Path   string
+	Format string
+	Rows   int64
+	SHA256 string
+	CSV    CSVOptions
+}
+
+// runVerify implements the verify command and returns the exit code.
+func runVerify(args []string) int {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	config := &Config{}
+	connectionFlags(fs, config)
+	csvFlags(fs, config)
+	fs.StringVar(&config.Table, "table", "", "Archive table to verify (default: the table in -manifest)")
+	manifestPath := fs.String("manifest", "", "Manifest written with the exports")
+	sqlFiles := fs.String("sql-file", "", "SQL export to check against the table, or a comma-separated list of its parts")
+	csvFiles := fs.String("csv-file", "", "CSV export to check against the table, or a comma-separated list of its parts")
+	fs.StringVar(&config.IdentityFile, "identity-file", "", "File with the age private key(s) to read encrypted (.age) exports with")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of %s verify:\n", os.Args[0])
+		fs.PrintDefaults()
+		fmt.Fprint(fs.Output(), credentialHelp)
+	}
+	fs.Parse(args)
+
+	check := archiveCheck{Table: config.Table}
+	if *manifestPath != "" {
+		manifest, err := readManifest(*manifestPath)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return 1
+		}
+		if check.Table == "" {
+			check.Table = manifest.Table
+		}
+		check.Manifest = manifest
+		check.ManifestDir = filepath.Dir(*manifestPath)
+	}
+	check.SQLFiles = splitList(*sqlFiles)
+	check.CSVFiles = splitList(*csvFiles)
+
+	if config.Database == "" || check.Table == "" {
+		fmt.Println("Error: database and either table or manifest flags are required")
+		fs.Usage()
+		return 1
+	}
+	if err := resolveConnectionFlags(fs, config); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	if err := validateCSVOptions(&config.CSV); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+
+	logger := NewLogger()
+	ctx := interruptContext(logger)
+	logger.Info("Verifying archive table %s", check.Table)
+
+	dialect, err := newDialect(config.Driver)
+	if err != nil {
+		logger.Error("%v", err)
+		return 1
+	}
+	db, err := connectDB(ctx, dialect, config, logger)
+	if err != nil {
+		logger.Error("Failed to connect to database: %v", err)
+		return 1
+	}
+	defer db.Close()
+
+	problems, err := verifyArchive(ctx, db, dialect, config, check, logger)
+	if err != nil {
+		logger.Error("Verification failed: %v", err)
+		return 1
+	}
+	if len(problems) > 0 {
+		logger.Error("Verification failed with %d problems", len(problems))
+		return 1
+	}
+
+	logger.Info("Verification passed")
+	return 0
+}
+
+// verifyArchive compares the archive table with its manifest and exports
+// and returns a description of every discrepancy found. An error means the
+// checks could not be run at all.
+func verifyArchive(ctx context.Context, db *sql.DB, dialect Dialect, config *Config, check archiveCheck, logger *Logger) ([]string, error) {
+	var problems []string
+	problem := func(format string, args ...any) {
+		msg := fmt.Sprintf(format, args...)
+		logger.Error("%s", msg)
+		problems = append(problems, msg)
+	}
+
+	columns, err := dialect.Columns(ctx, db, check.Table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get columns of %s: %v", check.Table, err)
+	}
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = column.Name
+	}
+	quotedNames, err := getColumnNames(ctx, db, dialect, check.Table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get columns of %s: %v", check.Table, err)
+	}
+
+	count, err := getTableCount(ctx, db, dialect, check.Table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count %s: %v", check.Table, err)
+	}
+	rows, checksum, err := tableChecksum(ctx, db, dialect, check.Table, columns)
+	if err != nil {
+		return nil, fmt.Errorf("failed to checksum %s: %v", check.Table, err)
+	}
+	if rows != count {
+		problem("Table %s changed while it was read: %d rows counted, %d rows checksummed", check.Table, count, rows)
+	}
+	logger.Info("Table %s: %d rows, %d columns, checksum %d", check.Table, count, len(columns), checksum)
+
+	var exports []exportCheck
+	if m := check.Manifest; m != nil {
+		if m.Table != check.Table {
+			problem("Manifest is for table %s, not %s", m.Table, check.Table)
+		}
+		if m.Driver != config.Driver {
+			logger.Warning("Manifest was written with -driver=%s", m.Driver)
+		}
+		if strings.Join(m.Columns, ",") != strings.Join(names, ",") {
+			problem("Columns differ from the manifest: table has %s, manifest lists %s", strings.Join(names, ", "), strings.Join(m.Columns, ", "))
+		}
+		if m.Rows != count {
+			problem("Row count differs from the manifest: table has %d, manifest lists %d", count, m.Rows)
+		}
+		if m.Checksum != checksum {
+			problem("Checksum differs from the manifest: table has %d, manifest lists %d", checksum, m.Checksum)
+		}
+		// Manifests written before CSV options were recorded used the defaults
+		csvOptions := defaultCSVOptions()
+		if m.CSV != nil {
+			csvOptions = *m.CSV
+		}
+		for _, file := range m.Files {
+			exports = append(exports, exportCheck{
+				Path:   filepath.Join(check.ManifestDir, file.Name),
+				Format: file.Format,
+				Rows:   file.Rows,
+				SHA256: file.SHA256,
+				CSV:    csvOptions,
+			})
+		}
+	}
+	for _, path := range check.SQLFiles {
+		exports = append(exports, exportCheck{Path: path, Format: "sql", Rows: -1})
+	}
+
+	// Exports limited to some columns are compared with those columns;
+	// masked values can only be counted
+	exportNames, exportQuoted, exportChecksum, masked := names, quotedNames, checksum, false
+	if m := check.Manifest; m != nil && len(m.ExportColumns) > 0 {
+		exportNames, masked = m.ExportColumns, len(m.Masks) > 0
+		var exportColumns []Column
+		exportQuoted = nil
+		for _, name := range exportNames {
+			i := slices.Index(names, name)
+			if i < 0 {
+				return problems, fmt.Errorf("manifest exports column %s, which %s does not have", name, check.Table)
+			}
+			exportColumns = append(exportColumns, columns[i])
+			exportQuoted = append(exportQuoted, dialect.QuoteIdentifier(name))
+		}
+		if masked {
+			logger.Info("Exports mask %s, so their values are not compared", strings.Join(m.Masks, ", "))
+		} else if _, exportChecksum, err = tableChecksum(ctx, db, dialect, check.Table, exportColumns); err != nil {
+			return nil, fmt.Errorf("failed to checksum %s: %v", check.Table, err)
+		}
+	}
+	for _, path := range check.CSVFiles {
+		exports = append(exports, exportCheck{Path: path, Format: "csv", Rows: -1, CSV: config.CSV})
+	}
+
+	// The parts of an export are checked one by one and then together
+	// against the table
+	type exportTotal struct {
+		Paths    []string
+		Rows     int64
+		Checksum uint64
+		CSV      CSVOptions
+		Broken   bool
+	}
+	totals := map[string]*exportTotal{"sql": {}, "csv": {}, "tsv": {}}
+
+	var identities []age.Identity
+	if config.IdentityFile != "" {
+		if identities, err = readIdentities(config.IdentityFile); err != nil {
+			return problems, err
+		}
+	}
+
+	for _, export := range exports {
+		if export.SHA256 != "" {
+			sum, err := fileSHA256(export.Path)
+			if err != nil {
+				problem("Export %s cannot be read: %v", export.Path, err)
+				if total := totals[export.Format]; total != nil {
+					total.Broken = true
+				}
+				continue
+			}
+			if sum != export.SHA256 {
+				problem("Export %s has SHA-256 %s, manifest lists %s", export.Path, sum, export.SHA256)
+			}
+		}
+
+		if export.Format == "load" {
+			files, err := readLoadDataScript(export.Path, identities)
+			if err != nil {
+				problem("Load file %s does not parse: %v", export.Path, err)
+				continue
+			}
+			for _, name := range files {
+				if _, err := os.Stat(filepath.Join(filepath.Dir(export.Path), name)); err != nil {
+					problem("Load file %s loads %s, which cannot be read: %v", export.Path, name, err)
+				}
+			}
+			logger.Info("Load file %s: loads %d TSV files", export.Path, len(files))
+			continue
+		}
+
+		total := totals[export.Format]
+		if total == nil {
+			problem("Export %s has unknown format %q", export.Path, export.Format)
+			continue
+		}
+		if len(total.Paths) == 0 {
+			total.CSV = export.CSV
+		}
+		total.Paths = append(total.Paths, export.Path)
+
+		switch export.Format {
+		case "sql":
+			dump, err := readSQLExport(export.Path, identities, dialect.QuoteIdentifier(check.Table), len(exportNames), config.Driver == DriverMySQL)
+			if err != nil {
+				problem("SQL export %s does not parse: %v", export.Path, err)
+				total.Broken = true
+				continue
+			}
+			for _, list := range dump.ColumnLists {
+				if list != strings.Join(exportQuoted, ",") {
+					problem("SQL export %s inserts into columns %s, want %s", export.Path, list, strings.Join(exportQuoted, ","))
+					break
+				}
+			}
+			if export.Rows >= 0 && dump.Rows != export.Rows {
+				problem("SQL export %s has %d rows, manifest lists %d", export.Path, dump.Rows, export.Rows)
+			}
+			total.Rows += dump.Rows
+			total.Checksum += dump.Checksum
+			logger.Info("SQL export %s: %d rows, checksum %d", export.Path, dump.Rows, dump.Checksum)
+		case "csv":
+			csvRows, csvChecksum, err := readCSVExport(export.Path, identities, exportNames, export.CSV)
+			if err != nil {
+				problem("CSV export %s does not parse: %v", export.Path, err)
+				total.Broken = true
+				continue
+			}
+			if export.Rows >= 0 && csvRows != export.Rows {
+				problem("CSV export %s has %d rows, manifest lists %d", export.Path, csvRows, export.Rows)
+			}
+			total.Rows += csvRows
+			total.Checksum += csvChecksum
+			logger.Info("CSV export %s: %d rows, checksum %d", export.Path, csvRows, csvChecksum)
+		case "tsv":
+			tsvRows, tsvChecksum, err := readCSVExport(export.Path, identities, exportNames, loadDataOptions())
+			if err != nil {
+				problem("TSV export %s does not parse: %v", export.Path, err)
+				total.Broken = true
+				continue
+			}
+			if export.Rows >= 0 && tsvRows != export.Rows {
+				problem("TSV export %s has %d rows, manifest lists %d", export.Path, tsvRows, export.Rows)
+			}
+			total.Rows += tsvRows
+			total.Checksum += tsvChecksum
+			logger.Info("TSV export %s: %d rows, checksum %d", export.Path, tsvRows, tsvChecksum)
+		}
+	}
+
+	if total := totals["sql"]; len(total.Paths) > 0 && !total.Broken {
+		if masked {
+			if total.Rows != count {
+				problem("SQL export %s has %d rows, table has %d", strings.Join(total.Paths, ", "), total.Rows, count)
+			}
+		} else if total.Rows != count || total.Checksum != exportChecksum {
+			problem("SQL export %s has %d rows with checksum %d, table has %d rows with checksum %d", strings.Join(total.Paths, ", "), total.Rows, total.Checksum, count, exportChecksum)
+		}
+	}
+	// CSV and TSV rows are compared with the table rows encoded the same
+	// way and read back, so dates and binary values match as exported
+	for _, name := range []string{"csv", "tsv"} {
+		total := totals[name]
+		if len(total.Paths) == 0 || total.Broken {
+			continue
+		}
+		kind := strings.ToUpper(name)
+		if masked {
+			if total.Rows != count {
+				problem("%s export %s has %d rows, table has %d", kind, strings.Join(total.Paths, ", "), total.Rows, count)
+			}
+			continue
+		}
+
+		var format exportFormat
+		options := total.CSV
+		if name == "tsv" {
+			format, err = newLoadDataFormat(exportNames, columns)
+			options = loadDataOptions()
+		} else {
+			format, err = newCSVFormat(exportNames, options)
+		}
+		if err != nil {
+			return problems, err
+		}
+		encodedChecksum, err := encodedRowsChecksum(ctx, db, dialect, check.Table, exportQuoted, format, options)
+		if err != nil {
+			return problems, fmt.Errorf("failed to checksum %s: %v", check.Table, err)
+		}
+		if total.Rows != count || total.Checksum != encodedChecksum {
+			problem("%s export %s has %d rows with checksum %d, table has %d rows with checksum %d", kind, strings.Join(total.Paths, ", "), total.Rows, total.Checksum, count, encodedChecksum)
+		}
+	}
+
+	return problems, nil
+}
+
+// sqlExport summarizes the INSERT statements of an SQL export.
+type sqlExport struct {
+	ColumnLists []string
+	Rows        int64
+	Checksum    uint64
+}
+
+// readSQLExport parses an SQL export written by exportTableToSQL, decrypting
+// it with identities if it is encrypted. The values of each row are the
+// literals FormatValue wrote, so their checksum matches the one
+// checksumRows computes on the table.
+func readSQLExport(path string, identities []age.Identity, quotedTable string, columnCount int, backslashEscapes bool) (sqlExport, error) {
+	var dump sqlExport
+
+	file, closer, err := openExport(path, identities)
+	if err != nil {
+		return dump, err
+	}
+	defer closer.Close()
+
+	seen := make(map[string]bool)
+	r := bufio.NewReaderSize(file, 1<<20)
+	for {
+		stmt, err := readStatement(r, backslashEscapes)
+		if err == io.EOF {
+			return dump, nil
+		}
+		if err != nil {
+			return dump, err
+		}
+		if !strings.HasPrefix(stmt, "INSERT INTO ") {
+			continue
+		}
+
+		rest, ok := strings.CutPrefix(stmt, "INSERT INTO "+quotedTable+" (")
+		if !ok {
+			return dump, fmt.Errorf("INSERT into another table: %s", truncateSQL(stmt, 100))
+		}
+		columnList, values, ok := strings.Cut(rest, ") VALUES\n")
+		if !ok {
+			return dump, fmt.Errorf("malformed INSERT: %s", truncateSQL(stmt, 100))
+		}
+		if !seen[columnList] {
+			seen[columnList] = true
+			dump.ColumnLists = append(dump.ColumnLists, columnList)
+		}
+
+		tuples, err := splitTuples(values, backslashEscapes)
+		if err != nil {
+			return dump, err
+		}
+		for _, fields := range tuples {
+			if len(fields) != columnCount {
+				return dump, fmt.Errorf("row with %d values in a table of %d columns", len(fields), columnCount)
+			}
+			var row strings.Builder
+			for _, field := range fields {
+				row.WriteString(field)
+				row.WriteByte(0)
+			}
+			dump.Checksum += uint64(crc32.ChecksumIEEE([]byte(row.String())))
+			dump.Rows++
+		}
+	}
+}
+
+// readStatement returns the next statement of an SQL file without its
+// terminating semicolon, skipping comment lines between statements. It
+// returns io.EOF when only whitespace and comments remain.
+func readStatement(r *bufio.Reader, backslashEscapes bool) (string, error) {
+	var stmt strings.Builder
+	var quote byte
+	escapes := false
+	for {
+		c, err := r.ReadByte()
+		if err == io.EOF {
+			if quote != 0 {
+				return "", fmt.Errorf("unterminated quoted value in %s", truncateSQL(stmt.String(), 100))
+			}
+			if strings.TrimSpace(stmt.String()) != "" {
+				return "", fmt.Errorf("unterminated statement %s", truncateSQL(stmt.String(), 100))
+			}
+			return "", io.EOF
+		}
+		if err != nil {
+			return "", err
+		}
+
+		if quote == 0 {
+			if stmt.Len() == 0 {
+				if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
+					continue
+				}
+				if next, _ := r.Peek(1); c == '-' && len(next) == 1 && next[0] == '-' {
+					if _, err := r.ReadString('\n'); err != nil && err != io.EOF {
+						return "", err
+					}
+					continue
+				}
+			}
+			if c == ';' {
+				return stmt.String(), nil
+			}
+			if c == '\'' || c == '"' || c == '`' {
+				quote = c
+				escapes = c == '\'' && (backslashEscapes || endsWithEscapePrefix(stmt.String()))
+			}
+			stmt.WriteByte(c)
+			continue
+		}
+
+		stmt.WriteByte(c)
+		switch {
+		case escapes && c == '\\':
+			next, err := r.ReadByte()
+			if err != nil {
+				return "", fmt.Errorf("unterminated quoted value in %s", truncateSQL(stmt.String(), 100))
+			}
+			stmt.WriteByte(next)
+		case c == quote:
+			// A doubled quote stands for the quote character itself
+			if next, _ := r.Peek(1); len(next) == 1 && next[0] == quote {
+				r.ReadByte()
+				stmt.WriteByte(quote)
+			} else {
+				quote = 0
+			}
+		}
+	}
+}
+
+// endsWithEscapePrefix reports whether a quote following s opens a
+// PostgreSQL escape string (E'...'), where backslashes escape.
+func endsWithEscapePrefix(s string) bool {
+	return strings.HasSuffix(s, "E") || strings.HasSuffix(s, "e")
+}
+
+// splitTuples splits the VALUES list of an INSERT statement into rows of
+// value literals.
+func splitTuples(values string, backslashEscapes bool) ([][]string, error) {
+	var tuples [][]string
+	var fields []string
+	var quote byte
+	escapes := false
+	depth, start := 0, 0
+	for i := 0; i < len(values); i++ {
+		c := values[i]
+		if quote != 0 {
+			switch {
+			case escapes && c == '\\':
+				i++
+			case c == quote:
+				if i+1 < len(values) && values[i+1] == quote {
+					i++
+				} else {
+					quote = 0
+				}
+			}
+			continue
+		}
+
+		switch c {
+		case '\'', '"', '`':
+			quote = c
+			escapes = c == '\'' && (backslashEscapes || endsWithEscapePrefix(values[:i]))
+		case '(':
+			depth++
+			if depth == 1 {
+				start = i + 1
+				fields = nil
+			}
+		case ')':
+			depth--
+			if depth < 0 {
+				return nil, errors.New("unbalanced parentheses in VALUES")
+			}
+			if depth == 0 {
+				tuples = append(tuples, append(fields, values[start:i]))
+			}
+		case ',':
+			if depth == 1 {
+				fields = append(fields, values[start:i])
+				start = i + 1
+			}
+		default:
+			if depth == 0 && c != ' ' && c != '\n' && c != '\r' && c != '\t' {
+				return nil, fmt.Errorf("unexpected %q between rows", c)
+			}
+		}
+	}
+	if quote != 0 || depth != 0 {
+		return nil, errors.New("unterminated row in VALUES")
+	}
+	return tuples, nil
+}
+
+// readCSVExport checks the header of a CSV export against columns and
+// returns the number of data rows and the sum of their checksums (see
+// csvRecordChecksum), decrypting it with identities if it is encrypted.
+func readCSVExport(path string, identities []age.Identity, columns []string, options CSVOptions) (int64, uint64, error) {
+	file, closer, err := openExport(path, identities)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer closer.Close()
+
+	r := bufio.NewReader(file)
+	if options.BOM {
+		if bom, _ := r.Peek(3); string(bom) == "\uFEFF" {
+			r.Discard(3)
+		}
+	}
+	reader := &csvReader{r: r, options: options}
+
+	if options.Header {
+		header, err := reader.Read()
+		if err == io.EOF {
+			return 0, 0, errors.New("empty file")
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		if strings.Join(header, ",") != strings.Join(columns, ",") {
+			return 0, 0, fmt.Errorf("header %s does not match columns %s", strings.Join(header, ","), strings.Join(columns, ","))
+		}
+	}
+
+	var rows int64
+	var checksum uint64
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, checksum, nil
+		}
+		if err != nil {
+			return rows, checksum, err
+		}
+		if len(record) != len(columns) {
+			return rows, checksum, fmt.Errorf("record %d has %d fields, table has %d columns", rows+1, len(record), len(columns))
+		}
+		checksum += csvRecordChecksum(record, reader.nulls)
+		rows++
+	}
+}
+
+// csvRecordChecksum returns the CRC32 of a decoded record, telling NULL
+// fields apart from any text.
+func csvRecordChecksum(record []string, nulls []bool) uint64 {
+	var row []byte
+	for i, field := range record {
+		if nulls[i] {
+			row = append(row, 'N')
+		} else {
+			row = append(row, 'V')
+			row = append(row, field...)
+		}
+		row = append(row, 0)
+	}
+	return uint64(crc32.ChecksumIEEE(row))
+}
+
+// encodedRowsChecksum encodes the rows of table with format, reads each one
+// back with options and sums the checksums of the records, which is what
+// readCSVExport returns for an export of the table written with format.
+func encodedRowsChecksum(ctx context.Context, db *sql.DB, dialect Dialect, table string, quotedColumns []string, format exportFormat, options CSVOptions) (uint64, error) {
+	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(quotedColumns, ", "), dialect.QuoteIdentifier(table))
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	colTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return 0, err
+	}
+	values := make([]any, len(colTypes))
+	pointers := make([]any, len(colTypes))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+
+	encode := format.NewEncoder()
+	var encoded bytes.Reader
+	reader := &csvReader{r: bufio.NewReader(&encoded), options: options}
+	var sum uint64
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return 0, err
+		}
+		row, err := encode(values, colTypes)
+		if err != nil {
+			return 0, err
+		}
+		encoded.Reset(row)
+		reader.r.Reset(&encoded)
+		record, err := reader.Read()
+		if err != nil {
+			return 0, err
+		}
+		sum += csvRecordChecksum(record, reader.nulls)
+	}
+
+	return sum, rows.Err()
+}
+
+// csvReader reads the records written by csvFormat: quoted fields with
+// doubled quotes, or with -csv-quote=none, backslash escapes. NULL markers
+// are returned as they are; after each Read, nulls tells which fields were
+// one, that is the marker neither quoted nor escaped.
+type csvReader struct {
+	r       *bufio.Reader
+	options CSVOptions
+	nulls   []bool
+}
+
+func (c *csvReader) Read() ([]string, error) {
+	delimiter := c.options.Delimiter[0]
+	var record []string
+	// raw is the unquoted field as written, before escapes are decoded,
+	// which is what NULL markers such as \N are compared with
+	var field, raw strings.Builder
+	quoted, inQuotes, started := false, false, false
+	c.nulls = c.nulls[:0]
+	// end finishes the current field
+	end := func(value string) []string {
+		rawValue := raw.String()
+		if c.options.CRLF {
+			rawValue = strings.TrimSuffix(rawValue, "\r")
+		}
+		null := !quoted && rawValue == c.options.Null
+		if null {
+			value = c.options.Null
+		}
+		c.nulls = append(c.nulls, null)
+		return append(record, value)
+	}
+
+	for {
+		b, err := c.r.ReadByte()
+		if err == io.EOF {
+			if inQuotes {
+				return nil, errors.New("unterminated quoted field")
+			}
+			if !started {
+				return nil, io.EOF
+			}
+			return end(field.String()), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		started = true
+
+		switch {
+		case inQuotes:
+			if b == '"' {
+				if next, _ := c.r.Peek(1); len(next) == 1 && next[0] == '"' {
+					c.r.ReadByte()
+					field.WriteByte('"')
+				} else {
+					inQuotes = false
+				}
+			} else {
+				field.WriteByte(b)
+			}
+		case b == delimiter:
+			record = end(field.String())
+			field.Reset()
+			raw.Reset()
+			quoted = false
+		case b == '\n':
+			if c.options.CRLF {
+				return end(strings.TrimSuffix(field.String(), "\r")), nil
+			}
+			return end(field.String()), nil
+		case b == '"' && c.options.Quote != CSVQuoteNone && field.Len() == 0 && !quoted:
+			inQuotes, quoted = true, true
+		case quoted:
+			if b != '\r' {
+				return nil, fmt.Errorf("unexpected %q after quoted field", b)
+			}
+			field.WriteByte(b)
+		case b == '\\' && c.options.Quote == CSVQuoteNone:
+			next, err := c.r.ReadByte()
+			if err != nil {
+				return nil, errors.New("unterminated escape")
+			}
+			raw.WriteByte(b)
+			raw.WriteByte(next)
+			switch next {
+			case 'n':
+				field.WriteByte('\n')
+			case 'r':
+				field.WriteByte('\r')
+			case '0':
+				field.WriteByte(0)
+			default:
+				field.WriteByte(next)
+			}
+		default:
+			field.WriteByte(b)
+			raw.WriteByte(b)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// archiveWithExports archives sms_log with SQL and CSV exports and returns
+// the check described by the manifest written with them.
+func archiveWithExports(t *testing.T) (*Config, archiveCheck) {
+	t.Helper()
+
+	db, config := openTestDB(t)
+	config.ExportSQL = true
+	config.ExportCSV = true
+	seedSMSLog(t, db, 400, 200, 100, 45, 31, 29, 10, 1)
+	mustExec(t, db, `UPDATE sms_log SET message = 'it''s a "quote", a comma; and
+a newline' WHERE id = 2`)
+	mustExec(t, db, "UPDATE sms_log SET message = NULL WHERE id = 3")
+
+	if _, err := archiveTable(context.Background(), db, &SQLiteDialect{}, config, testLogger()); err != nil {
+		t.Fatalf("archiveTable: %v", err)
+	}
+
+	path := exportFile(t, config, archiveName("sms_log"), "manifest.json")
+	manifest, err := readManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config, archiveCheck{Table: manifest.Table, Manifest: manifest, ManifestDir: filepath.Dir(path)}
+}
+
+func runVerifyArchive(t *testing.T, config *Config, check archiveCheck) []string {
+	t.Helper()
+	dialect := &SQLiteDialect{}
+	db, err := dialect.Open(context.Background(), config, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	problems, err := verifyArchive(context.Background(), db, dialect, config, check, testLogger())
+	if err != nil {
+		t.Fatalf("verifyArchive: %v", err)
+	}
+	return problems
+}
+
+func TestManifest(t *testing.T) {
+	_, check := archiveWithExports(t)
+	manifest := check.Manifest
+
+	if manifest.Table != archiveName("sms_log") || manifest.Rows != 5 {
+		t.Errorf("manifest lists %d rows of %s, want 5 rows of %s", manifest.Rows, manifest.Table, archiveName("sms_log"))
+	}
+	if got := strings.Join(manifest.Columns, ","); got != "id,msisdn,message,payload,created_at" {
+		t.Errorf("manifest lists columns %s", got)
+	}
+	if len(manifest.Files) != 2 || manifest.Files[0].Format != "sql" || manifest.Files[1].Format != "csv" {
+		t.Fatalf("manifest lists files %+v, want an SQL and a CSV export", manifest.Files)
+	}
+	for _, file := range manifest.Files {
+		if file.Rows != 5 || len(file.SHA256) != 64 {
+			t.Errorf("manifest lists %+v, want 5 rows and a SHA-256", file)
+		}
+	}
+}
+
+func TestVerifyArchive(t *testing.T) {
+	config, check := archiveWithExports(t)
+	if problems := runVerifyArchive(t, config, check); len(problems) != 0 {
+		t.Fatalf("fresh archive reported problems: %v", problems)
+	}
+
+	// The exports can also be checked on their own
+	files := check.Manifest.Files
+	bare := archiveCheck{
+		Table:    check.Table,
+		SQLFiles: []string{filepath.Join(check.ManifestDir, files[0].Name)},
+		CSVFiles: []string{filepath.Join(check.ManifestDir, files[1].Name)},
+	}
+	if problems := runVerifyArchive(t, config, bare); len(problems) != 0 {
+		t.Fatalf("exports without manifest reported problems: %v", problems)
+	}
+}
+
+func TestVerifyArchiveChangedTable(t *testing.T) {
+	config, check := archiveWithExports(t)
+
+	db, err := (&SQLiteDialect{}).Open(context.Background(), config, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustExec(t, db, `UPDATE "`+check.Table+`" SET message = 'changed' WHERE id = 1`)
+	db.Close()
+
+	problems := runVerifyArchive(t, config, check)
+	if !containsProblem(problems, "Checksum differs from the manifest") || !containsProblem(problems, "SQL export") || !containsProblem(problems, "CSV export") {
+		t.Errorf("changed row not reported, got %v", problems)
+	}
+	if containsProblem(problems, "Row count") {
+		t.Errorf("unexpected problems %v", problems)
+	}
+}
+
+func TestVerifyArchiveChangedCSVValues(t *testing.T) {
+	config, check := archiveWithExports(t)
+	csvPath := filepath.Join(check.ManifestDir, check.Manifest.Files[1].Name)
+	data, err := os.ReadFile(csvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct{ name, old, new string }{
+		{"changed value", "message 4,", "message 5,"},
+		// Without a -csv-null marker an empty string is quoted and NULL is not
+		{"NULL written as empty string", ",,", `,"",`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if !strings.Contains(string(data), tc.old) {
+				t.Fatalf("export lacks %q", tc.old)
+			}
+			changed := strings.Replace(string(data), tc.old, tc.new, 1)
+			if err := os.WriteFile(csvPath, []byte(changed), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			problems := runVerifyArchive(t, config, archiveCheck{Table: check.Table, CSVFiles: []string{csvPath}})
+			if !containsProblem(problems, "CSV export "+csvPath+" has 5 rows with checksum") {
+				t.Errorf("changed CSV not reported, got %v", problems)
+			}
+		})
+	}
+}
+
+func TestVerifyArchiveCSVOptions(t *testing.T) {
+	db, config := openTestDB(t)
+	config.ExportCSV = true
+	config.ExportLoadData = true
+	config.CSV = CSVOptions{Delimiter: ";", Quote: CSVQuoteNone, Null: `\N`, TimeLayout: "2006-01-02T15:04:05Z07:00", CRLF: true}
+	seedSMSLog(t, db, 400, 200, 100, 45, 31, 29, 10, 1)
+	mustExec(t, db, "UPDATE sms_log SET message = 'semi; colon\\ back' WHERE id = 1")
+	mustExec(t, db, "UPDATE sms_log SET message = NULL WHERE id = 3")
+
+	if _, err := archiveTable(context.Background(), db, &SQLiteDialect{}, config, testLogger()); err != nil {
+		t.Fatalf("archiveTable: %v", err)
+	}
+	path := exportFile(t, config, archiveName("sms_log"), "manifest.json")
+	manifest, err := readManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The verify flags are the defaults; the manifest records how the files were written
+	config.CSV = defaultCSVOptions()
+	check := archiveCheck{Table: manifest.Table, Manifest: manifest, ManifestDir: filepath.Dir(path)}
+	if problems := runVerifyArchive(t, config, check); len(problems) != 0 {
+		t.Errorf("CSV and TSV exports with custom options reported problems: %v", problems)
+	}
+}
+
+func TestVerifyArchiveChangedExports(t *testing.T) {
+	config, check := archiveWithExports(t)
+	sqlPath := filepath.Join(check.ManifestDir, check.Manifest.Files[0].Name)
+	csvPath := filepath.Join(check.ManifestDir, check.Manifest.Files[1].Name)
+
+	dump, err := os.ReadFile(sqlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := strings.Replace(string(dump), "'234080000000", "'234080000009", 1)
+	if err := os.WriteFile(sqlPath, []byte(changed), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	dropLastLine(t, csvPath)
+
+	problems := runVerifyArchive(t, config, check)
+	for _, want := range []string{
+		"SQL export " + sqlPath + " has 5 rows with checksum",
+		"Export " + sqlPath + " has SHA-256",
+		"CSV export " + csvPath + " has 4 rows with checksum",
+		"Export " + csvPath + " has SHA-256",
+	} {
+		if !containsProblem(problems, want) {
+			t.Errorf("missing problem %q in %v", want, problems)
+		}
+	}
+
+	if err := os.WriteFile(sqlPath, []byte(strings.TrimSuffix(strings.TrimSpace(changed), ";")+"\nINSERT INTO x VALUES ('open"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if problems := runVerifyArchive(t, config, check); !containsProblem(problems, "does not parse") {
+		t.Errorf("truncated SQL export not reported, got %v", problems)
+	}
+}
+
+func TestSplitTuples(t *testing.T) {
+	tuples, err := splitTuples("(1,'a,b',NULL),\n(2,'it\\'s (x)',X'00ff'),\n(3, E'c\\\\d','e''f')", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"1", "'a,b'", "NULL"},
+		{"2", "'it\\'s (x)'", "X'00ff'"},
+		{"3", " E'c\\\\d'", "'e''f'"},
+	}
+	if len(tuples) != len(want) {
+		t.Fatalf("got %d tuples %q, want %d", len(tuples), tuples, len(want))
+	}
+	for i := range want {
+		if strings.Join(tuples[i], "|") != strings.Join(want[i], "|") {
+			t.Errorf("tuple %d is %q, want %q", i, tuples[i], want[i])
+		}
+	}
+
+	for _, bad := range []string{"(1,'open)", "(1,2", "(1),x(2)"} {
+		if _, err := splitTuples(bad, true); err == nil {
+			t.Errorf("no error for %q", bad)
+		}
+	}
+}
+
+func containsProblem(problems []string, substr string) bool {
+	for _, p := range problems {
+		if strings.Contains(p, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func dropLastLine(t *testing.T, path string) {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	file.Close()
+	if err := os.WriteFile(path, []byte(strings.Join(lines[:len(lines)-1], "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArchiveTableVerifyChecksum(t *testing.T) {
+	db, config := openTestDB(t)
+	config.Verify = VerifyChecksum
+	config.ChunkSize = 2
+	seedSMSLog(t, db, 400, 200, 100, 45, 31, 29, 10, 1)
+
+	if _, err := archiveTable(context.Background(), db, &SQLiteDialect{}, config, testLogger()); err != nil {
+		t.Fatalf("archiveTable: %v", err)
+	}
+	if got := tableCount(t, db, archiveName("sms_log")); got != 5 {
+		t.Errorf("archive table has %d rows, want 5", got)
+	}
+}
+
+func TestVerifyChecksumsMismatch(t *testing.T) {
+	db, _ := openTestDB(t)
+	seedSMSLog(t, db, 400, 200, 100, 45, 31, 29, 10, 1)
+	mustExec(t, db, "CREATE TABLE archive (id INTEGER PRIMARY KEY, msisdn TEXT NOT NULL, message TEXT, payload BLOB, created_at DATETIME NOT NULL)")
+	mustExec(t, db, "INSERT INTO archive SELECT * FROM sms_log WHERE created_at < datetime('now', '-30 days')")
+
+	dialect := &SQLiteDialect{}
+	cutoff := time.Now().AddDate(0, 0, -30)
+	verify := func() []string {
+		t.Helper()
+		mismatches, err := verifyChecksums(context.Background(), db, dialect, "sms_log", "archive", "created_at", cutoff, 2, testLogger())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ranges []string
+		for _, mismatch := range mismatches {
+			ranges = append(ranges, mismatch.Range())
+		}
+		return ranges
+	}
+
+	if ranges := verify(); ranges != nil {
+		t.Fatalf("identical copy reported mismatches in %v", ranges)
+	}
+
+	// Rows 1-5 are archived; in chunks of 2 they fall in (-inf, 2], (2, 4]
+	// and (4, +inf]
+	mustExec(t, db, "DELETE FROM archive WHERE id = 5")
+	if ranges := verify(); !reflect.DeepEqual(ranges, []string{"(4, +inf]"}) {
+		t.Fatalf("missing row reported in %v, want (4, +inf]", ranges)
+	}
+
+	// Every differing range is reported, not just the first
+	mustExec(t, db, "UPDATE archive SET message = 'changed' WHERE id = 3")
+	if ranges := verify(); !reflect.DeepEqual(ranges, []string{"(2, 4]", "(4, +inf]"}) {
+		t.Fatalf("changed and missing rows reported in %v, want (2, 4] and (4, +inf]", ranges)
+	}
+
+	mismatches, err := verifyChecksums(context.Background(), db, dialect, "sms_log", "archive", "created_at", cutoff, 2, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed := mismatches[0]; changed.SourceRows != 2 || changed.ArchiveRows != 2 || changed.SourceChecksum == changed.ArchiveChecksum {
+		t.Errorf("changed row reported as %+v, want 2 rows on each side with different checksums", changed)
+	}
+	if missing := mismatches[1]; missing.SourceRows != 1 || missing.ArchiveRows != 0 {
+		t.Errorf("missing row reported as %+v, want 1 source row and no archived rows", missing)
+	}
+
+	// NULL and the text 'NULL' checksum differently
+	mustExec(t, db, "INSERT INTO archive SELECT * FROM sms_log WHERE id = 5")
+	mustExec(t, db, "UPDATE archive SET message = (SELECT message FROM sms_log WHERE id = 3) WHERE id = 3")
+	mustExec(t, db, "UPDATE sms_log SET message = NULL WHERE id = 1")
+	mustExec(t, db, "UPDATE archive SET message = 'NULL' WHERE id = 1")
+	if ranges := verify(); !reflect.DeepEqual(ranges, []string{"(-inf, 2]"}) {
+		t.Errorf("NULL against 'NULL' reported in %v, want (-inf, 2]", ranges)
+	}
+}
+
+func TestMismatchedRanges(t *testing.T) {
+	mismatch := func(lower, upper any) checksumMismatch {
+		return checksumMismatch{Lower: lower, Upper: upper}
+	}
+	one := []checksumMismatch{mismatch(nil, 2)}
+	if got := mismatchedRanges(one); got != "primary key range (-inf, 2]" {
+		t.Errorf("one range described as %q", got)
+	}
+
+	four := []checksumMismatch{mismatch(nil, 2), mismatch(2, 4), mismatch(4, 6), mismatch(6, nil)}
+	if got := mismatchedRanges(four); got != "4 primary key ranges: (-inf, 2], (2, 4], (4, 6], ..." {
+		t.Errorf("four ranges described as %q", got)
+	}
+
+	capped := make([]checksumMismatch, maxChecksumMismatches)
+	if got := mismatchedRanges(capped); !strings.HasPrefix(got, fmt.Sprintf("at least %d primary key ranges", maxChecksumMismatches)) {
+		t.Errorf("capped ranges described as %q", got)
+	}
+}